@@ -0,0 +1,481 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/G-Node/gin-cli/git"
+	"github.com/gogs/go-gogs-client"
+)
+
+// forkRemote is the name of the git remote of the DOI fork in the repository
+// clone of a job.
+const forkRemote = "doi"
+
+// adminJob holds the information shown to curators when reviewing a
+// registration job.
+type adminJob struct {
+	*JobRecord
+	// Content of the generated DataCite XML file
+	XML string
+	// Messages of the last review action
+	Messages []string
+}
+
+// adminHandler wraps a handler of the admin area and only calls it for
+// requests that are authenticated with the admin token. Other requests are
+// asked for HTTP basic authentication. State changing requests are only
+// accepted via POST from pages of the service itself.
+func adminHandler(conf *Configuration, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !isAdminRequest(r, conf) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="GIN DOI curation"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if origin := r.Header.Get("Origin"); origin != "" {
+				if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+					log.Printf("Rejecting admin request from origin %q", origin)
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// registerAdminHandlers adds the handlers of the curator admin area.
+func registerAdminHandlers(mux *http.ServeMux, conf *Configuration) {
+	// list of jobs waiting for review
+	mux.HandleFunc("/admin/", adminHandler(conf, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/" {
+			http.NotFound(w, r)
+			return
+		}
+		renderAdminJobList(w, r, conf)
+	}))
+	// review page of a single job
+	mux.HandleFunc("/admin/job/", adminHandler(conf, func(w http.ResponseWriter, r *http.Request) {
+		doi := strings.TrimPrefix(r.URL.Path, "/admin/job/")
+		renderAdminJob(w, r, conf, doi, nil)
+	}))
+	// preview of the landing page which is not yet publicly accessible
+	mux.HandleFunc("/admin/preview/", adminHandler(conf, func(w http.ResponseWriter, r *http.Request) {
+		doi := strings.TrimPrefix(r.URL.Path, "/admin/preview/")
+		serveStorageFile(w, r, conf, path.Join(doi, "index.html"), "text/html; charset=utf-8")
+	}))
+	mux.HandleFunc("/admin/approve/", adminHandler(conf, func(w http.ResponseWriter, r *http.Request) {
+		reviewJob(w, r, conf, strings.TrimPrefix(r.URL.Path, "/admin/approve/"), true)
+	}))
+	mux.HandleFunc("/admin/reject/", adminHandler(conf, func(w http.ResponseWriter, r *http.Request) {
+		reviewJob(w, r, conf, strings.TrimPrefix(r.URL.Path, "/admin/reject/"), false)
+	}))
+	mux.HandleFunc("/admin/withdraw/", adminHandler(conf, func(w http.ResponseWriter, r *http.Request) {
+		withdrawJob(w, r, conf, strings.TrimPrefix(r.URL.Path, "/admin/withdraw/"))
+	}))
+	mux.HandleFunc("/admin/allow-new-version/", adminHandler(conf, func(w http.ResponseWriter, r *http.Request) {
+		allowNewVersion(w, r, conf, strings.TrimPrefix(r.URL.Path, "/admin/allow-new-version/"))
+	}))
+}
+
+// renderAdminJobList renders the list of all jobs that have not been released
+// or rejected yet.
+func renderAdminJobList(w http.ResponseWriter, r *http.Request, conf *Configuration) {
+	records, err := conf.Jobs.list()
+	if err != nil {
+		log.Printf("Failed to list jobs: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	pending := make([]*JobRecord, 0, len(records))
+	for _, rec := range records {
+		if !rec.State.closed() && rec.Metadata != nil {
+			pending = append(pending, rec)
+		}
+	}
+
+	tmpl, err := prepareTemplates("AdminJobList")
+	if err != nil {
+		log.Printf("Failed to parse AdminJobList template: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tmpl = injectDynamicGINURL(tmpl, GetGINURL(conf))
+	if err := tmpl.Execute(w, pending); err != nil {
+		log.Printf("Error rendering AdminJobList template: %s", err.Error())
+	}
+}
+
+// renderAdminJob renders the review page of the job with the given ID,
+// showing the landing page preview, the warnings and errors of the
+// registration, and the generated XML file. The messages are shown as the
+// result of a previous review action.
+func renderAdminJob(w http.ResponseWriter, r *http.Request, conf *Configuration, doi string, messages []string) {
+	rec, err := conf.Jobs.get(doi)
+	if err != nil || rec.Metadata == nil {
+		http.NotFound(w, r)
+		return
+	}
+	data := adminJob{JobRecord: rec, Messages: messages}
+	if fp, err := conf.Storage.Backend.Open(path.Join(doi, "doi.xml")); err == nil {
+		xmldata, err := ioutil.ReadAll(fp)
+		fp.Close()
+		if err == nil {
+			data.XML = string(xmldata)
+		}
+	}
+
+	tmpl, err := prepareTemplates("AdminJob")
+	if err != nil {
+		log.Printf("Failed to parse AdminJob template: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tmpl = injectDynamicGINURL(tmpl, GetGINURL(conf))
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error rendering AdminJob template: %s", err.Error())
+	}
+}
+
+// serveStorageFile writes the file with the given name from the storage
+// backend to the response.
+func serveStorageFile(w http.ResponseWriter, r *http.Request, conf *Configuration, name string, contentType string) {
+	fp, err := conf.Storage.Backend.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer fp.Close()
+	w.Header().Set("Content-Type", contentType)
+	if _, err := io.Copy(w, fp); err != nil {
+		log.Printf("Failed to serve %s: %s", name, err.Error())
+	}
+}
+
+// reviewJob releases (approve) or rejects the job with the given ID and
+// renders the review page with the result.
+func reviewJob(w http.ResponseWriter, r *http.Request, conf *Configuration, doi string, approve bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rec, err := conf.Jobs.get(doi)
+	if err != nil || rec.Metadata == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if rec.State != jobDone {
+		renderAdminJob(w, r, conf, doi, []string{fmt.Sprintf("Job cannot be reviewed in state %q", rec.State)})
+		return
+	}
+
+	var messages []string
+	if approve {
+		messages = releaseJob(conf, rec)
+	} else {
+		message := strings.TrimSpace(r.PostFormValue("message"))
+		if message == "" {
+			renderAdminJob(w, r, conf, doi, []string{"A message to the requester is required to reject a request"})
+			return
+		}
+		messages = rejectJob(conf, rec, message)
+	}
+	renderAdminJob(w, r, conf, doi, messages)
+}
+
+// withdrawJob withdraws the released or embargoed dataset of the job with the
+// given ID and renders the review page with the result. The dataset is
+// removed from the keyword pages in the configured keyword directory; the
+// dataset index leaves it out when it is generated the next time.
+func withdrawJob(w http.ResponseWriter, r *http.Request, conf *Configuration, doi string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rec, err := conf.Jobs.get(doi)
+	if err != nil || rec.Metadata == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if rec.State != jobReleased && rec.State != jobEmbargoed {
+		renderAdminJob(w, r, conf, doi, []string{fmt.Sprintf("Only released datasets can be withdrawn; the job is in state %q", rec.State)})
+		return
+	}
+	reason := strings.TrimSpace(r.PostFormValue("reason"))
+	if reason == "" {
+		renderAdminJob(w, r, conf, doi, []string{"A reason is required to withdraw a dataset"})
+		return
+	}
+	messages, err := withdrawDataset(conf, doi, reason, r.PostFormValue("purge") != "")
+	if err != nil {
+		log.Printf("Failed to withdraw %s: %s", doi, err.Error())
+		messages = append(messages, fmt.Sprintf("Failed to withdraw the dataset: %s", err.Error()))
+	}
+	renderAdminJob(w, r, conf, doi, messages)
+}
+
+// releaseJob makes the dataset of a job publicly accessible, creates the DOI
+// fork and tag of the registered revision, and notifies the requester and the
+// XML repository issue. The data of embargoed datasets stay inaccessible and
+// the release is completed by the embargo scheduler (see releaseEmbargoes).
+// It returns a description of each step.
+func releaseJob(conf *Configuration, rec *JobRecord) []string {
+	doi := rec.ID
+	messages := make([]string, 0, 4)
+	if err := conf.Storage.Backend.SetPublic(doi, true); err != nil {
+		log.Printf("Failed to release %s: %s", doi, err.Error())
+		return append(messages, fmt.Sprintf("Failed to remove the access restriction: %s", err.Error()))
+	}
+	messages = append(messages, "Access restriction removed")
+	landingpage := landingPageURL(conf, doi)
+	if conf.DataCite != nil {
+		if err := conf.DataCite.Publish(doi, landingpage); err != nil {
+			log.Printf("Failed to publish %s at DataCite: %s", doi, err.Error())
+			messages = append(messages, fmt.Sprintf("Failed to make the DOI findable at DataCite; this needs to be done manually: %s", err.Error()))
+		} else {
+			messages = append(messages, "DOI is findable at DataCite")
+		}
+	}
+
+	if isEmbargoed(rec.Metadata, time.Now()) {
+		available := EmbargoDate(rec.Metadata)
+		conf.Jobs.review(doi, jobEmbargoed, "")
+		log.Printf("Released %s under embargo until %s", doi, available)
+		messages = append(messages, fmt.Sprintf("The data are embargoed until %s; the archive and the DOI fork are released automatically", available))
+
+		job := &RegistrationJob{Metadata: rec.Metadata, Config: conf}
+		issuetext := fmt.Sprintf("Dataset released under embargo until %s: %s", available, landingpage)
+		if _, err := createIssue(job, issuetext, conf); err != nil {
+			messages = append(messages, fmt.Sprintf("Failed to comment on the XML repository issue: %s", err.Error()))
+		}
+		if err := notifyReview(job, fmt.Sprintf(msgEmbargoedEmail, requesterName(job), rec.Metadata.SourceRepository, doi, landingpage, available)); err != nil {
+			messages = append(messages, fmt.Sprintf("Failed to notify the requester: %s", err.Error()))
+		} else {
+			messages = append(messages, "Requester notified")
+		}
+		return messages
+	}
+	return append(messages, completeRelease(conf, rec)...)
+}
+
+// completeRelease makes the data of a released job available: it releases the
+// archive of a dataset whose embargo has ended, creates the DOI fork and tag
+// of the registered revision, and notifies the requester and the XML
+// repository issue. It returns a description of each step.
+func completeRelease(conf *Configuration, rec *JobRecord) []string {
+	doi := rec.ID
+	messages := make([]string, 0, 4)
+	landingpage := landingPageURL(conf, doi)
+	embargoed := rec.State == jobEmbargoed
+	if _, ok := embargoDate(rec.Metadata); ok {
+		liftmsgs, err := liftEmbargo(conf, doi)
+		messages = append(messages, liftmsgs...)
+		if err != nil {
+			log.Printf("Failed to lift the embargo of %s: %s", doi, err.Error())
+			return append(messages, fmt.Sprintf("Failed to lift the embargo: %s", err.Error()))
+		}
+	}
+	conf.Jobs.review(doi, jobReleased, "")
+	log.Printf("Released %s", doi)
+
+	if err := finaliseFork(conf, rec); err != nil {
+		log.Printf("Failed to finalise the DOI fork of %s: %s", doi, err.Error())
+		messages = append(messages, fmt.Sprintf("Failed to finalise the DOI fork; this needs to be done manually: %s", err.Error()))
+	} else {
+		messages = append(messages, fmt.Sprintf("Pushed revision and tag to %s", rec.Metadata.ForkRepository))
+	}
+
+	job := &RegistrationJob{Metadata: rec.Metadata, Config: conf}
+	issuetext := fmt.Sprintf("Dataset released: %s", landingpage)
+	email := fmt.Sprintf(msgReleasedEmail, requesterName(job), rec.Metadata.SourceRepository, doi, landingpage)
+	if embargoed {
+		issuetext = fmt.Sprintf("Embargo ended, data released: %s", landingpage)
+		email = fmt.Sprintf(msgEmbargoEndedEmail, requesterName(job), rec.Metadata.SourceRepository, doi, landingpage)
+	}
+	if _, err := createIssue(job, issuetext, conf); err != nil {
+		messages = append(messages, fmt.Sprintf("Failed to comment on the XML repository issue: %s", err.Error()))
+	}
+	if err := notifyReview(job, email); err != nil {
+		messages = append(messages, fmt.Sprintf("Failed to notify the requester: %s", err.Error()))
+	} else {
+		messages = append(messages, "Requester notified")
+	}
+	return messages
+}
+
+// rejectJob marks a job as rejected and sends the message of the curator to
+// the requester and the XML repository issue. The dataset stays inaccessible.
+// It returns a description of each step.
+func rejectJob(conf *Configuration, rec *JobRecord, message string) []string {
+	doi := rec.ID
+	messages := make([]string, 0, 3)
+	conf.Jobs.review(doi, jobRejected, message)
+	log.Printf("Rejected %s", doi)
+	messages = append(messages, "Request rejected")
+
+	job := &RegistrationJob{Metadata: rec.Metadata, Config: conf}
+	issuetext := fmt.Sprintf("Request rejected:\n\n%s", message)
+	if _, err := createIssue(job, issuetext, conf); err != nil {
+		messages = append(messages, fmt.Sprintf("Failed to comment on the XML repository issue: %s", err.Error()))
+	}
+	if err := notifyReview(job, fmt.Sprintf(msgRejectedEmail, requesterName(job), rec.Metadata.SourceRepository, doi, message)); err != nil {
+		messages = append(messages, fmt.Sprintf("Failed to notify the requester: %s", err.Error()))
+	} else {
+		messages = append(messages, "Requester notified")
+	}
+	return messages
+}
+
+// notifyReview sends the result of the review of a job to the requesting
+// user.
+func notifyReview(job *RegistrationJob, message string) error {
+	user := job.Metadata.RequestingUser
+	if user == nil || user.Email == "" {
+		return fmt.Errorf("no email address for the requester")
+	}
+	subject := fmt.Sprintf("DOI registration request: %s", job.Metadata.SourceRepository)
+	return sendMail([]string{user.Email}, subject, message, job.Config)
+}
+
+// requesterName returns the name used to address the requesting user of a job
+// in notifications.
+func requesterName(job *RegistrationJob) string {
+	user := job.Metadata.RequestingUser
+	if user == nil {
+		return ""
+	}
+	if user.RealName != "" {
+		return user.RealName
+	}
+	return user.Username
+}
+
+// finaliseFork pushes the registered revision of a job from the repository
+// clone in the preparation directory to the DOI fork of the repository and
+// tags it with the DOI. The fork is created if it does not exist.
+func finaliseFork(conf *Configuration, rec *JobRecord) error {
+	repoparts := strings.SplitN(rec.Metadata.SourceRepository, "/", 2)
+	forkparts := strings.SplitN(rec.Metadata.ForkRepository, "/", 2)
+	if len(repoparts) != 2 || len(forkparts) != 2 {
+		return fmt.Errorf("invalid repository %q or fork %q", rec.Metadata.SourceRepository, rec.Metadata.ForkRepository)
+	}
+	repodir := filepath.Join(conf.Storage.PreparationDirectory, rec.ID, strings.ToLower(repoparts[1]))
+	if _, err := os.Stat(repodir); err != nil {
+		return fmt.Errorf("repository clone not available: %s", err.Error())
+	}
+
+	client := conf.GIN.Session
+	if _, err := client.GetRepo(rec.Metadata.ForkRepository); err != nil {
+		log.Printf("Creating DOI fork %s", rec.Metadata.ForkRepository)
+		if err := createForkRepo(conf, forkparts[1], rec); err != nil {
+			return err
+		}
+	}
+
+	// The commands run in the clone directory instead of changing the working
+	// directory of the process, which the workers use while processing jobs.
+
+	// Replace a remote left by a previous attempt
+	_ = runGitIn(repodir, "remote", "remove", forkRemote)
+	if err := runGitIn(repodir, "remote", "add", forkRemote, fmt.Sprintf("%s/%s", client.GitAddress(), rec.Metadata.ForkRepository)); err != nil {
+		return fmt.Errorf("failed to add fork remote: %s", err.Error())
+	}
+
+	revision := rec.Commit
+	if revision == "" {
+		revision = "HEAD"
+	}
+	if err := runGitIn(repodir, "tag", "--force", rec.ID, revision); err != nil {
+		return fmt.Errorf("failed to create tag: %s", err.Error())
+	}
+	if err := runGitIn(repodir, "push", forkRemote, revision+":refs/heads/master", "refs/tags/"+rec.ID); err != nil {
+		return fmt.Errorf("failed to push to fork: %s", err.Error())
+	}
+
+	// Push the git-annex branch and upload the annexed content
+	if err := runAnnexIn(repodir, "sync", "--no-pull", "--no-commit", forkRemote); err != nil {
+		return fmt.Errorf("failed to push annex branch: %s", err.Error())
+	}
+	if err := runAnnexIn(repodir, "copy", "--all", "--to="+forkRemote); err != nil {
+		return fmt.Errorf("failed to upload annexed content: %s", err.Error())
+	}
+	return nil
+}
+
+// runGitIn runs a git command in the given repository directory. The error
+// contains the error output of the command.
+func runGitIn(dir string, args ...string) error {
+	cmd := git.Command(args...)
+	cmd.Dir = dir
+	if _, stderr, err := cmd.OutputError(); err != nil {
+		return fmt.Errorf("%s (%s)", strings.TrimSpace(string(stderr)), err.Error())
+	}
+	return nil
+}
+
+// runAnnexIn runs a git annex command in the given repository directory. The
+// error contains the error output of the command.
+func runAnnexIn(dir string, args ...string) error {
+	cmd := git.AnnexCommand(args...)
+	cmd.Dir = dir
+	if _, stderr, err := cmd.OutputError(); err != nil {
+		return fmt.Errorf("%s (%s)", strings.TrimSpace(string(stderr)), err.Error())
+	}
+	return nil
+}
+
+// createForkRepo creates the public repository with the given name for the DOI
+// fork of a job under the service user.
+func createForkRepo(conf *Configuration, name string, rec *JobRecord) error {
+	data := gogs.CreateRepoOption{
+		Name:        name,
+		Description: fmt.Sprintf("Published dataset %s (DOI %s)", rec.Metadata.SourceRepository, rec.ID),
+		Private:     false,
+	}
+	resp, err := conf.GIN.Session.Post("/api/v1/user/repos", data)
+	if err != nil {
+		return fmt.Errorf("failed to create fork repository: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to create fork repository: [%d] %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// allowNewVersion allows a new submission of the repository revision of a job,
+// which would otherwise be rejected as a duplicate, e.g., to register an
+// intentional new version.
+func allowNewVersion(w http.ResponseWriter, r *http.Request, conf *Configuration, doi string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rec, err := conf.Jobs.get(doi)
+	if err != nil || rec.Metadata == nil {
+		http.NotFound(w, r)
+		return
+	}
+	conf.Jobs.allowNewVersion(doi)
+	log.Printf("New registrations of %s allowed for job %s", rec.Metadata.SourceRepository, doi)
+	renderAdminJob(w, r, conf, doi, []string{fmt.Sprintf("The repository %s can be submitted again", rec.Metadata.SourceRepository)})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestAdminReview(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_admin")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	conf := &Configuration{Jobs: store, AdminToken: "secret"}
+	conf.GIN.Session = ginclient.New("")
+	conf.Storage.PreparationDirectory = filepath.Join(tmpDir, "prep")
+	conf.Storage.StoreURL = "https://doi.example.org"
+	conf.Storage.Backend = NewLocalStorage(filepath.Join(tmpDir, "target"))
+
+	mux := http.NewServeMux()
+	registerAdminHandlers(mux, conf)
+
+	dois := []string{"10.12751/g-node.aaaaaa", "10.12751/g-node.bbbbbb"}
+	for _, doi := range dois {
+		job := newTestJob(doi, "owner/repo")
+		job.Metadata.ForkRepository = "doi/repo"
+		job.Metadata.RequestingUser = &libgin.GINUser{Username: "owner", Email: "owner@example.org"}
+		if err := store.add(job); err != nil {
+			t.Fatalf("Error adding job: %v", err)
+		}
+		store.finish(doi, jobDone, nil, []string{"Abstract may be too short: 10 characters"})
+		if err := conf.Storage.Backend.MkdirAll(doi); err != nil {
+			t.Fatalf("Error creating dataset directory: %v", err)
+		}
+		if err := conf.Storage.Backend.SetPublic(doi, false); err != nil {
+			t.Fatalf("Error restricting dataset directory: %v", err)
+		}
+	}
+
+	request := func(method, target string, form url.Values, token string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if form != nil {
+			req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req = httptest.NewRequest(method, target, nil)
+		}
+		if token != "" {
+			req.SetBasicAuth("curator", token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Unauthenticated requests are asked to log in
+	rec := request(http.MethodGet, "/admin/", nil, "")
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("Unexpected response for unauthenticated request: %d", rec.Code)
+	}
+	rec = request(http.MethodPost, "/admin/approve/"+dois[0], nil, "wrong")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Unexpected response for request with wrong token: %d", rec.Code)
+	}
+
+	// Pending jobs are listed
+	rec = request(http.MethodGet, "/admin/", nil, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code for job list: %d", rec.Code)
+	}
+	for _, doi := range dois {
+		if !strings.Contains(rec.Body.String(), doi) {
+			t.Fatalf("Job %s missing from job list", doi)
+		}
+	}
+
+	// Review page shows the warnings
+	rec = request(http.MethodGet, "/admin/job/"+dois[0], nil, "secret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Abstract may be too short") {
+		t.Fatalf("Unexpected review page: [%d] %s", rec.Code, rec.Body.String())
+	}
+
+	// Approving requires POST
+	rec = request(http.MethodGet, "/admin/approve/"+dois[0], nil, "secret")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Unexpected status code for GET approval: %d", rec.Code)
+	}
+
+	// Approve: access restriction is removed; the fork cannot be created
+	// without a repository clone, which is reported to the curator
+	rec = request(http.MethodPost, "/admin/approve/"+dois[0], url.Values{}, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code for approval: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Failed to finalise the DOI fork") {
+		t.Fatalf("Fork failure not reported: %s", rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "target", dois[0], ".htaccess")); !os.IsNotExist(err) {
+		t.Fatalf("Access restriction of released dataset was not removed: %v", err)
+	}
+	if jobrec, _ := store.get(dois[0]); jobrec.State != jobReleased {
+		t.Fatalf("Unexpected state of released job: %s", jobrec.State)
+	}
+
+	// Released datasets can be withdrawn and are removed from the keyword
+	// pages
+	kwdir := filepath.Join(tmpDir, "keywords")
+	if err := os.Mkdir(kwdir, 0777); err != nil {
+		t.Fatalf("Error creating keyword directory: %v", err)
+	}
+	kwindex := keywordIndex{
+		"neuroscience": {{Keyword: "Neuroscience", DOI: dois[0], Title: "Released"}, {Keyword: "Neuroscience", DOI: dois[1], Title: "Other"}},
+		"ephys":        {{Keyword: "Ephys", DOI: dois[0], Title: "Released"}},
+	}
+	if err := updateKeywordPages(kwdir, kwindex, []string{"neuroscience", "ephys"}); err != nil {
+		t.Fatalf("Error creating keyword pages: %v", err)
+	}
+	conf.KeywordDirectory = kwdir
+	xmldata, _ := libgin.NewDataCiteFromYAML(&libgin.RepositoryYAML{Title: "Released", License: &libgin.License{Name: "CC-BY"}}).Marshal()
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "target", dois[0], "doi.xml"), []byte(xmldata), 0666); err != nil {
+		t.Fatalf("Error writing XML: %v", err)
+	}
+	rec = request(http.MethodPost, "/admin/withdraw/"+dois[0], url.Values{"reason": {""}}, "secret")
+	if jobrec, _ := store.get(dois[0]); jobrec.State != jobReleased {
+		t.Fatalf("Dataset was withdrawn without a reason: %s", jobrec.State)
+	}
+	rec = request(http.MethodPost, "/admin/withdraw/"+dois[0], url.Values{"reason": {"Duplicate"}}, "secret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "tombstone page") {
+		t.Fatalf("Unexpected withdrawal response: [%d] %s", rec.Code, rec.Body.String())
+	}
+	if jobrec, _ := store.get(dois[0]); jobrec.State != jobWithdrawn {
+		t.Fatalf("Unexpected state of withdrawn job: %s", jobrec.State)
+	}
+	if !strings.Contains(rec.Body.String(), "Removed from 2 keyword page(s)") {
+		t.Errorf("Keyword page update not reported: %s", rec.Body.String())
+	}
+	if kwindex, err := readKeywordIndex(kwdir); err != nil || len(kwindex) != 1 || len(kwindex["neuroscience"]) != 1 || kwindex["neuroscience"][0].DOI != dois[1] {
+		t.Errorf("Withdrawn dataset not removed from the keyword index: %+v (%v)", kwindex, err)
+	}
+	if _, err := os.Stat(filepath.Join(kwdir, "ephys", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("Page of unused keyword was not removed: %v", err)
+	}
+	if page, err := ioutil.ReadFile(filepath.Join(kwdir, "neuroscience", "index.html")); err != nil || strings.Contains(string(page), dois[0]) {
+		t.Errorf("Withdrawn dataset still listed on the keyword page: %v", err)
+	}
+	request(http.MethodPost, "/admin/withdraw/"+dois[1], url.Values{"reason": {"Duplicate"}}, "secret")
+	if jobrec, _ := store.get(dois[1]); jobrec.State != jobDone {
+		t.Fatalf("Unreleased job was withdrawn: %s", jobrec.State)
+	}
+
+	// A new version of a job waiting for review can be allowed
+	if rec := request(http.MethodGet, "/admin/job/"+dois[1], nil, "secret"); !strings.Contains(rec.Body.String(), "/admin/allow-new-version/"+dois[1]) {
+		t.Fatalf("Review page does not offer a new version: %s", rec.Body.String())
+	}
+	rec = request(http.MethodPost, "/admin/allow-new-version/"+dois[1], url.Values{}, "secret")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "can be submitted again") {
+		t.Fatalf("Unexpected response for allowing a new version: [%d] %s", rec.Code, rec.Body.String())
+	}
+	if jobrec, _ := store.get(dois[1]); !jobrec.AllowNewVersion || jobrec.State != jobDone {
+		t.Fatalf("New version was not allowed: %+v", jobrec)
+	}
+
+	// Reject without a message is refused
+	rec = request(http.MethodPost, "/admin/reject/"+dois[1], url.Values{"message": {" "}}, "secret")
+	if jobrec, _ := store.get(dois[1]); jobrec.State != jobDone {
+		t.Fatalf("Job was rejected without a message: %s", jobrec.State)
+	}
+
+	// Reject: dataset stays restricted
+	rec = request(http.MethodPost, "/admin/reject/"+dois[1], url.Values{"message": {"License file missing"}}, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code for rejection: %d", rec.Code)
+	}
+	jobrec, _ := store.get(dois[1])
+	if jobrec.State != jobRejected || jobrec.ReviewMessage != "License file missing" {
+		t.Fatalf("Unexpected rejected job: %+v", jobrec)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "target", dois[1], ".htaccess")); err != nil {
+		t.Fatalf("Access restriction of rejected dataset was removed: %v", err)
+	}
+
+	// Reviewed jobs cannot be reviewed again and are no longer listed
+	request(http.MethodPost, "/admin/approve/"+dois[1], url.Values{}, "secret")
+	if jobrec, _ := store.get(dois[1]); jobrec.State != jobRejected {
+		t.Fatalf("Rejected job was reviewed again: %s", jobrec.State)
+	}
+	rec = request(http.MethodGet, "/admin/", nil, "secret")
+	if strings.Contains(rec.Body.String(), dois[0]) || strings.Contains(rec.Body.String(), dois[1]) {
+		t.Fatalf("Reviewed jobs are still listed: %s", rec.Body.String())
+	}
+
+	// Cross-origin form submissions are refused
+	req := httptest.NewRequest(http.MethodPost, "/admin/approve/"+dois[0], nil)
+	req.SetBasicAuth("curator", "secret")
+	req.Header.Set("Origin", "https://evil.example.org")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Unexpected status code for cross-origin request: %d", rec.Code)
+	}
+}
+
+func TestRunGitIn(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_rungit")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origdir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory: %v", err)
+	}
+	repodir := filepath.Join(tmpDir, "repo")
+	if err := os.Mkdir(repodir, 0777); err != nil {
+		t.Fatalf("Error creating repository directory: %v", err)
+	}
+	if err := runGitIn(repodir, "init", "--quiet"); err != nil {
+		t.Fatalf("Error running git init: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repodir, ".git")); err != nil {
+		t.Errorf("Command did not run in the repository directory: %v", err)
+	}
+	if wd, _ := os.Getwd(); wd != origdir {
+		t.Errorf("Working directory changed to %s", wd)
+	}
+	// The error output is reported
+	if err := runGitIn(repodir, "tag", "v1", "nosuchrevision"); err == nil || !strings.Contains(err.Error(), "nosuchrevision") {
+		t.Errorf("Unexpected error for failing command: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+	humanize "github.com/dustin/go-humanize"
+)
+
+// Supported archive packaging modes.
+const (
+	// Plain zip file of the repository content
+	packagingZip = "zip"
+	// BagIt bag serialised as a zip file
+	packagingBagItZip = "bagit-zip"
+	// BagIt bag serialised as a tar file
+	packagingBagItTar = "bagit-tar"
+)
+
+// bagitDeclaration is the content of the bagit.txt file of every bag.
+const bagitDeclaration = "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n"
+
+// isValidPackaging returns true if the given packaging mode is supported.
+func isValidPackaging(packaging string) bool {
+	switch packaging {
+	case packagingZip, packagingBagItZip, packagingBagItTar:
+		return true
+	}
+	return false
+}
+
+// isBagIt returns true if the given packaging mode creates a BagIt bag.
+func isBagIt(packaging string) bool {
+	return packaging == packagingBagItZip || packaging == packagingBagItTar
+}
+
+// archiveExtension returns the file extension of archives created with the
+// given packaging mode.
+func archiveExtension(packaging string) string {
+	if packaging == packagingBagItTar {
+		return ".tar"
+	}
+	return ".zip"
+}
+
+// archiveWriter adds files to an archive file of a specific format.
+type archiveWriter interface {
+	// writeFile adds a regular file with the given name, modification time
+	// and size, reading the file content from r.
+	writeFile(name string, modtime time.Time, size int64, r io.Reader) error
+	Close() error
+}
+
+// zipArchiveWriter writes uncompressed zip archives.
+type zipArchiveWriter struct {
+	*zip.Writer
+}
+
+func (w zipArchiveWriter) writeFile(name string, modtime time.Time, size int64, r io.Reader) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Store, Modified: modtime}
+	header.SetMode(0644)
+	fw, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+// tarArchiveWriter writes tar archives.
+type tarArchiveWriter struct {
+	*tar.Writer
+}
+
+func (w tarArchiveWriter) writeFile(name string, modtime time.Time, size int64, r io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modtime, Typeflag: tar.TypeReg}
+	if err := w.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// runbag creates a BagIt bag of the source directory and serialises it to
+// the file with the given name in the storage backend, using the format of
+// the packaging mode. The bag name is the base name of the archive file
+// without the extension. Any directories or files handed over via the exclude
+// parameter are not added to the bag. It returns the name, size, and checksum
+// of the archive and the checksums of the payload files.
+func runbag(storage StorageBackend, source, archivefilename string, exclude []string, metadata *libgin.RepositoryMetadata, packaging string) (*ManifestEntry, []ManifestEntry, error) {
+	fn := fmt.Sprintf("runbag(%s, %s)", source, archivefilename) // keep original args for errmsg
+	source, err := filepath.Abs(source)
+	if err != nil {
+		log.Printf("%s: Failed to get abs path for source directory in function '%s': %v", lpStorage, fn, err)
+		return nil, nil, err
+	}
+
+	fp, err := storage.Create(archivefilename)
+	if err != nil {
+		log.Printf("%s: Failed to create archive file for writing in function '%s': %v", lpStorage, fn, err)
+		return nil, nil, err
+	}
+	// Discard the incomplete file if anything goes wrong
+	stored := false
+	defer func() {
+		if !stored {
+			if err := abortFile(storage, archivefilename, fp); err != nil {
+				log.Printf("%s: Failed to discard incomplete file in function '%s': %v", lpStorage, fn, err)
+			}
+		}
+	}()
+
+	archivename := path.Base(archivefilename)
+	bagname := strings.TrimSuffix(archivename, path.Ext(archivename))
+	bagwriter := newChecksumWriter(fp)
+	files, err := MakeBag(bagwriter, packaging, bagname, metadata, exclude, source)
+	if err != nil {
+		log.Printf("%s: Failed to create bag in function '%s': %v", lpStorage, fn, err)
+		return nil, nil, err
+	}
+	if err := fp.Close(); err != nil {
+		log.Printf("%s: Failed to store bag in function '%s': %v", lpStorage, fn, err)
+		return nil, nil, err
+	}
+	stored = true
+
+	archive := bagwriter.entry(archivename)
+	return &archive, files, nil
+}
+
+// MakeBag writes a BagIt bag (RFC 8493) with the given name to the dest
+// io.Writer, serialised in the format of the packaging mode. All files found
+// under the source directory are added to the payload directory of the bag,
+// except the directories and files specified via the exclude parameter.
+// Symlinks are not followed and the link target is stored as file content,
+// like in MakeZip. The bag-info.txt file is populated from the repository
+// metadata. It returns the checksums of the payload files.
+func MakeBag(dest io.Writer, packaging string, bagname string, metadata *libgin.RepositoryMetadata, exclude []string, source string) ([]ManifestEntry, error) {
+	if _, err := os.Stat(source); err != nil {
+		return nil, fmt.Errorf("cannot access '%s': %s", source, err.Error())
+	}
+
+	var writer archiveWriter
+	switch packaging {
+	case packagingBagItZip:
+		writer = zipArchiveWriter{zip.NewWriter(dest)}
+	case packagingBagItTar:
+		writer = tarArchiveWriter{tar.NewWriter(dest)}
+	default:
+		return nil, fmt.Errorf("unsupported bag packaging %q", packaging)
+	}
+	defer writer.Close()
+
+	files := make([]ManifestEntry, 0)
+	walker := func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relpath, err := filepath.Rel(source, fpath)
+		if err != nil {
+			return err
+		}
+		for i := range exclude {
+			if exclude[i] == relpath {
+				return filepath.SkipDir
+			}
+		}
+		if fi.Mode().IsDir() {
+			return nil
+		}
+
+		var content io.Reader
+		size := fi.Size()
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(fpath)
+			if err != nil {
+				return err
+			}
+			content = strings.NewReader(target)
+			size = int64(len(target))
+		} else {
+			f, err := os.Open(fpath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			content = f
+		}
+
+		relpath = filepath.ToSlash(relpath)
+		hash := sha256.New()
+		name := path.Join(bagname, "data", relpath)
+		if err := writer.writeFile(name, fi.ModTime(), size, io.TeeReader(content, hash)); err != nil {
+			return err
+		}
+		files = append(files, ManifestEntry{Path: relpath, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))})
+		return nil
+	}
+	if err := filepath.Walk(source, walker); err != nil {
+		return nil, fmt.Errorf("error adding %s to bag: %s", source, err.Error())
+	}
+
+	now := time.Now()
+	tagfiles := []struct {
+		name    string
+		content string
+	}{
+		{"bagit.txt", bagitDeclaration},
+		{"bag-info.txt", bagInfo(metadata, files, now)},
+		{"manifest-sha256.txt", bagManifest(files)},
+	}
+	var tagmanifest strings.Builder
+	for _, tf := range tagfiles {
+		checksum, size, _ := sha256Sum(strings.NewReader(tf.content))
+		fmt.Fprintf(&tagmanifest, "%s  %s\n", checksum, tf.name)
+		if err := writer.writeFile(path.Join(bagname, tf.name), now, size, strings.NewReader(tf.content)); err != nil {
+			return nil, fmt.Errorf("error adding %s to bag: %s", tf.name, err.Error())
+		}
+	}
+	tagcontent := tagmanifest.String()
+	if err := writer.writeFile(path.Join(bagname, "tagmanifest-sha256.txt"), now, int64(len(tagcontent)), strings.NewReader(tagcontent)); err != nil {
+		return nil, fmt.Errorf("error adding tagmanifest-sha256.txt to bag: %s", err.Error())
+	}
+
+	return files, nil
+}
+
+// bagManifest returns the content of the manifest-sha256.txt payload
+// manifest for the given payload files.
+func bagManifest(files []ManifestEntry) string {
+	var manifest strings.Builder
+	for _, entry := range files {
+		fmt.Fprintf(&manifest, "%s  data/%s\n", entry.SHA256, bagEncodePath(entry.Path))
+	}
+	return manifest.String()
+}
+
+// bagEncodePath percent-encodes the characters that are not allowed in the
+// file paths of BagIt manifests (CR, LF and %).
+func bagEncodePath(fpath string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(fpath)
+}
+
+// bagInfo returns the content of the bag-info.txt file for a bag of the
+// given dataset and payload files.
+func bagInfo(metadata *libgin.RepositoryMetadata, files []ManifestEntry, now time.Time) string {
+	var info strings.Builder
+	addLine := func(label, value string) {
+		// Collapse line breaks and repeated whitespace into single spaces
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			fmt.Fprintf(&info, "%s: %s\n", label, value)
+		}
+	}
+
+	var octets int64
+	for _, entry := range files {
+		octets += entry.Size
+	}
+
+	addLine("Source-Organization", "German Neuroinformatics Node (G-Node)")
+	addLine("Bagging-Date", now.Format("2006-01-02"))
+	if metadata.DataCite != nil {
+		if metadata.Identifier.ID != "" {
+			addLine("External-Identifier", "https://doi.org/"+metadata.Identifier.ID)
+		}
+		if len(metadata.Titles) > 0 {
+			addLine("Title", metadata.Titles[0])
+		}
+		for _, creator := range metadata.Creators {
+			addLine("Author", creator.Name)
+		}
+		for _, desc := range metadata.Descriptions {
+			if desc.Type == "Abstract" {
+				addLine("External-Description", desc.Content)
+				break
+			}
+		}
+		for _, date := range metadata.Dates {
+			addLine("Date-"+date.Type, date.Value)
+		}
+		addLine("Publication-Year", fmt.Sprintf("%d", metadata.Year))
+	}
+	if metadata.SourceRepository != "" {
+		addLine("Internal-Sender-Identifier", metadata.SourceRepository)
+	}
+	addLine("Bag-Size", humanize.IBytes(uint64(octets)))
+	addLine("Payload-Oxum", fmt.Sprintf("%d.%d", octets, len(files)))
+	return info.String()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestMakeBag(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_bagit")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	source := filepath.Join(tmpDir, "repo")
+	if err := os.MkdirAll(filepath.Join(source, ".git"), 0755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	files := map[string]string{
+		"README.md":     "readme",
+		".git/excluded": "not archived",
+	}
+	for fname, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(source, fname), []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing file %s: %v", fname, err)
+		}
+	}
+
+	datacite := libgin.NewDataCite()
+	metadata := &libgin.RepositoryMetadata{DataCite: &datacite}
+	metadata.Identifier.ID = "10.12751/g-node.aaaaaa"
+	metadata.Titles = []string{"Test dataset"}
+	metadata.Creators = []libgin.Creator{{Name: "Doe, Jane"}, {Name: "Doe, John"}}
+	metadata.SourceRepository = "owner/repo"
+
+	exclude := []string{".git"}
+	for _, packaging := range []string{packagingBagItZip, packagingBagItTar} {
+		target := filepath.Join(tmpDir, packaging)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			t.Fatalf("Error creating directory: %v", err)
+		}
+		storage := NewLocalStorage(target)
+		archivefile := filepath.Join(target, "10.12751_g-node.aaaaaa"+archiveExtension(packaging))
+		archive, entries, err := runbag(storage, source, filepath.Base(archivefile), exclude, metadata, packaging)
+		if err != nil {
+			t.Fatalf("[%s] Error creating bag: %v", packaging, err)
+		}
+		if len(entries) != 1 || entries[0].Path != "README.md" {
+			t.Fatalf("[%s] Unexpected payload files: %+v", packaging, entries)
+		}
+
+		contents := make(map[string]string)
+		walker := func(name string, r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			contents[name] = string(data)
+			return err
+		}
+		if err := walkArchive(archivefile, walker); err != nil {
+			t.Fatalf("[%s] Error reading bag: %v", packaging, err)
+		}
+		for _, fname := range []string{"bagit.txt", "bag-info.txt", "manifest-sha256.txt", "tagmanifest-sha256.txt", "data/README.md"} {
+			if _, ok := contents["10.12751_g-node.aaaaaa/"+fname]; !ok {
+				t.Fatalf("[%s] Bag is missing file %q: %v", packaging, fname, contents)
+			}
+		}
+		baginfo := contents["10.12751_g-node.aaaaaa/bag-info.txt"]
+		for _, line := range []string{
+			"External-Identifier: https://doi.org/10.12751/g-node.aaaaaa\n",
+			"Title: Test dataset\n",
+			"Author: Doe, Jane\n",
+			"Author: Doe, John\n",
+			"Payload-Oxum: 6.1\n",
+		} {
+			if !strings.Contains(baginfo, line) {
+				t.Fatalf("[%s] bag-info.txt is missing line %q:\n%s", packaging, line, baginfo)
+			}
+		}
+		manifestline := entries[0].SHA256 + "  data/README.md\n"
+		if contents["10.12751_g-node.aaaaaa/manifest-sha256.txt"] != manifestline {
+			t.Fatalf("[%s] Unexpected payload manifest: %q", packaging, contents["10.12751_g-node.aaaaaa/manifest-sha256.txt"])
+		}
+
+		checksum, size, err := sha256File(archivefile)
+		if err != nil {
+			t.Fatalf("[%s] Error reading bag: %v", packaging, err)
+		}
+		if archive.SHA256 != checksum || archive.Size != size {
+			t.Fatalf("[%s] Archive entry %+v does not match the stored bag (%d bytes, %s)", packaging, archive, size, checksum)
+		}
+
+		manifest := createManifest(metadata.Identifier.ID, *archive, packaging, entries)
+		if problems := verifyArchiveFiles(archivefile, manifest); len(problems) != 0 {
+			t.Fatalf("[%s] Unexpected problems verifying bag: %v", packaging, problems)
+		}
+	}
+}
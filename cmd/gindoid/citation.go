@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+// citationFormat describes a citation export format. The citation files of
+// each format are written next to the landing page of a dataset.
+type citationFormat struct {
+	// Name of the format for the cite command
+	Name string
+	// Label of the download button on the landing page
+	Label string
+	// Filename of the citation file in the dataset directory
+	Filename string
+	// render returns the citation of the dataset in the format
+	render func(md *libgin.RepositoryMetadata) ([]byte, error)
+}
+
+// citationFormats lists the supported citation export formats in the order
+// they are shown on the landing page.
+var citationFormats = []citationFormat{
+	{Name: "bibtex", Label: "BibTeX", Filename: "citation.bib", render: formatBibTeX},
+	{Name: "ris", Label: "RIS", Filename: "citation.ris", render: formatRIS},
+	{Name: "csl-json", Label: "CSL-JSON", Filename: "citation.json", render: formatCSLJSON},
+	{Name: "datacite-json", Label: "DataCite JSON", Filename: "datacite.json", render: formatDataCiteJSON},
+}
+
+// CitationFormats returns the supported citation export formats.
+// This is a utility function for the landing page HTML template.
+func CitationFormats() []citationFormat {
+	return citationFormats
+}
+
+// getCitationFormat returns the citation format with the given name.
+func getCitationFormat(name string) (citationFormat, error) {
+	names := make([]string, len(citationFormats))
+	for idx, cf := range citationFormats {
+		if cf.Name == strings.ToLower(name) {
+			return cf, nil
+		}
+		names[idx] = cf.Name
+	}
+	return citationFormat{}, fmt.Errorf("unknown citation format %q (supported formats: %s)", name, strings.Join(names, ", "))
+}
+
+// writeCitations renders the citation files of a dataset in all supported
+// formats to the given directory in the storage backend.
+func writeCitations(storage StorageBackend, md *libgin.RepositoryMetadata, targetdir string) error {
+	for _, cf := range citationFormats {
+		data, err := cf.render(md)
+		if err != nil {
+			return fmt.Errorf("failed to render %s citation: %s", cf.Label, err.Error())
+		}
+		fp, err := storage.Create(path.Join(targetdir, cf.Filename))
+		if err != nil {
+			log.Printf("Could not create the citation file %s: %s", cf.Filename, err.Error())
+			return err
+		}
+		_, err = fp.Write(data)
+		if closeerr := fp.Close(); err == nil {
+			err = closeerr
+		}
+		if err != nil {
+			log.Printf("Could not write the citation file %s: %s", cf.Filename, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// citationName holds the name of a dataset creator split into family and
+// given names. Names that are not of the form "LastName, FirstName" are kept
+// as a single literal name.
+type citationName struct {
+	Family  string
+	Given   string
+	Literal string
+}
+
+// citationNames splits the creator names of a dataset.
+func citationNames(md *libgin.RepositoryMetadata) []citationName {
+	names := make([]citationName, len(md.Creators))
+	for idx, author := range md.Creators {
+		// Author names are LastName, FirstName
+		namesplit := strings.SplitN(author.Name, ",", 2)
+		if len(namesplit) != 2 {
+			names[idx] = citationName{Literal: strings.TrimSpace(author.Name)}
+			continue
+		}
+		names[idx] = citationName{Family: strings.TrimSpace(namesplit[0]), Given: strings.TrimSpace(namesplit[1])}
+	}
+	return names
+}
+
+// citationTitle returns the main title of a dataset.
+func citationTitle(md *libgin.RepositoryMetadata) string {
+	if len(md.Titles) == 0 {
+		return ""
+	}
+	return md.Titles[0]
+}
+
+// citationAbstract returns the abstract of a dataset.
+func citationAbstract(md *libgin.RepositoryMetadata) string {
+	for _, desc := range md.Descriptions {
+		if desc.Type == "Abstract" {
+			return desc.Content
+		}
+	}
+	return ""
+}
+
+// citationKeywords returns the keywords of a dataset.
+func citationKeywords(md *libgin.RepositoryMetadata) []string {
+	if md.Subjects == nil {
+		return nil
+	}
+	return *md.Subjects
+}
+
+// issuedDate returns the issued date of a dataset (YYYY-MM-DD), or an empty
+// string if the metadata does not contain the date.
+func issuedDate(md *libgin.RepositoryMetadata) string {
+	for _, mddate := range md.Dates {
+		if mddate.Type == "Issued" {
+			return mddate.Value
+		}
+	}
+	return ""
+}
+
+// dateParts returns the numeric components of the issued date of a dataset,
+// falling back to the publication year.
+func dateParts(md *libgin.RepositoryMetadata) []int {
+	var parts []int
+	for _, part := range strings.Split(issuedDate(md), "-") {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+		parts = append(parts, num)
+	}
+	if len(parts) == 0 && md.Year != 0 {
+		parts = []int{md.Year}
+	}
+	return parts
+}
+
+// bibtexEscaper escapes the characters with a special meaning in BibTeX.
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"{", `\{`,
+	"}", `\}`,
+	"&", `\&`,
+	"%", `\%`,
+	"$", `\$`,
+	"#", `\#`,
+	"_", `\_`,
+	"~", `\textasciitilde{}`,
+	"^", `\textasciicircum{}`,
+)
+
+// bibtexKeyRE matches the characters that are replaced in BibTeX entry keys.
+var bibtexKeyRE = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// formatBibTeX renders the citation of a dataset as a BibTeX entry.
+func formatBibTeX(md *libgin.RepositoryMetadata) ([]byte, error) {
+	names := citationNames(md)
+	authors := make([]string, len(names))
+	for idx, name := range names {
+		if name.Literal != "" {
+			// Protect names that cannot be split
+			authors[idx] = "{" + bibtexEscaper.Replace(name.Literal) + "}"
+			continue
+		}
+		authors[idx] = bibtexEscaper.Replace(name.Family + ", " + name.Given)
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "@misc{%s,\n", bibtexKeyRE.ReplaceAllString(md.Identifier.ID, "_"))
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(buf, "  %-9s = {%s},\n", name, value)
+		}
+	}
+	field("author", strings.Join(authors, " and "))
+	// Double braces preserve the capitalisation of the title
+	if title := citationTitle(md); title != "" {
+		field("title", "{"+bibtexEscaper.Replace(title)+"}")
+	}
+	field("publisher", bibtexEscaper.Replace(md.Publisher))
+	if md.Year != 0 {
+		field("year", strconv.Itoa(md.Year))
+	}
+	field("doi", bibtexEscaper.Replace(md.Identifier.ID))
+	if md.Identifier.ID != "" {
+		field("url", doiURL(md.Identifier.ID))
+	}
+	field("keywords", bibtexEscaper.Replace(strings.Join(citationKeywords(md), ", ")))
+	if len(md.RightsList) > 0 {
+		field("copyright", bibtexEscaper.Replace(md.RightsList[0].Name))
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+// formatRIS renders the citation of a dataset in the RIS format.
+func formatRIS(md *libgin.RepositoryMetadata) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	tag := func(name, value string) {
+		// Values must be on a single line
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			fmt.Fprintf(buf, "%s  - %s\n", name, value)
+		}
+	}
+	tag("TY", "DATA")
+	for _, name := range citationNames(md) {
+		if name.Literal != "" {
+			tag("AU", name.Literal)
+			continue
+		}
+		tag("AU", name.Family+", "+name.Given)
+	}
+	tag("TI", citationTitle(md))
+	if md.Year != 0 {
+		tag("PY", strconv.Itoa(md.Year))
+	}
+	tag("DA", strings.ReplaceAll(issuedDate(md), "-", "/"))
+	tag("PB", md.Publisher)
+	tag("DO", md.Identifier.ID)
+	if md.Identifier.ID != "" {
+		tag("UR", doiURL(md.Identifier.ID))
+	}
+	tag("AB", citationAbstract(md))
+	for _, kw := range citationKeywords(md) {
+		tag("KW", kw)
+	}
+	tag("ET", md.Version)
+	tag("LA", md.Language)
+	buf.WriteString("ER  - \n")
+	return buf.Bytes(), nil
+}
+
+// cslName is a name in CSL-JSON.
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+// cslDate is a date in CSL-JSON.
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// cslItem is a CSL-JSON citation item.
+type cslItem struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Title     string    `json:"title,omitempty"`
+	Author    []cslName `json:"author,omitempty"`
+	Issued    *cslDate  `json:"issued,omitempty"`
+	Publisher string    `json:"publisher,omitempty"`
+	DOI       string    `json:"DOI,omitempty"`
+	URL       string    `json:"URL,omitempty"`
+	Abstract  string    `json:"abstract,omitempty"`
+	Keyword   string    `json:"keyword,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Language  string    `json:"language,omitempty"`
+}
+
+// formatCSLJSON renders the citation of a dataset as CSL-JSON. The output is
+// an array with a single item, which is accepted by all citeproc
+// implementations.
+func formatCSLJSON(md *libgin.RepositoryMetadata) ([]byte, error) {
+	item := cslItem{
+		ID:        md.Identifier.ID,
+		Type:      "dataset",
+		Title:     citationTitle(md),
+		Publisher: md.Publisher,
+		DOI:       md.Identifier.ID,
+		Abstract:  citationAbstract(md),
+		Keyword:   strings.Join(citationKeywords(md), ", "),
+		Version:   md.Version,
+		Language:  md.Language,
+	}
+	if md.Identifier.ID != "" {
+		item.URL = doiURL(md.Identifier.ID)
+	}
+	for _, name := range citationNames(md) {
+		item.Author = append(item.Author, cslName(name))
+	}
+	if parts := dateParts(md); len(parts) > 0 {
+		item.Issued = &cslDate{DateParts: [][]int{parts}}
+	}
+	data, err := json.MarshalIndent([]cslItem{item}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// DataCite JSON representation of the metadata; the attributes correspond to
+// the ones of the DataCite REST API.
+type dcjNameIdentifier struct {
+	NameIdentifier       string `json:"nameIdentifier"`
+	NameIdentifierScheme string `json:"nameIdentifierScheme,omitempty"`
+	SchemeURI            string `json:"schemeUri,omitempty"`
+}
+
+type dcjAffiliation struct {
+	Name string `json:"name"`
+}
+
+type dcjCreator struct {
+	Name            string              `json:"name"`
+	NameType        string              `json:"nameType,omitempty"`
+	GivenName       string              `json:"givenName,omitempty"`
+	FamilyName      string              `json:"familyName,omitempty"`
+	NameIdentifiers []dcjNameIdentifier `json:"nameIdentifiers,omitempty"`
+	Affiliation     []dcjAffiliation    `json:"affiliation,omitempty"`
+}
+
+type dcjTitle struct {
+	Title string `json:"title"`
+}
+
+type dcjSubject struct {
+	Subject string `json:"subject"`
+}
+
+type dcjContributor struct {
+	Name            string `json:"name"`
+	ContributorType string `json:"contributorType"`
+}
+
+type dcjDate struct {
+	Date     string `json:"date"`
+	DateType string `json:"dateType"`
+}
+
+type dcjTypes struct {
+	ResourceTypeGeneral string `json:"resourceTypeGeneral,omitempty"`
+	ResourceType        string `json:"resourceType,omitempty"`
+}
+
+type dcjRelatedIdentifier struct {
+	RelatedIdentifier     string `json:"relatedIdentifier"`
+	RelatedIdentifierType string `json:"relatedIdentifierType"`
+	RelationType          string `json:"relationType"`
+}
+
+type dcjRights struct {
+	Rights    string `json:"rights"`
+	RightsURI string `json:"rightsUri,omitempty"`
+}
+
+type dcjDescription struct {
+	Description     string `json:"description"`
+	DescriptionType string `json:"descriptionType"`
+}
+
+type dcjFundingReference struct {
+	FunderName           string `json:"funderName"`
+	FunderIdentifier     string `json:"funderIdentifier,omitempty"`
+	FunderIdentifierType string `json:"funderIdentifierType,omitempty"`
+	AwardNumber          string `json:"awardNumber,omitempty"`
+}
+
+type dcjResource struct {
+	ID                 string                 `json:"id,omitempty"`
+	DOI                string                 `json:"doi,omitempty"`
+	Creators           []dcjCreator           `json:"creators"`
+	Titles             []dcjTitle             `json:"titles"`
+	Publisher          string                 `json:"publisher,omitempty"`
+	PublicationYear    int                    `json:"publicationYear,omitempty"`
+	Subjects           []dcjSubject           `json:"subjects,omitempty"`
+	Contributors       []dcjContributor       `json:"contributors,omitempty"`
+	Dates              []dcjDate              `json:"dates,omitempty"`
+	Language           string                 `json:"language,omitempty"`
+	Types              dcjTypes               `json:"types"`
+	RelatedIdentifiers []dcjRelatedIdentifier `json:"relatedIdentifiers,omitempty"`
+	Sizes              []string               `json:"sizes,omitempty"`
+	Version            string                 `json:"version,omitempty"`
+	RightsList         []dcjRights            `json:"rightsList,omitempty"`
+	Descriptions       []dcjDescription       `json:"descriptions,omitempty"`
+	FundingReferences  []dcjFundingReference  `json:"fundingReferences,omitempty"`
+	SchemaVersion      string                 `json:"schemaVersion"`
+}
+
+// formatDataCiteJSON renders the metadata of a dataset in the DataCite JSON
+// format.
+func formatDataCiteJSON(md *libgin.RepositoryMetadata) ([]byte, error) {
+	res := dcjResource{
+		DOI:             md.Identifier.ID,
+		Publisher:       md.Publisher,
+		PublicationYear: md.Year,
+		Language:        md.Language,
+		Types:           dcjTypes{ResourceTypeGeneral: md.ResourceType.General, ResourceType: md.ResourceType.Value},
+		Version:         md.Version,
+		SchemaVersion:   "http://datacite.org/schema/kernel-4",
+		Creators:        []dcjCreator{},
+		Titles:          []dcjTitle{},
+	}
+	if md.Identifier.ID != "" {
+		res.ID = doiURL(md.Identifier.ID)
+	}
+	names := citationNames(md)
+	for idx, creator := range md.Creators {
+		dcc := dcjCreator{Name: creator.Name, NameType: "Personal", GivenName: names[idx].Given, FamilyName: names[idx].Family}
+		if creator.Identifier != nil && creator.Identifier.ID != "" {
+			dcc.NameIdentifiers = []dcjNameIdentifier{{
+				NameIdentifier:       creator.Identifier.ID,
+				NameIdentifierScheme: creator.Identifier.Scheme,
+				SchemeURI:            creator.Identifier.SchemeURI,
+			}}
+		}
+		if creator.Affiliation != "" {
+			dcc.Affiliation = []dcjAffiliation{{Name: creator.Affiliation}}
+		}
+		res.Creators = append(res.Creators, dcc)
+	}
+	for _, title := range md.Titles {
+		res.Titles = append(res.Titles, dcjTitle{Title: title})
+	}
+	for _, kw := range citationKeywords(md) {
+		res.Subjects = append(res.Subjects, dcjSubject{Subject: kw})
+	}
+	for _, contributor := range md.Contributors {
+		res.Contributors = append(res.Contributors, dcjContributor{Name: contributor.Name, ContributorType: contributor.Type})
+	}
+	for _, mddate := range md.Dates {
+		res.Dates = append(res.Dates, dcjDate{Date: mddate.Value, DateType: mddate.Type})
+	}
+	for _, relid := range md.RelatedIdentifiers {
+		res.RelatedIdentifiers = append(res.RelatedIdentifiers, dcjRelatedIdentifier{
+			RelatedIdentifier:     relid.Identifier,
+			RelatedIdentifierType: relid.Type,
+			RelationType:          relid.RelationType,
+		})
+	}
+	if md.Sizes != nil {
+		res.Sizes = *md.Sizes
+	}
+	for _, rights := range md.RightsList {
+		res.RightsList = append(res.RightsList, dcjRights{Rights: rights.Name, RightsURI: rights.URL})
+	}
+	for _, desc := range md.Descriptions {
+		res.Descriptions = append(res.Descriptions, dcjDescription{Description: desc.Content, DescriptionType: desc.Type})
+	}
+	if md.FundingReferences != nil {
+		for _, funding := range *md.FundingReferences {
+			ref := dcjFundingReference{FunderName: funding.Funder, AwardNumber: funding.AwardNumber}
+			if funding.Identifier != nil {
+				ref.FunderIdentifier = funding.Identifier.ID
+				ref.FunderIdentifierType = funding.Identifier.Type
+			}
+			res.FundingReferences = append(res.FundingReferences, ref)
+		}
+	}
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
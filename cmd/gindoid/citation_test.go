@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func testCitationMetadata() *libgin.RepositoryMetadata {
+	yamldata := &libgin.RepositoryYAML{
+		Authors: []libgin.Author{
+			{FirstName: "Alice", LastName: "Doe", Affiliation: "G-Node", ID: "ORCID:0000-0002-1825-0097"},
+			{FirstName: "Bob", LastName: "O'Roe"},
+		},
+		Title:        "Spikes & {Waves} in 100% of_cases",
+		Description:  "Recordings\nand analysis",
+		Keywords:     []string{"Neuroscience", "Electrophysiology"},
+		License:      &libgin.License{Name: "CC-BY", URL: "https://creativecommons.org/licenses/by/4.0/"},
+		Funding:      []string{"DFG; 12345"},
+		ResourceType: "Dataset",
+	}
+	metadata := &libgin.RepositoryMetadata{
+		YAMLData: yamldata,
+		DataCite: libgin.NewDataCiteFromYAML(yamldata),
+	}
+	metadata.Identifier.ID = "10.12751/g-node.aaaaaa"
+	metadata.Dates = []libgin.Date{{Value: "2020-03-14", Type: "Issued"}}
+	metadata.Year = 2020
+	return metadata
+}
+
+func TestCitationFormats(t *testing.T) {
+	metadata := testCitationMetadata()
+
+	bibtex, err := formatBibTeX(metadata)
+	if err != nil {
+		t.Fatalf("Error rendering BibTeX: %v", err)
+	}
+	for _, line := range []string{
+		"@misc{10_12751_g_node_aaaaaa,",
+		"  author    = {Doe, Alice and O'Roe, Bob},",
+		`  title     = {{Spikes \& \{Waves\} in 100\% of\_cases}},`,
+		"  year      = {2020},",
+		"  doi       = {10.12751/g-node.aaaaaa},",
+		"  url       = {https://doi.org/10.12751/g-node.aaaaaa},",
+		"  keywords  = {Neuroscience, Electrophysiology},",
+	} {
+		if !strings.Contains(string(bibtex), line+"\n") {
+			t.Errorf("BibTeX line missing: %s\n%s", line, bibtex)
+		}
+	}
+
+	ris, err := formatRIS(metadata)
+	if err != nil {
+		t.Fatalf("Error rendering RIS: %v", err)
+	}
+	for _, line := range []string{
+		"TY  - DATA",
+		"AU  - Doe, Alice",
+		"AU  - O'Roe, Bob",
+		"DA  - 2020/03/14",
+		"DO  - 10.12751/g-node.aaaaaa",
+		"AB  - Recordings and analysis",
+		"KW  - Electrophysiology",
+	} {
+		if !strings.Contains(string(ris), line+"\n") {
+			t.Errorf("RIS line missing: %s\n%s", line, ris)
+		}
+	}
+	if !strings.HasPrefix(string(ris), "TY  - DATA\n") || !strings.HasSuffix(string(ris), "ER  - \n") {
+		t.Errorf("Invalid RIS record:\n%s", ris)
+	}
+
+	csl, err := formatCSLJSON(metadata)
+	if err != nil {
+		t.Fatalf("Error rendering CSL-JSON: %v", err)
+	}
+	var items []cslItem
+	if err := json.Unmarshal(csl, &items); err != nil {
+		t.Fatalf("Invalid CSL-JSON: %v", err)
+	}
+	if len(items) != 1 || items[0].Type != "dataset" || items[0].DOI != metadata.Identifier.ID || len(items[0].Author) != 2 || items[0].Author[1].Family != "O'Roe" {
+		t.Errorf("Unexpected CSL-JSON: %s", csl)
+	}
+	if items[0].Issued == nil || len(items[0].Issued.DateParts) != 1 || len(items[0].Issued.DateParts[0]) != 3 || items[0].Issued.DateParts[0][1] != 3 {
+		t.Errorf("Unexpected CSL-JSON date: %+v", items[0].Issued)
+	}
+
+	dcj, err := formatDataCiteJSON(metadata)
+	if err != nil {
+		t.Fatalf("Error rendering DataCite JSON: %v", err)
+	}
+	var resource dcjResource
+	if err := json.Unmarshal(dcj, &resource); err != nil {
+		t.Fatalf("Invalid DataCite JSON: %v", err)
+	}
+	if resource.DOI != metadata.Identifier.ID || resource.PublicationYear != 2020 || len(resource.Titles) != 1 || resource.Titles[0].Title != metadata.Titles[0] {
+		t.Errorf("Unexpected DataCite JSON: %s", dcj)
+	}
+	if len(resource.Creators) != 2 || len(resource.Creators[0].NameIdentifiers) != 1 || resource.Creators[0].NameIdentifiers[0].NameIdentifier != "0000-0002-1825-0097" {
+		t.Errorf("Unexpected DataCite JSON creators: %+v", resource.Creators)
+	}
+	if len(resource.FundingReferences) != 1 || resource.FundingReferences[0].AwardNumber != "12345" {
+		t.Errorf("Unexpected DataCite JSON funding: %+v", resource.FundingReferences)
+	}
+
+	if _, err := getCitationFormat("BibTeX"); err != nil {
+		t.Errorf("Error getting citation format: %v", err)
+	}
+	if _, err := getCitationFormat("endnote"); err == nil {
+		t.Error("Unknown citation format did not fail")
+	}
+}
+
+func TestWriteCitations(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_citation")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	metadata := testCitationMetadata()
+	storage := NewLocalStorage(tmpDir)
+	doi := metadata.Identifier.ID
+	if err := storage.MkdirAll(doi); err != nil {
+		t.Fatalf("Error creating dataset directory: %v", err)
+	}
+	if err := writeCitations(storage, metadata, doi); err != nil {
+		t.Fatalf("Error writing citation files: %v", err)
+	}
+	for _, cf := range citationFormats {
+		if _, err := os.Stat(filepath.Join(tmpDir, doi, cf.Filename)); err != nil {
+			t.Errorf("Citation file %s missing: %v", cf.Filename, err)
+		}
+	}
+
+	// The landing page links the citation files
+	if err := createLandingPage(storage, metadata, filepath.Join(doi, "index.html"), "", ""); err != nil {
+		t.Fatalf("Error creating landing page: %v", err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(tmpDir, doi, "index.html"))
+	if err != nil {
+		t.Fatalf("Error reading landing page: %v", err)
+	}
+	for _, cf := range citationFormats {
+		if !strings.Contains(string(page), `href="`+cf.Filename+`"`) {
+			t.Errorf("Landing page does not link %s", cf.Filename)
+		}
+	}
+}
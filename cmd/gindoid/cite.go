@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// cite reads the provided XML files or URLs and prints the citation of each
+// dataset in the requested format.
+func cite(cmd *cobra.Command, args []string) {
+	formatname, _ := cmd.Flags().GetString("format")
+	format, err := getCitationFormat(formatname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	var failed int
+	for _, filearg := range args {
+		metadata, err := readMetadataXML(filearg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			failed++
+			continue
+		}
+		data, err := format.render(metadata)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to render citation for %q: %s\n", filearg, err.Error())
+			failed++
+			continue
+		}
+		os.Stdout.Write(data)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/G-Node/gin-cli/ginclient"
 	"github.com/G-Node/gin-cli/ginclient/config"
 	"github.com/G-Node/gin-cli/git"
 	"github.com/G-Node/libgin/libgin"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
 )
 
 // Configuration is used to store and pass the configuration settings
@@ -19,6 +29,9 @@ type Configuration struct {
 	Port uint16
 	// The encryption key, shared with GIN Web for verification
 	Key string
+	// Token for curators and scripts to access the job details; access is
+	// disabled if empty
+	AdminToken string
 	// Processing queue length and max concurrent workers
 	MaxQueue   int
 	MaxWorkers int
@@ -39,9 +52,16 @@ type Configuration struct {
 		// File path with email addresses to which notifications are sent
 		RecipientsFile string
 	}
+	// DataCite registers the DOIs through the DataCite REST API; nil if no
+	// DataCite account is configured, in which case the DOIs are registered
+	// manually by the curators
+	DataCite *DataCiteClient
 	// XMLRepo is the repository where the registered dataset XML files are
 	// stored
 	XMLRepo string
+	// KeywordDirectory is the directory of the keyword pages and index; if
+	// set, withdrawn datasets are removed from the keyword pages right away
+	KeywordDirectory string
 	// Settings related to the storage location for published data and landing
 	// pages
 	Storage struct {
@@ -55,17 +75,437 @@ type Configuration struct {
 		// Used in email notification for convenient XML file retrieval (SCP
 		// format host:/path/)
 		XMLURL string
+		// Backend where the published datasets are stored; the local
+		// backend stores them in the TargetDirectory
+		Backend StorageBackend
+		// Archive packaging mode: plain zip file ("zip"), or BagIt bag
+		// serialised as zip ("bagit-zip") or tar ("bagit-tar") file
+		Packaging string
 	}
+	// Jobs keeps track of registration jobs across service restarts
+	Jobs *JobStore
+	// Requests keeps track of the submitted registration requests so that
+	// they cannot be submitted again
+	Requests *RequestStore
+	// Time after which the encrypted registration requests issued by GIN
+	// expire
+	RequestMaxAge time.Duration
+	// Time after which the records of finished jobs are removed
+	JobRetention time.Duration
+	// Time during which a new submission of a registered repository revision
+	// is rejected as a duplicate
+	DuplicateWindow time.Duration
+	// Maximum number of requests per minute to the registration pages from a
+	// single client address, maximum number of submissions per hour by a
+	// single GIN user, and maximum number of open registrations of a single
+	// GIN user; 0 disables the limit
+	RateLimit     int
+	UserRateLimit int
+	MaxOpenJobs   int
+	// clientLimiter and userLimiter enforce the rate limits; they are kept
+	// when the configuration is reloaded
+	clientLimiter *rateLimiter
+	userLimiter   *rateLimiter
+	// Metrics collects the processing statistics exported on the /metrics
+	// endpoint
+	Metrics *Metrics
+	// Minimum free space in bytes in the preparation and target directories
+	// for the service to report itself as ready
+	MinFreeSpace uint64
+	// Time the service waits for running jobs to finish when shutting down;
+	// jobs that are still running afterwards are resumed on the next start
+	ShutdownTimeout time.Duration
+	// settings are the values the configuration was created from; compared
+	// with the new values when the configuration is reloaded
+	settings configValues
 }
 
-// loadconfig reads all the configuration variables (from the environment).
+// configFileName is the name of the optional configuration file that is read
+// from the configuration directory if no file is specified via the
+// 'configfile' environment variable.
+const configFileName = "gindoid.yml"
+
+// secretFileSuffix is appended to the name of a setting to read its value from
+// a file instead, e.g., a Docker secret mounted in /run/secrets.
+const secretFileSuffix = "_file"
+
+// configSetting describes a configuration variable of the service.
+type configSetting struct {
+	// Name of the variable in the environment and the configuration file
+	Name string
+	// Value used if the variable is not set
+	Default string
+	// Required variables must have a non-empty value
+	Required bool
+	// Secret values are masked when the configuration is printed
+	Secret bool
+	// Check returns an error if a non-empty value is invalid
+	Check func(string) error
+	// Restart settings only take effect when the service is restarted; all
+	// others are applied when the configuration is reloaded
+	Restart bool
+}
+
+// configSettings lists all configuration variables read by loadconfig in the
+// order in which they are printed by the 'config check' command.
+var configSettings = []configSetting{
+	{Name: "port", Default: "10443", Check: checkPort, Restart: true},
+	{Name: "key", Required: true, Secret: true, Check: checkKey},
+	{Name: "admintoken", Secret: true},
+	{Name: "maxqueue", Default: "100", Check: checkPositive, Restart: true},
+	{Name: "maxworkers", Default: "3", Check: checkPositive},
+	{Name: "ginurl", Required: true, Check: checkWebURL, Restart: true},
+	{Name: "giturl", Required: true, Check: checkGitURL, Restart: true},
+	{Name: "ginuser", Required: true, Restart: true},
+	{Name: "ginpassword", Required: true, Secret: true, Restart: true},
+	{Name: "doibase", Required: true},
+	{Name: "mailserver", Check: checkHostPort},
+	{Name: "mailfrom", Check: checkAddress},
+	{Name: "mailtofile", Check: checkFile},
+	{Name: "preparation", Required: true, Check: checkDirectory, Restart: true},
+	{Name: "target", Check: checkDirectory, Restart: true},
+	{Name: "storeurl", Required: true, Check: checkURL},
+	{Name: "xmlurl"},
+	{Name: "xmlrepo"},
+	{Name: "keyworddir", Check: checkDirectory},
+	{Name: "packaging", Default: packagingZip, Check: checkPackaging},
+	{Name: "storage", Default: storageLocal, Check: checkStorage, Restart: true},
+	{Name: "s3endpoint", Check: checkURL, Restart: true},
+	{Name: "s3region", Default: "us-east-1", Restart: true},
+	{Name: "s3bucket", Restart: true},
+	{Name: "s3accesskey", Secret: true, Restart: true},
+	{Name: "s3secretkey", Secret: true, Restart: true},
+	{Name: "dataciteuser"},
+	{Name: "datacitepassword", Secret: true},
+	{Name: "dataciteurl", Default: dataciteURL, Check: checkURL},
+	{Name: "datacitetest", Default: "false", Check: checkBool},
+	{Name: "datacitedryrun", Default: "false", Check: checkBool},
+	{Name: "jobretention", Default: "90", Check: checkPositive},
+	{Name: "requestmaxage", Default: "24", Check: checkPositive},
+	{Name: "duplicatewindow", Default: "24", Check: checkNonNegative},
+	{Name: "ratelimit", Default: "30", Check: checkNonNegative},
+	{Name: "userratelimit", Default: "5", Check: checkNonNegative},
+	{Name: "maxopenjobs", Default: "3", Check: checkNonNegative},
+	{Name: "shutdowntimeout", Default: "300", Check: checkNonNegative},
+	{Name: "minfreespace", Default: "1024", Check: checkNonNegative},
+}
+
+// configValue is the effective value of a setting and the place it was read
+// from.
+type configValue struct {
+	Value  string
+	Source string
+}
+
+// configValues maps the names of the settings to their effective values.
+type configValues map[string]configValue
+
+// get returns the effective value of a setting.
+func (values configValues) get(name string) string {
+	return values[name].Value
+}
+
+// readConfigValues determines the effective value of each setting. Variables
+// in the environment take precedence over the configuration file. A setting
+// that is set in neither can be read from the file named by the same variable
+// with the '_file' suffix. Unset settings get their default value. Errors
+// while reading the files and unknown settings in the configuration file are
+// returned as problems.
+func readConfigValues() (configValues, []string) {
+	var problems []string
+	filevalues, path, err := readConfigFile()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	known := make(map[string]bool, 2*len(configSettings))
+	values := make(configValues, len(configSettings))
+	for _, setting := range configSettings {
+		known[setting.Name] = true
+		known[setting.Name+secretFileSuffix] = true
+		value, err := resolveSetting(setting, filevalues, path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", setting.Name, err.Error()))
+		}
+		values[setting.Name] = value
+	}
+
+	var unknown []string
+	for name := range filevalues {
+		if !known[name] {
+			unknown = append(unknown, fmt.Sprintf("unknown setting %q in %s", name, path))
+		}
+	}
+	sort.Strings(unknown)
+	return values, append(problems, unknown...)
+}
+
+// resolveSetting returns the effective value of a single setting from the
+// environment, the given values of the configuration file, the secret files,
+// or the default, in that order.
+func resolveSetting(setting configSetting, filevalues map[string]string, path string) (configValue, error) {
+	secretname := setting.Name + secretFileSuffix
+	if value, ok := os.LookupEnv(setting.Name); ok {
+		return configValue{value, "environment"}, nil
+	}
+	if secretpath, ok := os.LookupEnv(secretname); ok {
+		value, err := readSecretFile(secretpath)
+		return configValue{value, fmt.Sprintf("environment (%s)", secretpath)}, err
+	}
+	if value, ok := filevalues[setting.Name]; ok {
+		return configValue{value, path}, nil
+	}
+	if secretpath, ok := filevalues[secretname]; ok {
+		value, err := readSecretFile(secretpath)
+		return configValue{value, fmt.Sprintf("%s (%s)", path, secretpath)}, err
+	}
+	if setting.Default != "" {
+		return configValue{setting.Default, "default"}, nil
+	}
+	return configValue{"", "not set"}, nil
+}
+
+// readSecretFile returns the contents of a file holding a single setting
+// without surrounding whitespace.
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read value from file: %s", err.Error())
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readConfigFile reads the YAML configuration file specified via the
+// 'configfile' environment variable or, if not specified, the gindoid.yml
+// file in the configuration directory if it exists. The file maps the names of
+// the settings to their values. It returns the values and the path of the
+// file; both are empty if there is no configuration file.
+func readConfigFile() (map[string]string, string, error) {
+	path, explicit := os.LookupEnv("configfile")
+	if !explicit {
+		path = filepath.Join(libgin.ReadConf("configdir"), configFileName)
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, path, fmt.Errorf("failed to read configuration file: %s", err.Error())
+	}
+
+	rawvalues := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &rawvalues); err != nil {
+		return nil, path, fmt.Errorf("failed to parse configuration file %s: %s", path, err.Error())
+	}
+	values := make(map[string]string, len(rawvalues))
+	for name, value := range rawvalues {
+		switch value.(type) {
+		case nil:
+			values[name] = ""
+		case map[interface{}]interface{}, []interface{}:
+			return nil, path, fmt.Errorf("invalid configuration file %s: setting %q is not a single value", path, name)
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, path, nil
+}
+
+// validate checks the values of all settings and returns every problem found.
+func (values configValues) validate() []string {
+	var problems []string
+	require := func(name, reason string) {
+		if values.get(name) == "" {
+			problems = append(problems, fmt.Sprintf("%s: required %s", name, reason))
+		}
+	}
+	for _, setting := range configSettings {
+		value := values.get(setting.Name)
+		if value == "" {
+			if setting.Required {
+				require(setting.Name, "setting is not set")
+			}
+			continue
+		}
+		if setting.Check == nil {
+			continue
+		}
+		if err := setting.Check(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", setting.Name, err.Error()))
+		}
+	}
+
+	switch values.get("storage") {
+	case storageLocal:
+		require("target", "for the local storage backend")
+	case storageS3:
+		for _, name := range []string{"s3endpoint", "s3bucket", "s3accesskey", "s3secretkey"} {
+			require(name, "for the S3 storage backend")
+		}
+	}
+	if values.get("dataciteuser") != "" {
+		require("datacitepassword", "if dataciteuser is set")
+	}
+	return problems
+}
+
+// String returns the effective configuration with one setting per line and
+// the values of secret settings masked.
+func (values configValues) String() string {
+	var b strings.Builder
+	for _, setting := range configSettings {
+		value := values[setting.Name]
+		shown := value.Value
+		if setting.Secret && shown != "" {
+			shown = "[HIDDEN]"
+		}
+		fmt.Fprintf(&b, "%-18s %-40s %s\n", setting.Name, shown, value.Source)
+	}
+	return b.String()
+}
+
+func checkPort(value string) error {
+	if port, err := strconv.ParseUint(value, 10, 16); err != nil || port == 0 {
+		return fmt.Errorf("invalid port %q", value)
+	}
+	return nil
+}
+
+// checkKey checks that the encryption key shared with GIN Web is a valid AES
+// key.
+func checkKey(value string) error {
+	switch len(value) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("key must be 16, 24, or 32 bytes long, not %d", len(value))
+}
+
+func checkPositive(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 1 {
+		return fmt.Errorf("expected a positive number, not %q", value)
+	}
+	return nil
+}
+
+func checkNonNegative(value string) error {
+	if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+		return fmt.Errorf("expected a non-negative number, not %q", value)
+	}
+	return nil
+}
+
+func checkBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("expected true or false, not %q", value)
+	}
+	return nil
+}
+
+// checkURL checks that the value is an absolute HTTP(S) URL.
+func checkURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q: expected http(s)://host[:port][/path]", value)
+	}
+	return nil
+}
+
+// checkWebURL checks the address of the GIN web server, which must include
+// the port.
+func checkWebURL(value string) error {
+	if err := checkURL(value); err != nil {
+		return err
+	}
+	if _, err := config.ParseWebString(value); err != nil {
+		return fmt.Errorf("invalid GIN URL %q: expected http(s)://host:port", value)
+	}
+	return nil
+}
+
+// checkGitURL checks the address of the GIN git server.
+func checkGitURL(value string) error {
+	if _, err := config.ParseGitString(value); err != nil {
+		return fmt.Errorf("invalid git address %q: expected user@host:port", value)
+	}
+	return nil
+}
+
+func checkHostPort(value string) error {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || host == "" || checkPort(port) != nil {
+		return fmt.Errorf("invalid mail server address %q: expected host:port", value)
+	}
+	return nil
+}
+
+func checkAddress(value string) error {
+	if _, err := mail.ParseAddress(value); err != nil {
+		return fmt.Errorf("invalid email address %q: %s", value, err.Error())
+	}
+	return nil
+}
+
+func checkFile(value string) error {
+	info, err := os.Stat(value)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", value)
+	}
+	return nil
+}
+
+func checkDirectory(value string) error {
+	info, err := os.Stat(value)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", value)
+	}
+	return nil
+}
+
+func checkPackaging(value string) error {
+	if !isValidPackaging(value) {
+		return fmt.Errorf("unknown archive packaging mode %q", value)
+	}
+	return nil
+}
+
+func checkStorage(value string) error {
+	if value != storageLocal && value != storageS3 {
+		return fmt.Errorf("unknown storage backend %q", value)
+	}
+	return nil
+}
+
+// readConfig reads and validates the configuration variables. It returns an
+// error listing all problems if the configuration is invalid.
+func readConfig() (configValues, error) {
+	values, problems := readConfigValues()
+	problems = append(problems, values.validate()...)
+	if len(problems) > 0 {
+		return values, fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return values, nil
+}
+
+// loadconfig reads all the configuration variables (from the environment and
+// the configuration file) and sets up the GIN client.
 func loadconfig() (*Configuration, error) {
+	values, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
 	cfg := Configuration{}
 
 	// NOTE: Temporary workaround. GIN Client internals need a bit of a
 	// redesign to support in-memory configurations.
 	confdir := libgin.ReadConf("configdir")
-	confdir, err := filepath.Abs(confdir)
+	confdir, err = filepath.Abs(confdir)
 	if err != nil {
 		return nil, err
 	}
@@ -74,48 +514,40 @@ func loadconfig() (*Configuration, error) {
 		log.Printf("Could not set GIN_CONFIG_DIR env: %q", err.Error())
 	}
 
-	cfg.DOIBase = libgin.ReadConf("doibase")
-
-	cfg.Email.Server = libgin.ReadConf("mailserver")
-	cfg.Email.From = libgin.ReadConf("mailfrom")
-	cfg.Email.RecipientsFile = libgin.ReadConf("mailtofile")
-
-	cfg.Storage.PreparationDirectory = libgin.ReadConf("preparation")
-	cfg.Storage.TargetDirectory = libgin.ReadConf("target")
-	cfg.Storage.StoreURL = libgin.ReadConf("storeurl")
-	cfg.Storage.XMLURL = libgin.ReadConf("xmlurl")
-
-	cfg.XMLRepo = libgin.ReadConf("xmlrepo")
+	cfg.Storage.PreparationDirectory = values.get("preparation")
+	cfg.Storage.TargetDirectory = values.get("target")
+	applySettings(&cfg, values)
 
-	cfg.Key = libgin.ReadConf("key")
-	maxqueue, err := strconv.Atoi(libgin.ReadConfDefault("maxqueue", "100"))
+	backend, err := newStorageBackend(values)
 	if err != nil {
-		log.Printf("Error while parsing maxqueue flag: %s", err.Error())
-		log.Print("Using default")
-		maxqueue = 100
+		return nil, err
 	}
-	cfg.MaxQueue = maxqueue
+	cfg.Storage.Backend = backend
 
-	maxworkers, err := strconv.Atoi(libgin.ReadConfDefault("maxworkers", "3"))
+	jobs, err := newJobStore(filepath.Join(cfg.Storage.PreparationDirectory, jobsdir))
 	if err != nil {
-		log.Printf("Error while parsing maxworkers flag: %s", err.Error())
-		log.Print("Using default")
-		maxworkers = 3
+		return nil, err
 	}
-	cfg.MaxWorkers = maxworkers
+	cfg.Jobs = jobs
+	cfg.Metrics = newMetrics()
+	cfg.Jobs.metrics = cfg.Metrics
 
-	portstr := libgin.ReadConfDefault("port", "10443")
-	port, err := strconv.ParseUint(portstr, 10, 16)
+	requests, err := newRequestStore(filepath.Join(cfg.Storage.PreparationDirectory, usedRequestsFile))
 	if err != nil {
 		return nil, err
 	}
+	cfg.Requests = requests
+	cfg.clientLimiter = newRateLimiter(clientRatePeriod)
+	cfg.userLimiter = newRateLimiter(userRatePeriod)
 
+	cfg.MaxQueue, _ = strconv.Atoi(values.get("maxqueue"))
+	port, _ := strconv.ParseUint(values.get("port"), 10, 16)
 	cfg.Port = uint16(port)
 
 	// Set up GIN client configuration (for cloning)
 
-	ginurl := libgin.ReadConf("ginurl")
-	giturl := libgin.ReadConf("giturl")
+	ginurl := values.get("ginurl")
+	giturl := values.get("giturl")
 	log.Printf("gin: %s -- git: %s", ginurl, giturl)
 
 	webcfg, err := config.ParseWebString(ginurl)
@@ -144,10 +576,82 @@ func loadconfig() (*Configuration, error) {
 	if err != nil {
 		return nil, err
 	}
-	cfg.GIN.Username = libgin.ReadConf("ginuser")
-	cfg.GIN.Password = libgin.ReadConf("ginpassword")
+	cfg.GIN.Username = values.get("ginuser")
+	cfg.GIN.Password = values.get("ginpassword")
 
 	cfg.GIN.Session = ginclient.New("gin")
+	cfg.settings = values
 
 	return &cfg, nil
 }
+
+// applySettings sets the fields of the configuration that can be changed
+// while the service is running from the validated values.
+func applySettings(cfg *Configuration, values configValues) {
+	cfg.DOIBase = values.get("doibase")
+
+	cfg.Email.Server = values.get("mailserver")
+	cfg.Email.From = values.get("mailfrom")
+	cfg.Email.RecipientsFile = values.get("mailtofile")
+
+	cfg.Storage.StoreURL = values.get("storeurl")
+	cfg.Storage.XMLURL = values.get("xmlurl")
+	cfg.Storage.Packaging = values.get("packaging")
+
+	cfg.XMLRepo = values.get("xmlrepo")
+	cfg.KeywordDirectory = values.get("keyworddir")
+
+	cfg.DataCite = nil
+	if datacitename := values.get("dataciteuser"); datacitename != "" {
+		apiurl := values.get("dataciteurl")
+		if test, _ := strconv.ParseBool(values.get("datacitetest")); test {
+			apiurl = dataciteTestURL
+		}
+		dryrun, _ := strconv.ParseBool(values.get("datacitedryrun"))
+		cfg.DataCite = NewDataCiteClient(apiurl, datacitename, values.get("datacitepassword"), dryrun)
+	}
+
+	// Numbers have been validated
+	retentiondays, _ := strconv.Atoi(values.get("jobretention"))
+	cfg.JobRetention = time.Duration(retentiondays) * 24 * time.Hour
+	duplicatehours, _ := strconv.Atoi(values.get("duplicatewindow"))
+	cfg.DuplicateWindow = time.Duration(duplicatehours) * time.Hour
+	requesthours, _ := strconv.Atoi(values.get("requestmaxage"))
+	cfg.RequestMaxAge = time.Duration(requesthours) * time.Hour
+	cfg.RateLimit, _ = strconv.Atoi(values.get("ratelimit"))
+	cfg.UserRateLimit, _ = strconv.Atoi(values.get("userratelimit"))
+	cfg.MaxOpenJobs, _ = strconv.Atoi(values.get("maxopenjobs"))
+	shutdownsecs, _ := strconv.ParseUint(values.get("shutdowntimeout"), 10, 64)
+	cfg.ShutdownTimeout = time.Duration(shutdownsecs) * time.Second
+	minfreemb, _ := strconv.ParseUint(values.get("minfreespace"), 10, 64)
+	cfg.MinFreeSpace = minfreemb << 20
+
+	cfg.Key = values.get("key")
+	cfg.AdminToken = values.get("admintoken")
+	cfg.MaxWorkers, _ = strconv.Atoi(values.get("maxworkers"))
+}
+
+// checkconfig prints the effective configuration with the secrets masked and
+// lists all problems found. It exits with status 1 if the configuration is
+// invalid.
+func checkconfig(cmd *cobra.Command, args []string) {
+	values, problems := readConfigValues()
+	problems = append(problems, values.validate()...)
+
+	if _, path, _ := readConfigFile(); path != "" {
+		fmt.Printf("Configuration file: %s\n\n", path)
+	} else {
+		fmt.Printf("Configuration file: none\n\n")
+	}
+	fmt.Print(values)
+
+	if len(problems) == 0 {
+		fmt.Println("\nConfiguration OK")
+		return
+	}
+	fmt.Printf("\n%d problems found:\n", len(problems))
+	for _, problem := range problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+	os.Exit(1)
+}
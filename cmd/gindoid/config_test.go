@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setTestEnv sets environment variables and returns a function that restores
+// their previous values.
+func setTestEnv(vars map[string]string) func() {
+	previous := make(map[string]*string, len(vars))
+	for name, value := range vars {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+func TestConfigValues(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_config")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	prepdir := filepath.Join(tmpDir, "prep")
+	targetdir := filepath.Join(tmpDir, "target")
+	for _, dir := range []string{prepdir, targetdir} {
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatalf("Error creating directory: %v", err)
+		}
+	}
+	secretfile := filepath.Join(tmpDir, "ginpassword")
+	if err := ioutil.WriteFile(secretfile, []byte("s3cr3t-password\n"), 0600); err != nil {
+		t.Fatalf("Error writing secret file: %v", err)
+	}
+	conffile := filepath.Join(tmpDir, "gindoid.yml")
+	confdata := fmt.Sprintf(`key: 0123456789abcdef
+port: 8443
+maxworkers: 5
+ginurl: https://gin.g-node.org:443
+giturl: git@gin.g-node.org:22
+ginuser: doi
+ginpassword_file: %s
+doibase: 10.12751/g-node.
+mailserver: smtp.example.org:25
+mailfrom: GIN DOI <doi@example.org>
+preparation: %s
+target: %s
+storeurl: https://doi.gin.g-node.org
+datacitetest: true
+`, secretfile, prepdir, targetdir)
+	if err := ioutil.WriteFile(conffile, []byte(confdata), 0600); err != nil {
+		t.Fatalf("Error writing configuration file: %v", err)
+	}
+
+	// Environment overrides the file
+	defer setTestEnv(map[string]string{"configfile": conffile, "maxworkers": "7"})()
+	values, err := readConfig()
+	if err != nil {
+		t.Fatalf("Error reading valid configuration: %v", err)
+	}
+	for name, expected := range map[string]configValue{
+		"port":         {"8443", conffile},
+		"maxworkers":   {"7", "environment"},
+		"maxqueue":     {"100", "default"},
+		"ginpassword":  {"s3cr3t-password", fmt.Sprintf("%s (%s)", conffile, secretfile)},
+		"datacitetest": {"true", conffile},
+		"admintoken":   {"", "not set"},
+	} {
+		if value := values[name]; value != expected {
+			t.Errorf("Unexpected value of %s: %+v (expected %+v)", name, value, expected)
+		}
+	}
+
+	// Secrets are masked
+	printed := values.String()
+	for _, secret := range []string{"s3cr3t-password", "0123456789abcdef"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("Secret %q not masked:\n%s", secret, printed)
+		}
+	}
+	if !strings.Contains(printed, "[HIDDEN]") || !strings.Contains(printed, "https://doi.gin.g-node.org") {
+		t.Errorf("Unexpected effective configuration:\n%s", printed)
+	}
+
+	// All problems are reported
+	defer setTestEnv(map[string]string{
+		"key":              "tooshort",
+		"ginurl":           "gin.g-node.org",
+		"mailserver":       "smtp.example.org",
+		"maxqueue":         "-1",
+		"target":           filepath.Join(tmpDir, "missing"),
+		"storage":          "s3",
+		"dataciteuser":     "datacite",
+		"s3accesskey_file": filepath.Join(tmpDir, "missing"),
+	})()
+	if err := ioutil.WriteFile(conffile, []byte(confdata+"unknownsetting: 1\n"), 0600); err != nil {
+		t.Fatalf("Error writing configuration file: %v", err)
+	}
+	values, problems := readConfigValues()
+	problems = append(problems, values.validate()...)
+	for _, expected := range []string{
+		"s3accesskey: failed to read value from file",
+		"unknown setting \"unknownsetting\"",
+		"key: key must be 16, 24, or 32 bytes long, not 8",
+		"ginurl: invalid URL",
+		"mailserver: invalid mail server address",
+		"maxqueue: expected a positive number",
+		"target: stat ",
+		"s3endpoint: required for the S3 storage backend",
+		"s3bucket: required for the S3 storage backend",
+		"datacitepassword: required if dataciteuser is set",
+	} {
+		found := false
+		for _, problem := range problems {
+			if strings.Contains(problem, expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Problem %q not reported: %q", expected, problems)
+		}
+	}
+	if _, err := readConfig(); err == nil {
+		t.Error("Invalid configuration accepted")
+	}
+
+	// A missing default configuration file is not an error, a missing
+	// specified file is
+	os.Unsetenv("configfile")
+	defer setTestEnv(map[string]string{"configdir": tmpDir})()
+	os.Remove(conffile)
+	if _, _, err := readConfigFile(); err != nil {
+		t.Errorf("Error for missing default configuration file: %v", err)
+	}
+	os.Setenv("configfile", conffile)
+	if _, _, err := readConfigFile(); err == nil {
+		t.Error("Missing configuration file not reported")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// dataciteURL is the production endpoint of the DataCite REST API.
+	dataciteURL = "https://api.datacite.org"
+	// dataciteTestURL is the endpoint of the DataCite test system, which
+	// only accepts DOIs with the test prefixes of the repository account.
+	dataciteTestURL = "https://api.test.datacite.org"
+	// dataciteContentType is the JSON:API media type used by the DataCite
+	// REST API.
+	dataciteContentType = "application/vnd.api+json"
+)
+
+// DataCiteClient registers DOIs through the DataCite REST API. DOIs are
+// created as drafts when a request is submitted, the metadata is uploaded when
+// the dataset has been prepared, and the DOI is made findable when the
+// registration is approved by a curator. In dry-run mode the requests are only
+// logged.
+type DataCiteClient struct {
+	// URL of the DataCite REST API
+	URL string
+	// Repository account ID and password
+	Username string
+	Password string `json:"-"`
+	// DryRun logs the requests instead of sending them
+	DryRun bool
+	client *http.Client
+}
+
+// NewDataCiteClient returns a DataCiteClient for the API at the given URL
+// that authenticates with the given repository account.
+func NewDataCiteClient(apiurl, username, password string, dryrun bool) *DataCiteClient {
+	return &DataCiteClient{
+		URL:      apiurl,
+		Username: username,
+		Password: password,
+		DryRun:   dryrun,
+		client:   &http.Client{Timeout: time.Minute},
+	}
+}
+
+// dataciteAttributes holds the DOI attributes that are sent to DataCite.
+type dataciteAttributes struct {
+	DOI   string `json:"doi,omitempty"`
+	Event string `json:"event,omitempty"`
+	URL   string `json:"url,omitempty"`
+	// Base64 encoded DataCite XML metadata
+	XML string `json:"xml,omitempty"`
+}
+
+// dataciteRequest is the JSON:API document for creating and updating DOIs.
+type dataciteRequest struct {
+	Data struct {
+		Type       string             `json:"type"`
+		Attributes dataciteAttributes `json:"attributes"`
+	} `json:"data"`
+}
+
+// doiPath returns the API path of the DOI.
+func doiPath(doi string) string {
+	return "/dois/" + doi
+}
+
+// do sends a request to the DataCite API and returns the status code of the
+// response. Status codes other than 200 and 201 are returned as an error,
+// unless they are listed in accept.
+func (c *DataCiteClient) do(method, reqpath string, attrs *dataciteAttributes, accept ...int) (int, error) {
+	var body []byte
+	if attrs != nil {
+		doc := dataciteRequest{}
+		doc.Data.Type = "dois"
+		doc.Data.Attributes = *attrs
+		var err error
+		body, err = json.Marshal(doc)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if c.DryRun {
+		log.Printf("DataCite dry run: %s %s%s %s", method, c.URL, reqpath, body)
+		return http.StatusOK, nil
+	}
+
+	req, err := http.NewRequest(method, c.URL+reqpath, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+	req.Header.Set("Accept", dataciteContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", dataciteContentType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("DataCite request failed: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+		return resp.StatusCode, nil
+	}
+	for _, code := range accept {
+		if resp.StatusCode == code {
+			return resp.StatusCode, nil
+		}
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, fmt.Errorf("DataCite request %s %s failed: [%d] %s", method, reqpath, resp.StatusCode, msg)
+}
+
+// CreateDraft creates a draft DOI. Drafts are not publicly resolvable and can
+// be deleted. It succeeds without changes if the DOI already exists, e.g.,
+// when a registration is run again.
+func (c *DataCiteClient) CreateDraft(doi string) error {
+	status, err := c.do(http.MethodGet, doiPath(doi), nil, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNotFound && !c.DryRun {
+		log.Printf("DOI %s already exists at DataCite", doi)
+		return nil
+	}
+	log.Printf("Creating draft DOI %s", doi)
+	_, err = c.do(http.MethodPost, "/dois", &dataciteAttributes{DOI: doi})
+	return err
+}
+
+// UpdateMetadata uploads the DataCite XML metadata and the landing page URL
+// of a DOI without changing its state.
+func (c *DataCiteClient) UpdateMetadata(doi string, xml []byte, landingpage string) error {
+	log.Printf("Uploading metadata of DOI %s", doi)
+	attrs := &dataciteAttributes{
+		URL: landingpage,
+		XML: base64.StdEncoding.EncodeToString(xml),
+	}
+	_, err := c.do(http.MethodPut, doiPath(doi), attrs)
+	return err
+}
+
+// Publish makes a DOI findable with the given landing page URL. Findable DOIs
+// cannot be deleted.
+func (c *DataCiteClient) Publish(doi string, landingpage string) error {
+	log.Printf("Publishing DOI %s", doi)
+	_, err := c.do(http.MethodPut, doiPath(doi), &dataciteAttributes{Event: "publish", URL: landingpage})
+	return err
+}
+
+// Hide makes a findable DOI registered again: it still resolves to the
+// landing page but is removed from the DataCite search index. This is used
+// for withdrawn datasets, since findable DOIs cannot be deleted.
+func (c *DataCiteClient) Hide(doi string) error {
+	log.Printf("Hiding DOI %s", doi)
+	_, err := c.do(http.MethodPut, doiPath(doi), &dataciteAttributes{Event: "hide"})
+	return err
+}
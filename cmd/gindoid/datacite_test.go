@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDataCite is a minimal in-memory stand-in for the DataCite REST API.
+type fakeDataCite struct {
+	sync.Mutex
+	dois     map[string]dataciteAttributes
+	states   map[string]string
+	requests []string
+}
+
+func newFakeDataCite() *fakeDataCite {
+	return &fakeDataCite{
+		dois:   make(map[string]dataciteAttributes),
+		states: make(map[string]string),
+	}
+}
+
+func (f *fakeDataCite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if user, password, ok := r.BasicAuth(); !ok || user != "GIN.TEST" || password != "testpassword" {
+		http.Error(w, `{"errors": [{"status": "401", "title": "Bad credentials."}]}`, http.StatusUnauthorized)
+		return
+	}
+	doc := dataciteRequest{}
+	if r.Method != http.MethodGet {
+		if r.Header.Get("Content-Type") != dataciteContentType {
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &doc); err != nil || doc.Data.Type != "dois" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	doi := strings.TrimPrefix(r.URL.Path, "/dois/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/dois":
+		doi = doc.Data.Attributes.DOI
+		if _, exists := f.dois[doi]; exists {
+			http.Error(w, "This DOI has already been taken", http.StatusUnprocessableEntity)
+			return
+		}
+		f.dois[doi] = doc.Data.Attributes
+		f.states[doi] = "draft"
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet:
+		if _, exists := f.dois[doi]; !exists {
+			http.NotFound(w, r)
+			return
+		}
+	case r.Method == http.MethodPut:
+		attrs, exists := f.dois[doi]
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		if doc.Data.Attributes.XML != "" {
+			attrs.XML = doc.Data.Attributes.XML
+		}
+		if doc.Data.Attributes.URL != "" {
+			attrs.URL = doc.Data.Attributes.URL
+		}
+		if doc.Data.Attributes.Event == "publish" {
+			if attrs.XML == "" {
+				http.Error(w, "metadata missing", http.StatusUnprocessableEntity)
+				return
+			}
+			f.states[doi] = "findable"
+		} else if doc.Data.Attributes.Event == "hide" {
+			f.states[doi] = "registered"
+		}
+		f.dois[doi] = attrs
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func TestDataCiteClient(t *testing.T) {
+	fake := newFakeDataCite()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := NewDataCiteClient(server.URL, "GIN.TEST", "testpassword", false)
+	doi := "10.12751/g-node.aaaaaa"
+	landingpage := "https://doi.example.org/10.12751/g-node.aaaaaa/"
+
+	if err := client.CreateDraft(doi); err != nil {
+		t.Fatalf("Error creating draft: %v", err)
+	}
+	if fake.states[doi] != "draft" {
+		t.Fatalf("Unexpected state of new DOI: %q", fake.states[doi])
+	}
+	// Creating the draft again (e.g., re-running a registration) succeeds
+	if err := client.CreateDraft(doi); err != nil {
+		t.Fatalf("Error creating existing draft: %v", err)
+	}
+
+	// Publishing without metadata fails
+	if err := client.Publish(doi, landingpage); err == nil {
+		t.Fatal("Publishing a DOI without metadata did not fail")
+	}
+
+	xml := []byte(`<?xml version="1.0" encoding="UTF-8"?><resource/>`)
+	if err := client.UpdateMetadata(doi, xml, landingpage); err != nil {
+		t.Fatalf("Error uploading metadata: %v", err)
+	}
+	if data, _ := base64.StdEncoding.DecodeString(fake.dois[doi].XML); string(data) != string(xml) {
+		t.Fatalf("Unexpected uploaded metadata: %q", string(data))
+	}
+	if fake.dois[doi].URL != landingpage || fake.states[doi] != "draft" {
+		t.Fatalf("Unexpected DOI after metadata upload: %+v (%s)", fake.dois[doi], fake.states[doi])
+	}
+
+	if err := client.Publish(doi, landingpage); err != nil {
+		t.Fatalf("Error publishing DOI: %v", err)
+	}
+	if fake.states[doi] != "findable" {
+		t.Fatalf("Unexpected state of published DOI: %q", fake.states[doi])
+	}
+
+	if err := client.Hide(doi); err != nil {
+		t.Fatalf("Error hiding DOI: %v", err)
+	}
+	if fake.states[doi] != "registered" {
+		t.Fatalf("Unexpected state of hidden DOI: %q", fake.states[doi])
+	}
+
+	// Updating an unknown DOI fails
+	if err := client.UpdateMetadata("10.12751/g-node.nothere", xml, landingpage); err == nil {
+		t.Fatal("Updating an unknown DOI did not fail")
+	}
+
+	// Wrong credentials
+	badclient := NewDataCiteClient(server.URL, "GIN.TEST", "wrong", false)
+	if err := badclient.CreateDraft("10.12751/g-node.bbbbbb"); err == nil {
+		t.Fatal("Creating a draft with wrong credentials did not fail")
+	}
+
+	// Dry run does not send any requests
+	nrequests := len(fake.requests)
+	dryclient := NewDataCiteClient(server.URL, "GIN.TEST", "testpassword", true)
+	if err := dryclient.CreateDraft("10.12751/g-node.cccccc"); err != nil {
+		t.Fatalf("Error in dry run: %v", err)
+	}
+	if err := dryclient.Publish("10.12751/g-node.cccccc", landingpage); err != nil {
+		t.Fatalf("Error in dry run: %v", err)
+	}
+	if len(fake.requests) != nrequests {
+		t.Fatalf("Dry run sent requests: %v", fake.requests[nrequests:])
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/G-Node/gin-cli/ginclient"
 	"github.com/G-Node/gin-cli/git"
@@ -33,16 +35,21 @@ const (
 // individual functions.
 func createRegisteredDataset(job *RegistrationJob) error {
 	conf := job.Config
-	repopath := job.Metadata.SourceRepository
 	jobname := job.Metadata.Identifier.ID
 
 	preperrors := make([]string, 0, 7)
+	// Problems that do not prevent the dataset from being published are
+	// reported as warnings, so that the job can still be reviewed
+	prepwarnings := make([]string, 0, 2)
+	conf.Jobs.startStage(jobname, "prepare directories")
 	err := prepDir(job)
 	if err != nil {
 		preperrors = append(preperrors, fmt.Sprintf("Error preparing data directory : %q", err.Error()))
 	}
 
-	targetpath := filepath.Join(conf.Storage.TargetDirectory, jobname)
+	// Dataset directory in the storage backend
+	targetpath := jobname
+	storage := conf.Storage.Backend
 
 	ginurl, err := url.Parse(GetGINURL(conf))
 	if err != nil {
@@ -55,7 +62,7 @@ func createRegisteredDataset(job *RegistrationJob) error {
 	forkURL := ginurl.String()
 
 	preppath := filepath.Join(conf.Storage.PreparationDirectory, jobname)
-	zipfname, zipsize, err := cloneAndZip(repopath, jobname, preppath, targetpath, conf)
+	zipfname, zipsize, err := cloneAndZip(job, preppath, targetpath)
 	var archiveURL string
 	if err != nil {
 		// failed to clone and zip
@@ -70,20 +77,49 @@ func createRegisteredDataset(job *RegistrationJob) error {
 	}
 	job.Metadata.AddURLs(repoURL, forkURL, archiveURL)
 
+	// Keep the archive of an embargoed dataset inaccessible until the embargo
+	// ends; the archive URL is recorded since it is valid after the release
+	if archiveURL != "" && isEmbargoed(job.Metadata, time.Now()) {
+		if err := holdArchives(storage, targetpath); err != nil {
+			preperrors = append(preperrors, fmt.Sprintf("Failed to hold back the archive of the embargoed dataset: %s", err.Error()))
+		}
+	}
+
+	// Record the exact commit if a specific revision was registered
+	if job.Revision != "" && job.Commit != "" {
+		ginurl.Path = path.Join(job.Metadata.SourceRepository, "src", job.Commit)
+		relatedIdentifier := libgin.RelatedIdentifier{Identifier: ginurl.String(), Type: "URL", RelationType: "IsVariantFormOf"}
+		job.Metadata.RelatedIdentifiers = append(job.Metadata.RelatedIdentifiers, relatedIdentifier)
+	}
+
 	// Check if there are older versions of the same dataset
 	if oldID := getPreviousDOI(job); oldID != "" {
 		relatedIdentifier := libgin.RelatedIdentifier{Identifier: oldID, Type: "DOI", RelationType: "IsNewVersionOf"}
 		job.Metadata.RelatedIdentifiers = append(job.Metadata.RelatedIdentifiers, relatedIdentifier)
 	}
 
+	// Show the archive checksum on the landing page if the manifest exists
+	var checksum string
+	if manifest, err := readManifest(storage, targetpath); err == nil {
+		checksum = manifest.Archive.SHA256
+	}
+
+	conf.Jobs.setState(jobname, jobRendering)
+	conf.Jobs.startStage(jobname, "render landing page")
 	dynurl := GetGINURL(conf)
-	err = createLandingPage(job.Metadata, filepath.Join(conf.Storage.TargetDirectory, job.Metadata.Identifier.ID, "index.html"), dynurl)
+	err = createLandingPage(storage, job.Metadata, path.Join(targetpath, "index.html"), dynurl, checksum)
 	if err != nil {
 		// Landing page creation failed; append the error for reporting and continue with the XML prep
 		preperrors = append(preperrors, fmt.Sprintf("Failed to create the landing page: %q", err.Error()))
 	}
 
-	fp, err := os.Create(filepath.Join(targetpath, "doi.xml"))
+	conf.Jobs.startStage(jobname, "write citation files")
+	if err := writeCitations(storage, job.Metadata, targetpath); err != nil {
+		prepwarnings = append(prepwarnings, fmt.Sprintf("Failed to write the citation files: %s", err.Error()))
+	}
+
+	conf.Jobs.startStage(jobname, "write doi.xml")
+	fp, err := storage.Create(path.Join(targetpath, "doi.xml"))
 	if err != nil {
 		log.Print("Could not create the metadata template")
 		// XML Creation failed; return with error
@@ -92,27 +128,39 @@ func createRegisteredDataset(job *RegistrationJob) error {
 		if mailerr != nil {
 			log.Printf("Failed to send notification email: %s", mailerr.Error())
 		}
+		conf.Jobs.finish(jobname, jobFailed, preperrors, nil)
 		return err
 	}
-	defer fp.Close()
 
 	data, err := job.Metadata.DataCite.Marshal()
 	if err != nil {
+		fp.Close()
 		log.Print("Could not render the metadata file")
 		preperrors = append(preperrors, fmt.Sprintf("Failed to render the XML metadata: %s", err))
 		mailerr := notifyAdmin(job, preperrors, nil, false)
 		if mailerr != nil {
 			log.Printf("Failed to send notification email: %s", mailerr.Error())
 		}
+		conf.Jobs.finish(jobname, jobFailed, preperrors, nil)
 		return err
 	}
 	_, err = fp.Write([]byte(data))
+	if closeerr := fp.Close(); err == nil {
+		err = closeerr
+	}
 	if err != nil {
 		log.Print("Could not write to the metadata file")
 		preperrors = append(preperrors, fmt.Sprintf("Failed to write the metadata XML file: %s", err))
+	} else if conf.DataCite != nil {
+		// Upload the metadata to the draft DOI; it is made findable when
+		// the registration is approved
+		conf.Jobs.startStage(jobname, "upload metadata to DataCite")
+		if dcerr := conf.DataCite.UpdateMetadata(jobname, []byte(data), landingPageURL(conf, jobname)); dcerr != nil {
+			prepwarnings = append(prepwarnings, fmt.Sprintf("Failed to upload the metadata to DataCite; this needs to be done manually: %s", dcerr.Error()))
+		}
 	}
 
-	warnings := collectWarnings(job)
+	warnings := append(prepwarnings, collectWarnings(job)...)
 
 	if len(preperrors)+len(warnings) > 0 {
 		// Resend email with errors if any occurred
@@ -121,13 +169,24 @@ func createRegisteredDataset(job *RegistrationJob) error {
 			log.Printf("Failed to send notification email: %s", mailerr.Error())
 		}
 	}
+
+	state := jobDone
+	if err != nil || len(preperrors) > 0 {
+		state = jobFailed
+	}
+	conf.Jobs.finish(jobname, state, preperrors, warnings)
 	return err
 }
 
-// cloneAndZip clones the source repository into a temporary directory under
-// preppath, zips the contents at the targetpath, and returns the archive filename
-// and its size in bytes.
-func cloneAndZip(repopath string, jobname string, preppath string, targetpath string, conf *Configuration) (string, int64, error) {
+// cloneAndZip clones the source repository of a job at the requested revision
+// into a temporary directory under preppath, archives the contents in the
+// targetpath directory of the storage backend using the configured packaging
+// mode, and returns the archive filename and its size in bytes. The commit hash of the cloned revision is
+// stored in the job.
+func cloneAndZip(job *RegistrationJob, preppath string, targetpath string) (string, int64, error) {
+	conf := job.Config
+	repopath := job.Metadata.SourceRepository
+	jobname := job.Metadata.Identifier.ID
 	log.Print("Start clone and zip")
 	// Clone at preppath (will create subdirectories '[doi-org-id]/[doi-jobname]/[reponame]')
 	if err := os.MkdirAll(preppath, 0777); err != nil {
@@ -136,106 +195,160 @@ func cloneAndZip(repopath string, jobname string, preppath string, targetpath st
 		return "", -1, fmt.Errorf(errmsg)
 	}
 
-	// Clone repository at the preparation path
-	if err := cloneRepo(repopath, preppath, conf); err != nil {
-		log.Print("Repository cloning failed")
-		return "", -1, fmt.Errorf("failed to clone repository '%s': %v", repopath, err)
-	}
-
-	// Zip repository content to the target path
 	repoparts := strings.SplitN(repopath, "/", 2)
 	reponame := strings.ToLower(repoparts[1]) // clone directory is always lowercase
 	repodir := filepath.Join(preppath, reponame)
 
-	log.Printf("Preparing zip file for %s", jobname)
-	// use DOI with / replacement for zip filename
-	zipbasename := strings.ReplaceAll(jobname, "/", "_") + ".zip"
-	zipfilename := filepath.Join(targetpath, zipbasename)
-	// exclude the git folder from the zip file
+	// Remove leftovers of a previous, interrupted run of the same job
+	if err := os.RemoveAll(repodir); err != nil {
+		log.Printf("Failed to remove previous clone directory %q: %s", repodir, err.Error())
+	}
+
+	// Clone repository at the preparation path
+	conf.Jobs.setState(jobname, jobCloning)
+	conf.Jobs.startStage(jobname, "clone repository")
+	commit, err := cloneRepo(repopath, job.Revision, preppath, jobname, conf)
+	if err != nil {
+		log.Print("Repository cloning failed")
+		return "", -1, fmt.Errorf("failed to clone repository '%s': %v", repopath, err)
+	}
+	job.Commit = commit
+	conf.Jobs.setCommit(jobname, commit)
+
+	// Package repository content to the target path
+	conf.Jobs.setState(jobname, jobZipping)
+	conf.Jobs.startStage(jobname, "create archive")
+	log.Printf("Preparing %s archive for %s", conf.Storage.Packaging, jobname)
+	// use DOI with / replacement for archive filename
+	archivebasename := strings.ReplaceAll(jobname, "/", "_") + archiveExtension(conf.Storage.Packaging)
+	archivefilename := path.Join(targetpath, archivebasename)
+	// exclude the git folder from the archive
 	exclude := []string{".git"}
-	zipsize, err := runzip(repodir, zipfilename, exclude)
+	var archive *ManifestEntry
+	var files []ManifestEntry
+	if isBagIt(conf.Storage.Packaging) {
+		archive, files, err = runbag(conf.Storage.Backend, repodir, archivefilename, exclude, job.Metadata, conf.Storage.Packaging)
+	} else {
+		archive, err = runzip(conf.Storage.Backend, repodir, archivefilename, exclude)
+	}
 	if err != nil {
-		log.Print("Could not zip the data")
-		return "", -1, fmt.Errorf("failed to create the zip file: %v", err)
+		log.Print("Could not archive the data")
+		return "", -1, fmt.Errorf("failed to create the archive file: %v", err)
 	}
-	log.Printf("Archive size: %d", zipsize)
-	return zipbasename, zipsize, nil
+	log.Printf("Archive size: %d", archive.Size)
+	conf.Jobs.setArchiveSize(jobname, archive.Size)
+
+	// Record the checksums of the archive and the repository content next to
+	// the archive for later integrity checks
+	conf.Jobs.startStage(jobname, "create checksum manifest")
+	if files == nil {
+		files, err = hashFiles(repodir, exclude)
+		if err != nil {
+			log.Printf("Could not compute the file checksums: %s", err.Error())
+			return "", -1, fmt.Errorf("failed to compute the file checksums: %v", err)
+		}
+	}
+	manifest := createManifest(jobname, *archive, conf.Storage.Packaging, files)
+	if err := writeManifest(conf.Storage.Backend, manifest, targetpath); err != nil {
+		log.Printf("Could not write the checksum manifest: %s", err.Error())
+		return "", -1, fmt.Errorf("failed to write the checksum manifest: %v", err)
+	}
+	log.Printf("Archive SHA-256: %s", manifest.Archive.SHA256)
+	return archivebasename, archive.Size, nil
 }
 
-// runzip zips a source directory into a file with the given filename.  Any directories
-// or files handed over via the exclude parameter will not be zipped.
-func runzip(source, zipfilename string, exclude []string) (int64, error) {
+// runzip zips a source directory into the file with the given name in the
+// storage backend.  Any directories or files handed over via the exclude
+// parameter will not be zipped. It returns the name, size, and checksum of the
+// zip file.
+func runzip(storage StorageBackend, source, zipfilename string, exclude []string) (*ManifestEntry, error) {
 	fn := fmt.Sprintf("runzip(%s, %s)", source, zipfilename) // keep original args for errmsg
 	source, err := filepath.Abs(source)
 	if err != nil {
 		log.Printf("%s: Failed to get abs path for source directory in function '%s': %v", lpStorage, fn, err)
-		return -1, err
-	}
-
-	zipfilename, err = filepath.Abs(zipfilename)
-	if err != nil {
-		log.Printf("%s: Failed to get abs path for target zip file in function '%s': %v", lpStorage, fn, err)
-		return -1, err
+		return nil, err
 	}
 
 	// Create zip file IO writer for MakeZip function
-	zipfp, err := os.Create(zipfilename)
+	zipfp, err := storage.Create(zipfilename)
 	if err != nil {
 		log.Printf("%s: Failed to create zip file for writing in function '%s': %v", lpStorage, fn, err)
-		return -1, err
+		return nil, err
 	}
-	defer zipfp.Close()
+	// Discard the incomplete file if anything goes wrong
+	stored := false
+	defer func() {
+		if !stored {
+			if err := abortFile(storage, zipfilename, zipfp); err != nil {
+				log.Printf("%s: Failed to discard incomplete file in function '%s': %v", lpStorage, fn, err)
+			}
+		}
+	}()
 	// Change into clone directory to make the paths in the zip archive repo
 	// root-relative.
 	origdir, err := os.Getwd()
 	if err != nil {
 		log.Printf("%s: Failed to get working directory in function '%s': %v", lpStorage, fn, err)
-		return -1, err
+		return nil, err
 	}
 	defer os.Chdir(origdir)
 	if err := os.Chdir(source); err != nil {
 		log.Printf("%s: Failed to change to source directory to make zip file in function '%s': %v", lpStorage, fn, err)
-		return -1, err
+		return nil, err
 	}
 
-	if err := MakeZip(zipfp, exclude, "."); err != nil {
+	zipwriter := newChecksumWriter(zipfp)
+	if err := MakeZip(zipwriter, exclude, "."); err != nil {
 		log.Printf("%s: Failed to create zip file in function '%s': %v", lpStorage, fn, err)
-		return -1, err
+		return nil, err
 	}
+	if err := zipfp.Close(); err != nil {
+		log.Printf("%s: Failed to store zip file in function '%s': %v", lpStorage, fn, err)
+		return nil, err
+	}
+	stored = true
 
-	stat, _ := zipfp.Stat()
-	return stat.Size(), nil
+	entry := zipwriter.entry(path.Base(zipfilename))
+	return &entry, nil
 }
 
 // createLandingPage renders and writes a registered dataset landing page based
-// on the LandingPage template.
-func createLandingPage(metadata *libgin.RepositoryMetadata, targetfile string, ginurl string) error {
+// on the LandingPage template to the target file in the storage backend. The
+// archive checksum is shown on the page if it is not empty.
+func createLandingPage(storage StorageBackend, metadata *libgin.RepositoryMetadata, targetfile string, ginurl string, checksum string) error {
 	tmpl, err := prepareTemplates("DOIInfo", "LandingPage")
 	if err != nil {
 		return err
 	}
 	// Overwrite default GIN server URL with config GIN server URL
 	tmpl = injectDynamicGINURL(tmpl, ginurl)
+	tmpl = injectArchiveChecksum(tmpl, checksum)
 
-	fp, err := os.Create(targetfile)
+	fp, err := storage.Create(targetfile)
 	if err != nil {
 		log.Printf("Could not create the landing page file: %s", err.Error())
 		return err
 	}
-	defer fp.Close()
 	if err := tmpl.Execute(fp, metadata); err != nil {
+		fp.Close()
 		log.Printf("Error rendering the landing page: %s", err.Error())
 		return err
 	}
+	if err := fp.Close(); err != nil {
+		log.Printf("Could not write the landing page file: %s", err.Error())
+		return err
+	}
 	return nil
 }
 
-// prepDir creates the directories where the dataset will be cloned and archived.
+// prepDir creates the directories where the dataset will be cloned and
+// archived. Access to the dataset directory in the storage backend is denied
+// until it is released.
 func prepDir(job *RegistrationJob) error {
 	conf := job.Config
 	metadata := job.Metadata
 	prepdir := conf.Storage.PreparationDirectory
-	storagedir := conf.Storage.TargetDirectory
+	storage := conf.Storage.Backend
 	doi := metadata.Identifier.ID
 
 	err := os.MkdirAll(filepath.Join(prepdir, doi), os.ModePerm)
@@ -243,42 +356,38 @@ func prepDir(job *RegistrationJob) error {
 		log.Print("Could not create the preparation directory")
 		return err
 	}
-	err = os.MkdirAll(filepath.Join(storagedir, doi), os.ModePerm)
+	err = storage.MkdirAll(doi)
 	if err != nil {
 		log.Print("Could not create the target directory")
 		return err
 	}
 	// Deny access per default
-	file, err := os.Create(filepath.Join(storagedir, doi, ".htaccess"))
+	err = storage.SetPublic(doi, false)
 	if err != nil {
-		log.Print("Could not create .htaccess")
-		return err
-	}
-	defer file.Close()
-	// todo check
-	_, err = file.Write([]byte("deny from all"))
-	if err != nil {
-		log.Print("Could not write to .htaccess")
+		log.Print("Could not restrict access to the target directory")
 		return err
 	}
 	return nil
 }
 
 // cloneRepo clones a git repository (with git-annex) specified by URI to the
-// destination directory.
-func cloneRepo(URI string, destdir string, conf *Configuration) error {
+// destination directory and checks out the given revision (commit hash or
+// tag) before downloading the annexed content. If the revision is empty, the
+// default branch is used. The progress is recorded for the job with the given
+// jobname. Returns the hash of the checked out commit.
+func cloneRepo(URI string, revision string, destdir string, jobname string, conf *Configuration) (string, error) {
 	// NOTE: cloneRepo changes the working directory to the cloned repository
 	// See: https://github.com/G-Node/gin-cli/issues/225
 	// This will need to change when that issue is fixed
 	origdir, err := os.Getwd()
 	if err != nil {
 		log.Printf("%s: Failed to get working directory when cloning repository. Was our working directory removed?", lpStorage)
-		return err
+		return "", err
 	}
 	defer os.Chdir(origdir)
 	err = os.Chdir(destdir)
 	if err != nil {
-		return err
+		return "", err
 	}
 	log.Printf("Cloning %s to directory %s", URI, destdir)
 
@@ -288,45 +397,69 @@ func cloneRepo(URI string, destdir string, conf *Configuration) error {
 		log.Print(stat)
 		if stat.Err != nil {
 			log.Printf("Repository cloning failed: %s", stat.Err)
-			return stat.Err
+			return "", stat.Err
 		}
 	}
 
+	if revision != "" {
+		log.Printf("Checking out revision %s", revision)
+		if !isValidRevision(revision) {
+			return "", fmt.Errorf("invalid revision %q", revision)
+		}
+		cmd := git.Command("checkout", "--quiet", revision)
+		if stdout, stderr, err := cmd.OutputError(); err != nil {
+			log.Printf("Failed to check out revision %s: %s %s", revision, stdout, stderr)
+			return "", fmt.Errorf("failed to check out revision %q: %s", revision, strings.TrimSpace(string(stderr)))
+		}
+	}
+	commit, err := git.RevParse("HEAD")
+	if err != nil {
+		log.Printf("Failed to determine the checked out commit: %s", err.Error())
+		return "", err
+	}
+	commit = strings.TrimSpace(commit)
+
 	log.Print("Primary annex content download")
+	conf.Jobs.startStage(jobname, "annex get (round 1)")
 	downloadchan := make(chan git.RepoFileStatus)
 	go conf.GIN.Session.GetContent(nil, downloadchan)
 	for stat := range downloadchan {
 		log.Print(stat)
 		if stat.Err != nil {
 			log.Printf("Repository cloning failed during annex get: %s", stat.Err)
-			return stat.Err
+			return "", stat.Err
 		}
 	}
 
 	// Add a second round of content get since git annex can stop
 	// content download silently if the download rate drops too low.
 	log.Print("Secondary annex content download")
+	conf.Jobs.startStage(jobname, "annex get (round 2)")
 	downloadchan = make(chan git.RepoFileStatus)
 	go conf.GIN.Session.GetContent(nil, downloadchan)
 	for stat := range downloadchan {
 		log.Print(stat)
 		if stat.Err != nil {
 			log.Printf("Repository cloning failed during annex get: %s", stat.Err)
-			return stat.Err
+			return "", stat.Err
 		}
 	}
-	return nil
+	return commit, nil
 }
 
-// repoFileURL returns the full URL to a file on the master branch of a
-// repository.
-func repoFileURL(conf *Configuration, repopath string, filename string) string {
+// repoFileURL returns the full URL to a file at a given revision (commit hash,
+// tag, or branch) of a repository. If the revision is empty, the file on the
+// master branch is used.
+func repoFileURL(conf *Configuration, repopath string, revision string, filename string) string {
 	u, err := url.Parse(GetGINURL(conf))
 	if err != nil {
 		// not configured properly; return nothing
 		return ""
 	}
-	fetchRepoPath := fmt.Sprintf("%s/raw/master/%s", repopath, filename)
+	if revision == "" {
+		revision = "master"
+	}
+	fetchRepoPath := fmt.Sprintf("%s/raw/%s/%s", repopath, revision, filename)
 	u.Path = fetchRepoPath
 	return u.String()
 }
@@ -357,6 +490,12 @@ type RegistrationRequest struct {
 	// Errors during the registration process that get sent in the body of the
 	// email to the administrators.
 	ErrorMessages []string
+	// Revision (commit hash or tag) of the repository to register; the
+	// default branch is used if empty.
+	Revision string
+	// Token of the submission form that binds the submission to the request
+	// page.
+	CSRFToken string
 }
 
 // GetDOIURI replaces scheme and path of the RegistrationRequest.Repository
@@ -376,26 +515,35 @@ func (d *RegistrationRequest) AsHTML() template.HTML {
 }
 
 // readAndValidate loads and checks LICENSE file and datacite.yml file for a
-// given repository. The function tries to collect as many issues as possible
+// given repository at the given revision (master if empty). The function tries to collect as many issues as possible
 // and returns the RepositoryYAML struct or an error message if the retrieval,
 // parsing, or validation fails.  The message is appropriate for display to the
-// user.
-func readAndValidate(conf *Configuration, repository string) (*libgin.RepositoryYAML, error) {
+// user. The embargo date from the optional 'embargo' field of the datacite.yml
+// file is returned as well; it is the zero time if the field is not set.
+func readAndValidate(conf *Configuration, repository string, revision string) (*libgin.RepositoryYAML, time.Time, error) {
+	// Fail on an invalid revision before fetching anything
+	if revision != "" && !isValidRevision(revision) {
+		conf.Metrics.countRejection(rejectInvalidRevision)
+		return nil, time.Time{}, fmt.Errorf("<p>%s</p>", msgInvalidRevision)
+	}
+
 	// Fail registration on missing LICENSE file; do not yet return and check datacite.yml
 	collecterr := make([]string, 0)
-	_, err := readFileAtURL(repoFileURL(conf, repository, "LICENSE"))
+	_, err := readFileAtURL(repoFileURL(conf, repository, revision, "LICENSE"))
 	if err != nil {
 		log.Printf("Failed to fetch LICENSE: %s", err.Error())
 		collecterr = append(collecterr, fmt.Sprintf("<p>%s</p>", msgNoLicenseFile))
+		conf.Metrics.countRejection(rejectNoLicenseFile)
 	}
 
 	// Fail registration on missing datacite.yaml file; can happen if the datacite.yml file
 	// is removed and the user clicks the register button on a stale page
-	dataciteText, err := readFileAtURL(repoFileURL(conf, repository, "datacite.yml"))
+	dataciteText, err := readFileAtURL(repoFileURL(conf, repository, revision, "datacite.yml"))
 	if err != nil {
 		log.Printf("Failed to fetch datacite.yml: %s", err.Error())
 		collecterr = append(collecterr, fmt.Sprintf("<p>%s</p>", msgInvalidDOI))
-		return nil, fmt.Errorf(strings.Join(collecterr, "<br>"))
+		conf.Metrics.countRejection(rejectNoDataCite)
+		return nil, time.Time{}, fmt.Errorf(strings.Join(collecterr, "<br>"))
 	}
 
 	// Fail registration on invalid datacite.yaml file
@@ -403,20 +551,30 @@ func readAndValidate(conf *Configuration, repository string) (*libgin.Repository
 	if err != nil {
 		log.Printf("DOI file invalid: %s", err.Error())
 		collecterr = append(collecterr, fmt.Sprintf("<p>%s<br>Error details: <i>%s</i></p>", msgInvalidDOI, err.Error()))
-		return nil, fmt.Errorf(strings.Join(collecterr, "<br>"))
+		conf.Metrics.countRejection(rejectBadDataCite)
+		return nil, time.Time{}, fmt.Errorf(strings.Join(collecterr, "<br>"))
 	}
 	// Fail registration if any required validation fails
 	if msgs := validateDataCite(repoMetadata); len(msgs) > 0 {
 		log.Print("DOI file contains validation issues")
 		fmtstring := "%s<div align='left' style='padding-left: 50px;'><i><ul><li>%s</li></ul></i></div>"
 		collecterr = append(collecterr, fmt.Sprintf(fmtstring, msgInvalidDOI, strings.Join(msgs, "</li><li>")))
+		conf.Metrics.countRejection(rejectInvalidMetadata)
+	}
+
+	// Fail registration on an invalid or past embargo date
+	embargo, err := readEmbargoYAML(dataciteText)
+	if err != nil {
+		log.Printf("Invalid embargo: %s", err.Error())
+		collecterr = append(collecterr, fmt.Sprintf("<p>%s<br>Error details: <i>%s</i></p>", msgInvalidEmbargo, err.Error()))
+		conf.Metrics.countRejection(rejectInvalidEmbargo)
 	}
 
 	if len(collecterr) > 0 {
-		return nil, fmt.Errorf(strings.Join(collecterr, "<br>"))
+		return nil, time.Time{}, fmt.Errorf(strings.Join(collecterr, "<br>"))
 	}
 
-	return repoMetadata, nil
+	return repoMetadata, embargo, nil
 }
 
 // getPreviousDOI checks if the repository to be registered has a fork with a
@@ -447,6 +605,40 @@ func getPreviousDOI(job *RegistrationJob) string {
 	return ""
 }
 
+// commitRE matches full commit hashes.
+var commitRE = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// resolveCommit returns the commit hash of a revision of a repository on GIN.
+// Branches are resolved through the GIN API and the master branch is used if
+// the revision is empty; full commit hashes are returned as they are. Tags
+// and abbreviated commit hashes cannot be resolved.
+func resolveCommit(conf *Configuration, repository, revision string) (string, error) {
+	if commitRE.MatchString(revision) {
+		return revision, nil
+	}
+	branch := revision
+	if branch == "" {
+		branch = "master"
+	}
+	reqpath := fmt.Sprintf("api/v1/repos/%s/branches/%s", repository, url.PathEscape(branch))
+	resp, err := conf.GIN.Session.Get(reqpath)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get branch %q of %q: %s", branch, repository, resp.Status)
+	}
+	data := gogs.Branch{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", fmt.Errorf("failed to parse branch %q of %q: %s", branch, repository, err.Error())
+	}
+	if data.Commit == nil || !commitRE.MatchString(data.Commit.ID) {
+		return "", fmt.Errorf("no commit for branch %q of %q", branch, repository)
+	}
+	return data.Commit.ID, nil
+}
+
 // getRepoForks returns a list of forks for the repository.
 func getRepoForks(client *ginclient.Client, repo string) ([]gogs.Repository, error) {
 	reqpath := fmt.Sprintf("api/v1/repos/%s/forks", repo)
@@ -512,8 +704,6 @@ func getLatestDOITag(client *ginclient.Client, repo *gogs.Repository, doiBase st
 // binary files that do not compress well, while it might take a decent amount
 // of time in addition.
 func MakeZip(dest io.Writer, exclude []string, source ...string) error {
-	// NOTE: Does not support commits other than master.
-
 	// check sources
 	for _, src := range source {
 		if _, err := os.Stat(src); err != nil {
@@ -4,9 +4,14 @@ import (
 	"archive/zip"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	ginweb "github.com/G-Node/gin-cli/web"
 )
 
 func TestMakeZip(t *testing.T) {
@@ -155,3 +160,32 @@ func TestReadRepoYAML(t *testing.T) {
 		t.Fatalf("Could not read YAML")
 	}
 }
+
+func TestResolveCommit(t *testing.T) {
+	head := "0123456789abcdef0123456789abcdef01234567"
+	gin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/repos/owner/repo/branches/master":
+			fmt.Fprintf(w, `{"name": "master", "commit": {"id": %q}}`, head)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer gin.Close()
+	conf := &Configuration{}
+	conf.GIN.Session = &ginclient.Client{Client: ginweb.New(gin.URL)}
+
+	// The master branch is used for requests without a revision
+	if commit, err := resolveCommit(conf, "owner/repo", ""); err != nil || commit != head {
+		t.Errorf("Unexpected commit of the master branch: %q (%v)", commit, err)
+	}
+	// Full commit hashes are not looked up
+	other := "fedcba9876543210fedcba9876543210fedcba98"
+	if commit, err := resolveCommit(conf, "owner/repo", other); err != nil || commit != other {
+		t.Errorf("Unexpected commit for a commit hash: %q (%v)", commit, err)
+	}
+	// Unknown branches and tags cannot be resolved
+	if commit, err := resolveCommit(conf, "owner/repo", "v1.0"); err == nil {
+		t.Errorf("Unknown revision resolved to %q", commit)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// freeDiskSpace returns the number of bytes available to unprivileged users on
+// the file system of the given directory.
+func freeDiskSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
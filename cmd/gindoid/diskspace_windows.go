@@ -0,0 +1,8 @@
+package main
+
+import "fmt"
+
+// freeDiskSpace is not supported on Windows; the service runs on Linux.
+func freeDiskSpace(dir string) (uint64, error) {
+	return 0, fmt.Errorf("free disk space check not supported on this platform")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// embargodir is the directory of the storage backend where the archives
+	// of embargoed datasets are kept until the embargo ends.
+	embargodir = "embargo"
+	// embargoDateType is the DataCite date type that holds the end of the
+	// embargo of a dataset.
+	embargoDateType = "Available"
+	// embargoCheckInterval is the time between two checks for datasets
+	// whose embargo has ended.
+	embargoCheckInterval = time.Hour
+)
+
+// parseEmbargo parses an embargo date of the form YYYY-MM-DD. The date must
+// lie in the future.
+func parseEmbargo(value string) (time.Time, error) {
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid embargo date %q: expected YYYY-MM-DD", value)
+	}
+	if !time.Now().Before(date) {
+		return time.Time{}, fmt.Errorf("embargo date %s is not in the future", value)
+	}
+	return date, nil
+}
+
+// readEmbargoYAML returns the embargo date from the 'embargo' field of the
+// content of a datacite.yml file. The zero time is returned if the field is
+// not set.
+func readEmbargoYAML(infoyml []byte) (time.Time, error) {
+	embargo := struct {
+		Embargo string `yaml:"embargo"`
+	}{}
+	if err := yaml.Unmarshal(infoyml, &embargo); err != nil {
+		return time.Time{}, err
+	}
+	if embargo.Embargo == "" {
+		return time.Time{}, nil
+	}
+	return parseEmbargo(embargo.Embargo)
+}
+
+// setEmbargo adds the end of the embargo to the dates of a dataset. The
+// embargo of a registration request takes precedence over the one from the
+// datacite.yml file. Nothing is added if neither is set.
+func setEmbargo(md *libgin.RepositoryMetadata, yamlEmbargo time.Time, requested string) {
+	embargo := yamlEmbargo
+	if date, err := parseEmbargo(requested); err == nil {
+		embargo = date
+	}
+	if embargo.IsZero() {
+		return
+	}
+	dates := make([]libgin.Date, 0, len(md.Dates)+1)
+	for _, date := range md.Dates {
+		if date.Type != embargoDateType {
+			dates = append(dates, date)
+		}
+	}
+	md.Dates = append(dates, libgin.Date{Value: embargo.Format("2006-01-02"), Type: embargoDateType})
+}
+
+// embargoDate returns the end of the embargo of a dataset and whether it has
+// one.
+func embargoDate(md *libgin.RepositoryMetadata) (time.Time, bool) {
+	if md == nil || md.DataCite == nil {
+		return time.Time{}, false
+	}
+	for _, date := range md.Dates {
+		if date.Type != embargoDateType {
+			continue
+		}
+		if available, err := time.Parse("2006-01-02", date.Value); err == nil {
+			return available, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isEmbargoed returns true if the data of a dataset are not yet available at
+// the given time.
+func isEmbargoed(md *libgin.RepositoryMetadata, now time.Time) bool {
+	date, ok := embargoDate(md)
+	return ok && now.Before(date)
+}
+
+// EmbargoDate returns the end of the embargo of a dataset in the format DD
+// Mon. YYYY for the landing page, or an empty string if the data are
+// available.
+func EmbargoDate(md *libgin.RepositoryMetadata) string {
+	if !isEmbargoed(md, time.Now()) {
+		return ""
+	}
+	date, _ := embargoDate(md)
+	return date.Format("02 Jan. 2006")
+}
+
+// holdArchives moves the archives of a dataset to the restricted embargo
+// directory, so that the landing page can be made public while the data stay
+// inaccessible.
+func holdArchives(storage StorageBackend, doi string) error {
+	archives, err := storedArchives(storage, doi)
+	if err != nil {
+		return err
+	}
+	edir := path.Join(embargodir, doi)
+	if err := storage.MkdirAll(edir); err != nil {
+		return err
+	}
+	if err := storage.SetPublic(edir, false); err != nil {
+		return err
+	}
+	for _, archive := range archives {
+		if err := moveStorageFile(storage, archive, path.Join(edir, path.Base(archive))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseArchives moves the archives of a dataset from the embargo directory
+// back to the dataset directory. It returns the names of the moved archives.
+func releaseArchives(storage StorageBackend, doi string) ([]string, error) {
+	archives, err := storedArchives(storage, path.Join(embargodir, doi))
+	if err != nil {
+		return nil, err
+	}
+	released := make([]string, 0, len(archives))
+	for _, archive := range archives {
+		target := path.Join(doi, path.Base(archive))
+		if err := moveStorageFile(storage, archive, target); err != nil {
+			return released, err
+		}
+		released = append(released, target)
+	}
+	return released, nil
+}
+
+// liftEmbargo makes the archive of an embargoed dataset available and renders
+// the landing page again with the download link. It returns a description of
+// each step and an error if the archive could not be released.
+func liftEmbargo(conf *Configuration, doi string) ([]string, error) {
+	storage := conf.Storage.Backend
+	messages := make([]string, 0, 2)
+	released, err := releaseArchives(storage, doi)
+	if err != nil {
+		return messages, fmt.Errorf("failed to release the archive: %s", err.Error())
+	}
+	messages = append(messages, fmt.Sprintf("Released %d archive(s) from the embargo", len(released)))
+
+	metadata, err := readStoredMetadata(storage, doi)
+	if err != nil {
+		return messages, fmt.Errorf("failed to read the metadata: %s", err.Error())
+	}
+	var checksum string
+	if manifest, err := readManifest(storage, doi); err == nil {
+		checksum = manifest.Archive.SHA256
+	}
+	if err := createLandingPage(storage, metadata, path.Join(doi, "index.html"), GetGINURL(conf), checksum); err != nil {
+		return messages, fmt.Errorf("failed to render the landing page: %s", err.Error())
+	}
+	return append(messages, "Landing page updated"), nil
+}
+
+// releaseEmbargoes completes the release of the embargoed jobs whose embargo
+// has ended. It returns the number of released jobs.
+func releaseEmbargoes(conf *Configuration, now time.Time) (int, error) {
+	records, err := conf.Jobs.list()
+	if err != nil {
+		return 0, err
+	}
+	nreleased := 0
+	for _, rec := range records {
+		if rec.State != jobEmbargoed || isEmbargoed(rec.Metadata, now) {
+			continue
+		}
+		log.Printf("Embargo of %s ended", rec.ID)
+		for _, msg := range completeRelease(conf, rec) {
+			log.Printf("%s: %s", rec.ID, msg)
+		}
+		nreleased++
+	}
+	return nreleased, nil
+}
+
+// scheduleEmbargoes checks for datasets whose embargo has ended on startup
+// and then every embargoCheckInterval for as long as the service is running.
+func scheduleEmbargoes(live *liveConfig) {
+	for {
+		if n, err := releaseEmbargoes(live.get(), time.Now()); err != nil {
+			log.Printf("Failed to release embargoed datasets: %s", err.Error())
+		} else if n > 0 {
+			log.Printf("Released %d embargoed datasets", n)
+		}
+		time.Sleep(embargoCheckInterval)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestEmbargoDates(t *testing.T) {
+	future := time.Now().AddDate(1, 0, 0).Format("2006-01-02")
+	later := time.Now().AddDate(2, 0, 0).Format("2006-01-02")
+	for _, value := range []string{"", "2020-01-01", "tomorrow", "01.02.2040", time.Now().Format("2006-01-02")} {
+		if _, err := parseEmbargo(value); err == nil {
+			t.Errorf("Invalid embargo date %q accepted", value)
+		}
+	}
+	if _, err := parseEmbargo(future); err != nil {
+		t.Errorf("Error parsing embargo date %q: %v", future, err)
+	}
+
+	if date, err := readEmbargoYAML([]byte("title: Test\n")); err != nil || !date.IsZero() {
+		t.Errorf("Unexpected embargo without embargo field: %v %v", date, err)
+	}
+	if _, err := readEmbargoYAML([]byte("title: Test\nembargo: 2020-01-01\n")); err == nil {
+		t.Error("Past embargo date accepted")
+	}
+	yamlEmbargo, err := readEmbargoYAML([]byte("title: Test\nembargo: " + future + "\n"))
+	if err != nil || yamlEmbargo.Format("2006-01-02") != future {
+		t.Fatalf("Unexpected embargo date: %v %v", yamlEmbargo, err)
+	}
+
+	md := &libgin.RepositoryMetadata{DataCite: new(libgin.DataCite)}
+	md.Dates = []libgin.Date{{Value: "2020-01-01", Type: "Issued"}}
+	setEmbargo(md, time.Time{}, "")
+	if _, ok := embargoDate(md); ok || isEmbargoed(md, time.Now()) || EmbargoDate(md) != "" {
+		t.Errorf("Embargo set without a date: %+v", md.Dates)
+	}
+	setEmbargo(md, yamlEmbargo, "")
+	if date, ok := embargoDate(md); !ok || date.Format("2006-01-02") != future {
+		t.Errorf("Unexpected embargo date: %+v", md.Dates)
+	}
+	// The embargo of the request takes precedence and replaces the date
+	setEmbargo(md, yamlEmbargo, later)
+	if len(md.Dates) != 2 || md.Dates[1].Value != later || md.Dates[1].Type != embargoDateType {
+		t.Errorf("Unexpected dates: %+v", md.Dates)
+	}
+	if !isEmbargoed(md, time.Now()) || isEmbargoed(md, time.Now().AddDate(3, 0, 0)) {
+		t.Error("Unexpected embargo state")
+	}
+	if available := EmbargoDate(md); !strings.HasSuffix(available, later[:4]) {
+		t.Errorf("Unexpected embargo date on the landing page: %q", available)
+	}
+	if ld := datasetLD(md, ""); len(ld.Distribution) != 0 {
+		t.Errorf("Archive of embargoed dataset listed in structured data: %+v", ld.Distribution)
+	}
+}
+
+func TestReleaseEmbargoes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_embargo")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	conf := &Configuration{Jobs: store}
+	conf.GIN.Session = ginclient.New("")
+	conf.Storage.StoreURL = "https://doi.example.org"
+	conf.Storage.PreparationDirectory = filepath.Join(tmpDir, "prep")
+	conf.Storage.Backend = NewLocalStorage(filepath.Join(tmpDir, "target"))
+
+	doi := "10.12751/g-node.aaaaaa"
+	archive := "10.12751_g-node.aaaaaa.zip"
+	yamldata := &libgin.RepositoryYAML{
+		Authors:      []libgin.Author{{FirstName: "Alice", LastName: "Doe"}},
+		Title:        "Embargoed dataset",
+		Description:  "The abstract",
+		Keywords:     []string{"Neuroscience"},
+		License:      &libgin.License{Name: "CC-BY", URL: "https://creativecommons.org/licenses/by/4.0/"},
+		ResourceType: "Dataset",
+	}
+	job := newTestJob(doi, "owner/repo")
+	job.Metadata.ForkRepository = "doi/repo"
+	job.Metadata.YAMLData = yamldata
+	job.Metadata.DataCite = libgin.NewDataCiteFromYAML(yamldata)
+	job.Metadata.Identifier.ID = doi
+	job.Metadata.Dates = []libgin.Date{{Value: "2020-01-01", Type: "Issued"}}
+	setEmbargo(job.Metadata, time.Time{}, time.Now().AddDate(1, 0, 0).Format("2006-01-02"))
+	job.Metadata.AddURLs("https://gin.g-node.org/owner/repo", "https://gin.g-node.org/doi/repo", "https://doi.example.org/"+doi+"/"+archive)
+	xmldata, err := job.Metadata.DataCite.Marshal()
+	if err != nil {
+		t.Fatalf("Error creating XML: %v", err)
+	}
+	dir := filepath.Join(tmpDir, "target", filepath.FromSlash(doi))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatalf("Error creating dataset directory: %v", err)
+	}
+	for name, content := range map[string]string{"doi.xml": xmldata, archive: "archive data"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+	}
+
+	// Archive is held back in the restricted embargo directory
+	if err := holdArchives(conf.Storage.Backend, doi); err != nil {
+		t.Fatalf("Error holding back archive: %v", err)
+	}
+	edir := filepath.Join(tmpDir, "target", embargodir, filepath.FromSlash(doi))
+	if _, err := os.Stat(filepath.Join(dir, archive)); !os.IsNotExist(err) {
+		t.Errorf("Archive of embargoed dataset is still accessible: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(edir, archive)); err != nil {
+		t.Errorf("Archive was not moved to the embargo directory: %v", err)
+	}
+	if public, _ := conf.Storage.Backend.IsPublic(embargodir + "/" + doi); public {
+		t.Error("Embargo directory is publicly accessible")
+	}
+	if archives, err := datasetArchives(conf.Storage.Backend, doi); err != nil || len(archives) != 1 {
+		t.Errorf("Held archive not found: %v %v", archives, err)
+	}
+
+	if err := store.add(job); err != nil {
+		t.Fatalf("Error adding job: %v", err)
+	}
+	store.review(doi, jobEmbargoed, "")
+
+	// Embargo has not ended
+	if n, err := releaseEmbargoes(conf, time.Now()); err != nil || n != 0 {
+		t.Fatalf("Unexpected release before the end of the embargo: %d %v", n, err)
+	}
+	if rec, _ := store.get(doi); rec.State != jobEmbargoed {
+		t.Fatalf("Unexpected state of embargoed job: %s", rec.State)
+	}
+
+	// Embargo has ended
+	if n, err := releaseEmbargoes(conf, time.Now().AddDate(2, 0, 0)); err != nil || n != 1 {
+		t.Fatalf("Unexpected release after the end of the embargo: %d %v", n, err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, archive)); err != nil || string(data) != "archive data" {
+		t.Errorf("Archive was not released: %q %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(edir, archive)); !os.IsNotExist(err) {
+		t.Errorf("Archive was not removed from the embargo directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+		t.Errorf("Landing page was not rendered: %v", err)
+	}
+	if rec, _ := store.get(doi); rec.State != jobReleased {
+		t.Errorf("Unexpected state of released job: %s", rec.State)
+	}
+}
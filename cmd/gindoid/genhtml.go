@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/G-Node/libgin/libgin"
@@ -11,71 +12,132 @@ import (
 )
 
 // mkhtml reads the provided XML files or URLs and generates the HTML landing
-// page for each.
+// page for each. The tombstone page is generated for withdrawn datasets.
 func mkhtml(cmd *cobra.Command, args []string) {
 	fmt.Printf("Generating %d pages\n", len(args))
 	var success int
 	for idx, filearg := range args {
 		fmt.Printf("%3d: %s\n", idx, filearg)
-		var contents []byte
-		var err error
-		if isURL(filearg) {
-			contents, err = readFileAtURL(filearg)
-		} else {
-			contents, err = readFileAtPath(filearg)
-		}
-		if err != nil {
-			fmt.Printf("Failed to read file at %q: %s\n", filearg, err.Error())
-			continue
-		}
-
-		datacite := new(libgin.DataCite)
-		err = xml.Unmarshal(contents, datacite)
+		metadata, err := readMetadataXML(filearg)
 		if err != nil {
-			fmt.Printf("Failed to unmarshal contents of %q: %s\n", filearg, err.Error())
+			fmt.Println(err.Error())
 			continue
 		}
-		metadata := &libgin.RepositoryMetadata{
-			DataCite: datacite,
-		}
-
-		// find URLs in RelatedIdentifiers
-		for _, relid := range metadata.RelatedIdentifiers {
-			switch u := strings.ToLower(relid.Identifier); {
-			case strings.HasPrefix(u, "https://gin.g-node.org/doi/"):
-				// fork URL
-				metadata.ForkRepository = strings.TrimPrefix(relid.Identifier, "https://gin.g-node.org/")
-			case strings.HasPrefix(u, "https://web.gin.g-node.org/doi"):
-				// fork URL (old)
-				metadata.ForkRepository = strings.TrimPrefix(relid.Identifier, "https://web.gin.g-node.org/")
-			case strings.HasPrefix(u, "https://gin.g-node.org/"):
-				// repo URL
-				metadata.SourceRepository = strings.TrimPrefix(relid.Identifier, "https://gin.g-node.org/")
-			case strings.HasPrefix(u, "https://web.gin.g-node.org/"):
-				// repo URL (old)
-				metadata.SourceRepository = strings.TrimPrefix(relid.Identifier, "https://web.gin.g-node.org/")
-			}
-		}
 
 		fname := fmt.Sprintf("%s/index.html", metadata.Identifier.ID)
 		// If no DOI was found in the file do not create directory and
-		// fall back to the argument number.
+		// fall back to the argument number. The citation files are only
+		// written to the dataset directory.
+		citedir := metadata.Identifier.ID
 		if metadata.Identifier.ID == "" {
 			fmt.Println("WARNING: Couldn't determine DOI. Using generic filename.")
 			fname = fmt.Sprintf("%03d-index.html", idx)
+			citedir = ""
 		} else if err = os.MkdirAll(metadata.Identifier.ID, 0777); err != nil {
 			fmt.Printf("WARNING: Could not create directory: %q", err.Error())
 			fname = fmt.Sprintf("%s-index.html", metadata.Identifier.ID)
+			citedir = ""
 		}
-		if err := createLandingPage(metadata, fname, ""); err != nil {
+		storage := NewLocalStorage("")
+		if isWithdrawn(metadata) {
+			// Withdrawn datasets keep their tombstone page
+			if err := createTombstonePage(storage, metadata, fname, ""); err != nil {
+				fmt.Printf("Failed to render tombstone page for %q: %s\n", filearg, err.Error())
+				continue
+			}
+		} else if err := createLandingPage(storage, metadata, fname, "", readArchiveChecksum(filearg)); err != nil {
 			fmt.Printf("Failed to render landing page for %q: %s\n", filearg, err.Error())
 			continue
 		}
 
 		fmt.Printf("\t-> %s\n", fname)
+		if citedir != "" {
+			if err := writeCitations(storage, metadata, citedir); err != nil {
+				fmt.Printf("WARNING: Failed to write citation files for %q: %s\n", filearg, err.Error())
+			} else {
+				fmt.Printf("\t-> %s/citation files\n", citedir)
+			}
+		}
 		// all good
 		success++
 	}
 
 	fmt.Printf("%d/%d jobs completed successfully\n", success, len(args))
 }
+
+// readMetadataXML reads the DataCite XML file at the given path or URL and
+// returns the dataset metadata (see parseMetadataXML).
+func readMetadataXML(filearg string) (*libgin.RepositoryMetadata, error) {
+	var contents []byte
+	var err error
+	if isURL(filearg) {
+		contents, err = readFileAtURL(filearg)
+	} else {
+		contents, err = readFileAtPath(filearg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file at %q: %s", filearg, err.Error())
+	}
+	metadata, err := parseMetadataXML(contents)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal contents of %q: %s", filearg, err.Error())
+	}
+	return metadata, nil
+}
+
+// parseMetadataXML unmarshals the content of a DataCite XML file and returns
+// the dataset metadata. The source and fork repositories are determined from
+// the URLs in the related identifiers.
+func parseMetadataXML(contents []byte) (*libgin.RepositoryMetadata, error) {
+	datacite := new(libgin.DataCite)
+	if err := xml.Unmarshal(contents, datacite); err != nil {
+		return nil, err
+	}
+	metadata := &libgin.RepositoryMetadata{
+		DataCite: datacite,
+	}
+
+	// find URLs in RelatedIdentifiers
+	for _, relid := range metadata.RelatedIdentifiers {
+		switch u := strings.ToLower(relid.Identifier); {
+		case commitURLRE.MatchString(u):
+			// registered commit URL
+			continue
+		case strings.HasPrefix(u, "https://gin.g-node.org/doi/"):
+			// fork URL
+			metadata.ForkRepository = strings.TrimPrefix(relid.Identifier, "https://gin.g-node.org/")
+		case strings.HasPrefix(u, "https://web.gin.g-node.org/doi"):
+			// fork URL (old)
+			metadata.ForkRepository = strings.TrimPrefix(relid.Identifier, "https://web.gin.g-node.org/")
+		case strings.HasPrefix(u, "https://gin.g-node.org/"):
+			// repo URL
+			metadata.SourceRepository = strings.TrimPrefix(relid.Identifier, "https://gin.g-node.org/")
+		case strings.HasPrefix(u, "https://web.gin.g-node.org/"):
+			// repo URL (old)
+			metadata.SourceRepository = strings.TrimPrefix(relid.Identifier, "https://web.gin.g-node.org/")
+		}
+	}
+	return metadata, nil
+}
+
+// readArchiveChecksum reads the checksum manifest stored next to the given XML
+// file path or URL and returns the archive checksum. It returns an empty
+// string if no manifest is found.
+func readArchiveChecksum(xmlloc string) string {
+	var contents []byte
+	var err error
+	if isURL(xmlloc) {
+		contents, err = readFileAtURL(xmlloc[:strings.LastIndex(xmlloc, "/")+1] + manifestfname)
+	} else {
+		contents, err = readFileAtPath(filepath.Join(filepath.Dir(xmlloc), manifestfname))
+	}
+	if err != nil {
+		return ""
+	}
+	manifest, err := parseManifest(contents)
+	if err != nil {
+		fmt.Printf("WARNING: Failed to parse checksum manifest for %q: %s\n", xmlloc, err.Error())
+		return ""
+	}
+	return manifest.Archive.SHA256
+}
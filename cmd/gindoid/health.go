@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// Status values of the health checks, from best to worst.
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthFailed   = "failed"
+)
+
+const (
+	// healthCacheTime is the time for which the results of the dependency
+	// checks are reused, so that frequent probes do not put load on the GIN
+	// server.
+	healthCacheTime = 15 * time.Second
+	// healthTimeout is the time after which a connection attempt of a
+	// dependency check is given up.
+	healthTimeout = 5 * time.Second
+)
+
+// healthCheck is the result of a single check of the health report.
+type healthCheck struct {
+	Name    string
+	Status  string
+	Message string `json:",omitempty"`
+}
+
+// healthReport is the response of the health and readiness endpoints. The
+// status is the worst status of all checks.
+type healthReport struct {
+	Status  string
+	Checked time.Time
+	Checks  []healthCheck
+}
+
+// healthChecker checks the dependencies of the service for the health and
+// readiness endpoints.
+type healthChecker struct {
+	conf       *Configuration
+	jobQueue   chan *RegistrationJob
+	dispatcher *Dispatcher
+
+	// Cached results of the dependency checks
+	mutex   sync.Mutex
+	cached  []healthCheck
+	checked time.Time
+}
+
+// newHealthChecker returns a healthChecker for the service with the given
+// configuration, job queue, and dispatcher.
+func newHealthChecker(conf *Configuration, jobQueue chan *RegistrationJob, dispatcher *Dispatcher) *healthChecker {
+	return &healthChecker{conf: conf, jobQueue: jobQueue, dispatcher: dispatcher}
+}
+
+// report runs the checks and returns the health report. The state of the
+// worker pool is always current; the results of the dependency checks are
+// reused for healthCacheTime.
+func (hc *healthChecker) report() healthReport {
+	hc.mutex.Lock()
+	if time.Since(hc.checked) >= healthCacheTime {
+		checks := checkGIN(hc.conf)
+		checks = append(checks, checkSMTP(hc.conf))
+		checks = append(checks, checkDiskSpace(hc.conf)...)
+		hc.cached = checks
+		hc.checked = time.Now()
+	}
+	report := healthReport{Checked: hc.checked}
+	report.Checks = append([]healthCheck{hc.checkWorkers()}, hc.cached...)
+	hc.mutex.Unlock()
+
+	report.Status = healthOK
+	for _, check := range report.Checks {
+		report.Status = worseHealth(report.Status, check.Status)
+	}
+	return report
+}
+
+// checkWorkers reports the state of the worker pool. It fails if the service
+// is shutting down and is degraded if all workers are busy and more jobs are
+// waiting than the queue can hold.
+func (hc *healthChecker) checkWorkers() healthCheck {
+	check := healthCheck{Name: "workers", Status: healthOK}
+	busy, size := hc.dispatcher.busy(), hc.dispatcher.size()
+	waiting := len(hc.jobQueue) + hc.dispatcher.waiting()
+	check.Message = fmt.Sprintf("%d of %d workers busy, %d jobs waiting (queue capacity %d)", busy, size, waiting, cap(hc.jobQueue))
+	if hc.dispatcher.stopped() {
+		check.Status = healthFailed
+		check.Message = "service is shutting down"
+	} else if waiting >= cap(hc.jobQueue) {
+		check.Status = healthDegraded
+	}
+	return check
+}
+
+// serveHealth serves the liveness report. The status code is 503 only if the
+// service itself is unusable, i.e., the worker pool has stopped; failures of
+// the dependencies are reported but do not fail the check.
+func (hc *healthChecker) serveHealth(w http.ResponseWriter, r *http.Request) {
+	report := hc.report()
+	code := http.StatusOK
+	if report.Checks[0].Status == healthFailed {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealthReport(w, code, report)
+}
+
+// serveReady serves the readiness report. The status code is 503 if any of
+// the checks failed.
+func (hc *healthChecker) serveReady(w http.ResponseWriter, r *http.Request) {
+	report := hc.report()
+	code := http.StatusOK
+	if report.Status == healthFailed {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealthReport(w, code, report)
+}
+
+func writeHealthReport(w http.ResponseWriter, code int, report healthReport) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Failed to render health report: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// checkGIN checks that the GIN web API is reachable and that the session of
+// the DOI user is still valid. An expired session is renewed by logging in
+// again.
+func checkGIN(conf *Configuration) []healthCheck {
+	api := healthCheck{Name: "gin-api", Status: healthOK}
+	session := healthCheck{Name: "gin-session", Status: healthOK}
+	client := conf.GIN.Session
+	resp, err := client.Get("api/v1/user")
+	if err != nil {
+		api.Status, api.Message = healthFailed, fmt.Sprintf("GIN server not reachable: %s", err.Error())
+		session.Status, session.Message = healthFailed, "session cannot be verified while the GIN server is not reachable"
+		return []healthCheck{api, session}
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		api.Status, api.Message = healthFailed, fmt.Sprintf("GIN server error: %s", resp.Status)
+		session.Status, session.Message = healthFailed, "session cannot be verified while the GIN server fails"
+		return []healthCheck{api, session}
+	}
+	if resp.StatusCode == http.StatusOK {
+		session.Message = fmt.Sprintf("logged in as %s", conf.GIN.Username)
+		return []healthCheck{api, session}
+	}
+
+	log.Printf("GIN session is no longer valid (%s); logging in again", resp.Status)
+	if err := client.Login(conf.GIN.Username, conf.GIN.Password, "gin-doi"); err != nil {
+		log.Printf("Failed to log in to GIN: %s", err.Error())
+		session.Status, session.Message = healthFailed, fmt.Sprintf("session expired and login failed: %s", err.Error())
+	} else {
+		session.Message = fmt.Sprintf("session renewed for %s", conf.GIN.Username)
+	}
+	return []healthCheck{api, session}
+}
+
+// checkSMTP checks that the configured mail server accepts connections. The
+// check is degraded instead of failed on errors, since the registrations do
+// not depend on the notifications.
+func checkSMTP(conf *Configuration) healthCheck {
+	check := healthCheck{Name: "smtp", Status: healthOK}
+	if conf.Email.Server == "" {
+		check.Message = "no mail server configured; notifications are only logged"
+		return check
+	}
+	conn, err := net.DialTimeout("tcp", conf.Email.Server, healthTimeout)
+	if err != nil {
+		check.Status, check.Message = healthDegraded, fmt.Sprintf("mail server not reachable: %s", err.Error())
+		return check
+	}
+	conn.Close()
+	return check
+}
+
+// checkDiskSpace checks the free space in the preparation directory and, for
+// the local storage backend, in the target directory.
+func checkDiskSpace(conf *Configuration) []healthCheck {
+	dirs := []struct{ name, path string }{{"disk-preparation", conf.Storage.PreparationDirectory}}
+	if _, ok := conf.Storage.Backend.(*LocalStorage); ok {
+		dirs = append(dirs, struct{ name, path string }{"disk-target", conf.Storage.TargetDirectory})
+	}
+	checks := make([]healthCheck, 0, len(dirs))
+	for _, dir := range dirs {
+		check := healthCheck{Name: dir.name, Status: healthOK}
+		free, err := freeDiskSpace(dir.path)
+		if err != nil {
+			check.Status, check.Message = healthFailed, fmt.Sprintf("failed to determine the free space of %s: %s", dir.path, err.Error())
+		} else {
+			check.Message = fmt.Sprintf("%s free in %s", humanize.IBytes(free), dir.path)
+			if free < conf.MinFreeSpace {
+				check.Status = healthFailed
+				check.Message = fmt.Sprintf("%s (minimum %s)", check.Message, humanize.IBytes(conf.MinFreeSpace))
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// worseHealth returns the worse of two health statuses.
+func worseHealth(a, b string) string {
+	rank := map[string]int{healthOK: 0, healthDegraded: 1, healthFailed: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	ginweb "github.com/G-Node/gin-cli/web"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_health")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Fake GIN server: the session is valid until it expires and logging in
+	// again fails
+	status := http.StatusOK
+	gin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/user":
+			w.WriteHeader(status)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer gin.Close()
+
+	conf := &Configuration{}
+	conf.GIN.Username = "doiuser"
+	conf.GIN.Session = &ginclient.Client{Client: ginweb.New(gin.URL)}
+	conf.Storage.PreparationDirectory = tmpDir
+	conf.Storage.TargetDirectory = filepath.Join(tmpDir, "target")
+	conf.Storage.Backend = NewLocalStorage(conf.Storage.TargetDirectory)
+	if err := os.MkdirAll(conf.Storage.TargetDirectory, 0777); err != nil {
+		t.Fatalf("Error creating target directory: %v", err)
+	}
+	// Mail server that accepts connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Close()
+	conf.Email.Server = listener.Addr().String()
+
+	jobQueue := make(chan *RegistrationJob, 1)
+	dispatcher := newDispatcher(jobQueue, 2)
+	health := newHealthChecker(conf, jobQueue, dispatcher)
+
+	get := func(handler http.HandlerFunc) (int, healthReport) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		report := healthReport{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Error parsing health report: %v\n%s", err, rec.Body.String())
+		}
+		return rec.Code, report
+	}
+	statusOf := func(report healthReport, name string) string {
+		for _, check := range report.Checks {
+			if check.Name == name {
+				return check.Status
+			}
+		}
+		t.Fatalf("Check %q missing from report: %+v", name, report)
+		return ""
+	}
+
+	code, report := get(health.serveReady)
+	if code != http.StatusOK || report.Status != healthOK {
+		t.Fatalf("Unexpected readiness: [%d] %+v", code, report)
+	}
+	for _, name := range []string{"workers", "gin-api", "gin-session", "smtp", "disk-preparation", "disk-target"} {
+		if status := statusOf(report, name); status != healthOK {
+			t.Errorf("Unexpected status of %s: %s", name, status)
+		}
+	}
+
+	// Results of the dependency checks are cached
+	status = http.StatusUnauthorized
+	if _, report := get(health.serveReady); report.Status != healthOK {
+		t.Errorf("Dependency checks were not cached: %+v", report)
+	}
+
+	// Expired session that cannot be renewed and unreachable mail server
+	listener.Close()
+	health.checked = time.Time{}
+	code, report = get(health.serveReady)
+	if code != http.StatusServiceUnavailable || report.Status != healthFailed {
+		t.Errorf("Unexpected readiness with expired session: [%d] %+v", code, report)
+	}
+	if statusOf(report, "gin-api") != healthOK || statusOf(report, "gin-session") != healthFailed || statusOf(report, "smtp") != healthDegraded {
+		t.Errorf("Unexpected checks with expired session: %+v", report)
+	}
+	// Liveness does not depend on the dependencies
+	if code, _ := get(health.serveHealth); code != http.StatusOK {
+		t.Errorf("Unexpected liveness status code: %d", code)
+	}
+
+	// Unreachable GIN server and insufficient disk space
+	gin.Close()
+	conf.MinFreeSpace = 1 << 62
+	health.checked = time.Time{}
+	_, report = get(health.serveReady)
+	if statusOf(report, "gin-api") != healthFailed || statusOf(report, "disk-preparation") != healthFailed {
+		t.Errorf("Unexpected checks with unreachable GIN server: %+v", report)
+	}
+
+	// Stopped worker pool
+	dispatcher.stop(time.Second)
+	if code, report := get(health.serveHealth); code != http.StatusServiceUnavailable || statusOf(report, "workers") != healthFailed {
+		t.Errorf("Unexpected liveness while shutting down: [%d] %+v", code, report)
+	}
+}
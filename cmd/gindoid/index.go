@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// sitemapFile is the name of the generated sitemap.
+	sitemapFile = "sitemap.xml"
+	// feedFile is the name of the generated Atom feed.
+	feedFile = "atom.xml"
+	// keywordsDir is the path under the base URL where the keyword pages
+	// generated by make-keyword-pages are served.
+	keywordsDir = "keywords"
+)
+
+// indexPageLink is an entry in the pagination menu of the dataset index.
+type indexPageLink struct {
+	Number int
+	File   string
+}
+
+// indexPage holds the data for rendering one page of the dataset index.
+type indexPage struct {
+	Datasets []*libgin.RepositoryMetadata
+	Total    int
+	Page     int
+	NPages   int
+	Pages    []indexPageLink
+	Prev     string
+	Next     string
+	FeedFile string
+}
+
+// sitemapURL is a single location in the sitemap.
+type sitemapURL struct {
+	Loc     string
+	LastMod string
+}
+
+// atomEntry is a single dataset in the Atom feed.
+type atomEntry struct {
+	ID      string
+	Title   string
+	Updated string
+	Link    string
+	Authors []string
+	Summary string
+}
+
+// atomFeed holds the data for rendering the Atom feed.
+type atomFeed struct {
+	ID      string
+	Home    string
+	Updated string
+	Entries []atomEntry
+}
+
+// mkindex reads the provided XML files or URLs and generates the
+// chronological dataset index pages, the sitemap and the Atom feed in the
+// current directory. Withdrawn datasets are not listed.
+func mkindex(cmd *cobra.Command, args []string) {
+	baseurl, _ := cmd.Flags().GetString("url")
+	pagesize, _ := cmd.Flags().GetInt("page-size")
+	feedsize, _ := cmd.Flags().GetInt("feed-size")
+	if pagesize < 1 || feedsize < 1 {
+		fmt.Fprintln(os.Stderr, "ERROR: --page-size and --feed-size must be positive")
+		os.Exit(1)
+	}
+
+	fmt.Println("Reading files")
+	datasets := make([]*libgin.RepositoryMetadata, 0, len(args))
+	seen := make(map[string]bool)
+	for idx, filearg := range args {
+		metadata, err := readMetadataXML(filearg)
+		if err != nil {
+			fmt.Println(err.Error())
+			continue
+		}
+		doi := metadata.Identifier.ID
+		if doi == "" {
+			fmt.Printf("Skipping %q: no DOI found\n", filearg)
+			continue
+		}
+		if seen[doi] {
+			continue
+		}
+		if isWithdrawn(metadata) {
+			fmt.Printf("Skipping %q: dataset was withdrawn\n", filearg)
+			continue
+		}
+		seen[doi] = true
+		datasets = append(datasets, metadata)
+		fmt.Printf(" %d/%d\r", idx+1, len(args))
+	}
+	fmt.Printf("\nFound %d datasets\n", len(datasets))
+
+	storage := NewLocalStorage("")
+	npages, err := createDatasetIndex(storage, datasets, pagesize)
+	if err != nil {
+		fmt.Printf("Failed to create the dataset index: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("\t-> %d index page(s)\n", npages)
+	if err := createSitemap(storage, datasets, baseurl, npages); err != nil {
+		fmt.Printf("Failed to create the sitemap: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("\t-> %s\n", sitemapFile)
+	if err := createAtomFeed(storage, datasets, baseurl, feedsize); err != nil {
+		fmt.Printf("Failed to create the Atom feed: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("\t-> %s\n", feedFile)
+}
+
+// sortDatasets sorts datasets by issued date, newest first. Datasets issued
+// on the same date are sorted by DOI so that the output is stable.
+func sortDatasets(datasets []*libgin.RepositoryMetadata) {
+	sort.SliceStable(datasets, func(i, j int) bool {
+		idate, jdate := issuedTime(datasets[i]), issuedTime(datasets[j])
+		if idate.Equal(jdate) {
+			return datasets[i].Identifier.ID < datasets[j].Identifier.ID
+		}
+		return idate.After(jdate)
+	})
+}
+
+// issuedTime returns the issued date of a dataset, falling back to the
+// beginning of the publication year. The zero time is returned if neither is
+// available.
+func issuedTime(md *libgin.RepositoryMetadata) time.Time {
+	if date, err := time.Parse("2006-01-02", issuedDate(md)); err == nil {
+		return date
+	}
+	if md.Year != 0 {
+		return time.Date(md.Year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+// indexPageFile returns the file name of the given page of the dataset index.
+// The first page is the index.html file.
+func indexPageFile(page int) string {
+	if page <= 1 {
+		return "index.html"
+	}
+	return fmt.Sprintf("index-%d.html", page)
+}
+
+// renderTemplate executes the named templates with the given data and writes
+// the result to the target file in the storage backend. XML files are
+// prefixed with the XML declaration, which html/template would escape if it
+// were part of the template.
+func renderTemplate(storage StorageBackend, targetfile string, data interface{}, templateNames ...string) error {
+	tmpl, err := prepareTemplates(templateNames...)
+	if err != nil {
+		return err
+	}
+	fp, err := storage.Create(targetfile)
+	if err != nil {
+		log.Printf("Could not create %s: %s", targetfile, err.Error())
+		return err
+	}
+	if path.Ext(targetfile) == ".xml" {
+		if _, err := io.WriteString(fp, xml.Header); err != nil {
+			fp.Close()
+			return err
+		}
+	}
+	if err := tmpl.Execute(fp, data); err != nil {
+		fp.Close()
+		log.Printf("Error rendering %s: %s", targetfile, err.Error())
+		return err
+	}
+	return fp.Close()
+}
+
+// createDatasetIndex sorts the datasets by date and writes the paginated HTML
+// index with at most pagesize datasets per page. It returns the number of
+// pages written.
+func createDatasetIndex(storage StorageBackend, datasets []*libgin.RepositoryMetadata, pagesize int) (int, error) {
+	sortDatasets(datasets)
+	npages := (len(datasets) + pagesize - 1) / pagesize
+	if npages == 0 {
+		// Always write the first page
+		npages = 1
+	}
+	pages := make([]indexPageLink, npages)
+	for idx := range pages {
+		pages[idx] = indexPageLink{Number: idx + 1, File: indexPageFile(idx + 1)}
+	}
+	for page := 1; page <= npages; page++ {
+		start := (page - 1) * pagesize
+		end := start + pagesize
+		if end > len(datasets) {
+			end = len(datasets)
+		}
+		data := indexPage{
+			Datasets: datasets[start:end],
+			Total:    len(datasets),
+			Page:     page,
+			NPages:   npages,
+			Pages:    pages,
+			FeedFile: feedFile,
+		}
+		if page > 1 {
+			data.Prev = indexPageFile(page - 1)
+		}
+		if page < npages {
+			data.Next = indexPageFile(page + 1)
+		}
+		if err := renderTemplate(storage, indexPageFile(page), data, "DatasetIndex"); err != nil {
+			return page - 1, err
+		}
+	}
+	return npages, nil
+}
+
+// createSitemap writes the sitemap listing the dataset index pages, the
+// landing page of each dataset and the keyword pages under the base URL.
+func createSitemap(storage StorageBackend, datasets []*libgin.RepositoryMetadata, baseurl string, npages int) error {
+	baseurl = strings.TrimSuffix(baseurl, "/")
+	urls := make([]sitemapURL, 0, npages+len(datasets)+1)
+	for page := 1; page <= npages; page++ {
+		loc := fmt.Sprintf("%s/%s", baseurl, indexPageFile(page))
+		if page == 1 {
+			loc = baseurl + "/"
+		}
+		urls = append(urls, sitemapURL{Loc: loc})
+	}
+
+	keywords := make(map[string]bool)
+	for _, md := range datasets {
+		entry := sitemapURL{Loc: fmt.Sprintf("%s/%s/", baseurl, md.Identifier.ID)}
+		if date := issuedTime(md); !date.IsZero() {
+			entry.LastMod = date.Format("2006-01-02")
+		}
+		urls = append(urls, entry)
+		if md.Subjects == nil {
+			continue
+		}
+		for _, kw := range *md.Subjects {
+			keywords[KeywordPath(kw)] = true
+		}
+	}
+
+	if len(keywords) > 0 {
+		urls = append(urls, sitemapURL{Loc: fmt.Sprintf("%s/%s/", baseurl, keywordsDir)})
+		kwlist := make([]string, 0, len(keywords))
+		for kw := range keywords {
+			kwlist = append(kwlist, kw)
+		}
+		sort.Strings(kwlist)
+		for _, kw := range kwlist {
+			urls = append(urls, sitemapURL{Loc: fmt.Sprintf("%s/%s/%s/", baseurl, keywordsDir, kw)})
+		}
+	}
+	return renderTemplate(storage, sitemapFile, urls, "Sitemap")
+}
+
+// createAtomFeed writes the Atom feed with the feedsize most recently issued
+// datasets.
+func createAtomFeed(storage StorageBackend, datasets []*libgin.RepositoryMetadata, baseurl string, feedsize int) error {
+	baseurl = strings.TrimSuffix(baseurl, "/")
+	sortDatasets(datasets)
+	if len(datasets) > feedsize {
+		datasets = datasets[:feedsize]
+	}
+	// Datasets without an issued date fall back to the time of the feed
+	// update; a zero time would be reported as 0001-01-01
+	now := time.Now().UTC().Format(time.RFC3339)
+	feed := atomFeed{
+		ID:      fmt.Sprintf("%s/%s", baseurl, feedFile),
+		Home:    baseurl + "/",
+		Updated: now,
+		Entries: make([]atomEntry, len(datasets)),
+	}
+	if len(datasets) > 0 {
+		if issued := issuedTime(datasets[0]); !issued.IsZero() {
+			feed.Updated = issued.Format(time.RFC3339)
+		}
+	}
+	for idx, md := range datasets {
+		entry := atomEntry{
+			ID:      doiURL(md.Identifier.ID),
+			Updated: now,
+			Link:    fmt.Sprintf("%s/%s/", baseurl, md.Identifier.ID),
+			Authors: make([]string, len(md.Creators)),
+		}
+		if issued := issuedTime(md); !issued.IsZero() {
+			entry.Updated = issued.Format(time.RFC3339)
+		}
+		if len(md.Titles) > 0 {
+			entry.Title = md.Titles[0]
+		}
+		for cidx, creator := range md.Creators {
+			entry.Authors[cidx] = creator.Name
+		}
+		for _, desc := range md.Descriptions {
+			if desc.Type == "Abstract" {
+				entry.Summary = desc.Content
+				break
+			}
+		}
+		feed.Entries[idx] = entry
+	}
+	return renderTemplate(storage, feedFile, feed, "AtomFeed")
+}
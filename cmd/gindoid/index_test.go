@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestDatasetIndex(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_index")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	datasets := make([]*libgin.RepositoryMetadata, 5)
+	for idx := range datasets {
+		yamldata := &libgin.RepositoryYAML{
+			Authors:      []libgin.Author{{FirstName: "Alice", LastName: "Doe"}},
+			Title:        fmt.Sprintf("Dataset %d & more", idx),
+			Description:  fmt.Sprintf("Description <%d>", idx),
+			Keywords:     []string{"Neuroscience", fmt.Sprintf("Keyword %d", idx%2)},
+			License:      &libgin.License{Name: "CC-BY", URL: "https://creativecommons.org/licenses/by/4.0/"},
+			ResourceType: "Dataset",
+		}
+		md := &libgin.RepositoryMetadata{
+			YAMLData: yamldata,
+			DataCite: libgin.NewDataCiteFromYAML(yamldata),
+		}
+		md.Identifier.ID = fmt.Sprintf("10.12751/g-node.%06d", idx)
+		md.Dates = []libgin.Date{{Value: fmt.Sprintf("2020-0%d-01", idx+1), Type: "Issued"}}
+		datasets[idx] = md
+	}
+
+	storage := NewLocalStorage(tmpDir)
+	npages, err := createDatasetIndex(storage, datasets, 2)
+	if err != nil {
+		t.Fatalf("Error creating dataset index: %v", err)
+	}
+	if npages != 3 {
+		t.Fatalf("Unexpected number of pages: %d", npages)
+	}
+	// Newest first
+	if datasets[0].Identifier.ID != "10.12751/g-node.000004" || datasets[4].Identifier.ID != "10.12751/g-node.000000" {
+		t.Fatalf("Datasets not sorted by date: %s ... %s", datasets[0].Identifier.ID, datasets[4].Identifier.ID)
+	}
+	readFile := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Fatalf("Error reading %s: %v", name, err)
+		}
+		return string(data)
+	}
+	first := readFile("index.html")
+	if !strings.Contains(first, "10.12751/g-node.000004") || strings.Contains(first, "10.12751/g-node.000002") || !strings.Contains(first, `href="index-2.html">&raquo;`) {
+		t.Errorf("Unexpected first index page:\n%s", first)
+	}
+	last := readFile("index-3.html")
+	if !strings.Contains(last, "10.12751/g-node.000000") || !strings.Contains(last, `href="index-2.html">&laquo;`) || strings.Contains(last, "&raquo;") {
+		t.Errorf("Unexpected last index page:\n%s", last)
+	}
+
+	if err := createSitemap(storage, datasets, "https://doi.example.org/", npages); err != nil {
+		t.Fatalf("Error creating sitemap: %v", err)
+	}
+	if !strings.HasPrefix(readFile(sitemapFile), xml.Header+"<urlset") {
+		t.Fatalf("Missing or escaped XML declaration in sitemap:\n%s", readFile(sitemapFile))
+	}
+	var sitemap struct {
+		URLs []struct {
+			Loc     string `xml:"loc"`
+			LastMod string `xml:"lastmod"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal([]byte(readFile(sitemapFile)), &sitemap); err != nil {
+		t.Fatalf("Invalid sitemap: %v", err)
+	}
+	// 3 index pages, 5 landing pages, the keyword index and 3 keywords
+	if len(sitemap.URLs) != 12 {
+		t.Fatalf("Unexpected number of sitemap URLs: %d", len(sitemap.URLs))
+	}
+	locs := make(map[string]string)
+	for _, u := range sitemap.URLs {
+		locs[u.Loc] = u.LastMod
+	}
+	for loc, lastmod := range map[string]string{
+		"https://doi.example.org/":                        "",
+		"https://doi.example.org/index-3.html":            "",
+		"https://doi.example.org/10.12751/g-node.000002/": "2020-03-01",
+		"https://doi.example.org/keywords/":               "",
+		"https://doi.example.org/keywords/neuroscience/":  "",
+	} {
+		if mod, ok := locs[loc]; !ok || mod != lastmod {
+			t.Errorf("Sitemap entry %s missing or wrong (lastmod %q)", loc, mod)
+		}
+	}
+
+	if err := createAtomFeed(storage, datasets, "https://doi.example.org", 3); err != nil {
+		t.Fatalf("Error creating Atom feed: %v", err)
+	}
+	var feed struct {
+		ID      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Title   string `xml:"title"`
+			Updated string `xml:"updated"`
+			Link    struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Summary string `xml:"summary"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal([]byte(readFile(feedFile)), &feed); err != nil {
+		t.Fatalf("Invalid Atom feed: %v", err)
+	}
+	if feed.ID != "https://doi.example.org/atom.xml" || feed.Updated != "2020-05-01T00:00:00Z" || len(feed.Entries) != 3 {
+		t.Fatalf("Unexpected Atom feed: %+v", feed)
+	}
+	entry := feed.Entries[0]
+	if entry.ID != "https://doi.org/10.12751/g-node.000004" || entry.Title != "Dataset 4 & more" || entry.Summary != "Description <4>" || entry.Link.Href != "https://doi.example.org/10.12751/g-node.000004/" {
+		t.Errorf("Unexpected Atom entry: %+v", entry)
+	}
+
+	// Datasets without an issued date keep the time of the feed update
+	undated := *datasets[0].DataCite
+	undated.Dates = nil
+	undated.Year = 0
+	if err := createAtomFeed(storage, []*libgin.RepositoryMetadata{{DataCite: &undated}}, "https://doi.example.org", 3); err != nil {
+		t.Fatalf("Error creating Atom feed: %v", err)
+	}
+	feed.Entries = nil
+	if err := xml.Unmarshal([]byte(readFile(feedFile)), &feed); err != nil {
+		t.Fatalf("Invalid Atom feed: %v", err)
+	}
+	if strings.HasPrefix(feed.Updated, "0001-") || len(feed.Entries) != 1 || strings.HasPrefix(feed.Entries[0].Updated, "0001-") {
+		t.Errorf("Zero update time in Atom feed: %+v", feed)
+	}
+}
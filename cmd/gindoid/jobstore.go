@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+// jobsdir is the name of the directory inside the preparation directory where
+// the job records are stored.
+const jobsdir = "jobs"
+
+// JobState describes the processing stage of a registration job.
+type JobState string
+
+const (
+	jobQueued    JobState = "queued"
+	jobCloning   JobState = "cloning"
+	jobZipping   JobState = "zipping"
+	jobRendering JobState = "rendering"
+	jobDone      JobState = "done"
+	jobFailed    JobState = "failed"
+	jobReleased  JobState = "released"
+	jobRejected  JobState = "rejected"
+	// Released dataset with data under embargo
+	jobEmbargoed JobState = "embargoed"
+	// Released dataset that was withdrawn later
+	jobWithdrawn JobState = "withdrawn"
+)
+
+// finished returns true if a job in the given state requires no further
+// processing by the workers. Finished jobs in the done state are waiting for
+// the review of a curator; jobs in the embargoed state are waiting for the
+// end of the embargo.
+func (s JobState) finished() bool {
+	return s == jobDone || s == jobFailed || s == jobEmbargoed || s.closed()
+}
+
+// closed returns true if a job in the given state requires no further action
+// by the curators.
+func (s JobState) closed() bool {
+	return s == jobReleased || s == jobRejected || s == jobWithdrawn
+}
+
+// JobStage records the start and end time of a single processing step of a
+// registration job.  Finished is nil while the stage is running.
+type JobStage struct {
+	Name     string
+	Started  time.Time
+	Finished *time.Time `json:",omitempty"`
+}
+
+// JobRecord is the persistent representation of a RegistrationJob. It holds
+// everything required to restart the job after a service restart and the
+// progress information shown on the job status page.
+type JobRecord struct {
+	// ID of the job; the reserved DOI
+	ID       string
+	State    JobState
+	Metadata *libgin.RepositoryMetadata
+	// Requested revision of the repository
+	Revision string
+	Created  time.Time
+	Updated  time.Time
+	// Processing steps in the order they were started
+	Stages []JobStage
+	// Size of the created archive in bytes
+	ArchiveSize int64
+	// Errors and warnings collected during the dataset preparation
+	Errors   []string
+	Warnings []string
+	// Job run by the register command; it is never resumed by the service
+	Interactive bool `json:",omitempty"`
+	// Commit hash of the registered revision
+	Commit string `json:",omitempty"`
+	// Message of the curator who released or rejected the job
+	ReviewMessage string `json:",omitempty"`
+	// Set by a curator to allow a new registration of the same repository
+	// revision, which is otherwise rejected as a duplicate of this job
+	AllowNewVersion bool `json:",omitempty"`
+}
+
+// JobStore keeps registration job records as JSON files in a directory so
+// that queued and running jobs survive a restart of the service.
+type JobStore struct {
+	dir   string
+	mutex sync.Mutex
+	// metrics records the stage durations, archive sizes, and final states
+	// of the jobs; optional
+	metrics *Metrics
+}
+
+// newJobStore returns a JobStore which keeps its records in the given
+// directory. The directory is created if it does not exist.
+func newJobStore(dir string) (*JobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create job store directory %q: %s", dir, err.Error())
+	}
+	return &JobStore{dir: dir}, nil
+}
+
+// recordPath returns the path of the file for the job with the given ID.
+func (s *JobStore) recordPath(id string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(id, "/", "_")+".json")
+}
+
+// add creates a new queued record for a registration job.
+func (s *JobStore) add(job *RegistrationJob) error {
+	if s == nil {
+		return nil
+	}
+	now := time.Now()
+	rec := &JobRecord{
+		ID:          job.Metadata.Identifier.ID,
+		State:       jobQueued,
+		Metadata:    job.Metadata,
+		Revision:    job.Revision,
+		Created:     now,
+		Updated:     now,
+		Interactive: job.Interactive,
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(rec)
+}
+
+// get returns the record of the job with the given ID.
+func (s *JobStore) get(id string) (*JobRecord, error) {
+	if s == nil {
+		return nil, fmt.Errorf("no job store configured")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.read(s.recordPath(id))
+}
+
+// update applies the modify function to the record of the job with the given
+// ID and saves the result. Failures are logged but not returned, since the
+// registration itself should not fail because of the job bookkeeping.
+func (s *JobStore) update(id string, modify func(rec *JobRecord)) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rec, err := s.read(s.recordPath(id))
+	if err != nil {
+		log.Printf("Failed to read job record %q: %s", id, err.Error())
+		return
+	}
+	modify(rec)
+	rec.Updated = time.Now()
+	if err := s.write(rec); err != nil {
+		log.Printf("Failed to update job record %q: %s", id, err.Error())
+	}
+}
+
+// setState updates the state of the job with the given ID.
+func (s *JobStore) setState(id string, state JobState) {
+	s.update(id, func(rec *JobRecord) {
+		rec.State = state
+	})
+}
+
+// startStage marks the currently running stage of a job as finished and
+// starts a new one with the given name.
+func (s *JobStore) startStage(id string, name string) {
+	s.update(id, func(rec *JobRecord) {
+		now := time.Now()
+		s.endStage(rec, now)
+		rec.Stages = append(rec.Stages, JobStage{Name: name, Started: now})
+	})
+}
+
+// setCommit records the commit hash of the registered revision for a job.
+func (s *JobStore) setCommit(id string, commit string) {
+	s.update(id, func(rec *JobRecord) {
+		rec.Commit = commit
+	})
+}
+
+// review sets the final state of a job after the review of a curator and
+// stores the message of the curator.
+func (s *JobStore) review(id string, state JobState, message string) {
+	s.update(id, func(rec *JobRecord) {
+		rec.State = state
+		rec.ReviewMessage = message
+	})
+}
+
+// setArchiveSize records the size of the created archive for a job.
+func (s *JobStore) setArchiveSize(id string, size int64) {
+	s.update(id, func(rec *JobRecord) {
+		rec.ArchiveSize = size
+		s.metrics.observeArchiveSize(size)
+	})
+}
+
+// finish marks the running stage of a job as finished, sets the final state
+// and stores the errors and warnings collected during processing.
+func (s *JobStore) finish(id string, state JobState, errors, warnings []string) {
+	s.update(id, func(rec *JobRecord) {
+		s.endStage(rec, time.Now())
+		rec.State = state
+		rec.Errors = errors
+		rec.Warnings = warnings
+		s.metrics.countRegistration(state)
+	})
+}
+
+// interrupt records that the processing of a job was cut short by a shutdown
+// of the service and queues it again, so that it is resumed on the next start.
+func (s *JobStore) interrupt(id string) {
+	s.update(id, func(rec *JobRecord) {
+		now := time.Now()
+		rec.finishStage(now)
+		rec.Stages = append(rec.Stages, JobStage{Name: "interrupted by service shutdown", Started: now, Finished: &now})
+		rec.State = jobQueued
+	})
+}
+
+// endStage finishes the running stage of a job record and records its
+// duration in the metrics.
+func (s *JobStore) endStage(rec *JobRecord, now time.Time) {
+	if stage := rec.finishStage(now); stage != nil {
+		s.metrics.observeStage(stage.Name, now.Sub(stage.Started))
+	}
+}
+
+// finishStage sets the end time of the last stage if it is still running and
+// returns it. It returns nil if no stage was running.
+func (rec *JobRecord) finishStage(now time.Time) *JobStage {
+	if n := len(rec.Stages); n > 0 && rec.Stages[n-1].Finished == nil {
+		rec.Stages[n-1].Finished = &now
+		return &rec.Stages[n-1]
+	}
+	return nil
+}
+
+// list returns all job records in the store sorted by creation time.
+func (s *JobStore) list() ([]*JobRecord, error) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*JobRecord, 0, len(files))
+	for _, fi := range files {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		rec, err := s.read(filepath.Join(s.dir, fi.Name()))
+		if err != nil {
+			log.Printf("Skipping unreadable job record %q: %s", fi.Name(), err.Error())
+			continue
+		}
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+	return records, nil
+}
+
+// findDuplicate returns the most recent job for the same repository and
+// commit that is still being processed, or that finished after the given time
+// and was not rejected or withdrawn. If the commit of the submission is known,
+// a job matches if it registered the same commit, or if it requested the same
+// revision and has not been cloned yet. Otherwise a job matches if it was
+// requested with the same revision or if its commit starts with the revision.
+// Failed jobs and jobs for which a curator allowed a new version are ignored.
+// It returns nil if there is no such job.
+func (s *JobStore) findDuplicate(repository, revision, commit string, since time.Time) (*JobRecord, error) {
+	records, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var duplicate *JobRecord
+	for _, rec := range records {
+		if rec.Metadata == nil || rec.AllowNewVersion || rec.State == jobFailed || rec.State == jobRejected || rec.State == jobWithdrawn {
+			continue
+		}
+		if !strings.EqualFold(rec.Metadata.SourceRepository, repository) {
+			continue
+		}
+		if commit != "" {
+			if rec.Commit != commit && (rec.Commit != "" || rec.Revision != revision) {
+				continue
+			}
+		} else if rec.Revision != revision && (revision == "" || !strings.HasPrefix(rec.Commit, revision)) {
+			continue
+		}
+		if rec.State.finished() && rec.Created.Before(since) {
+			continue
+		}
+		duplicate = rec
+	}
+	return duplicate, nil
+}
+
+// countOpen returns the number of registrations requested by the given GIN
+// user that are still being processed or are waiting for the review of a
+// curator.
+func (s *JobStore) countOpen(username string) (int, error) {
+	records, err := s.list()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, rec := range records {
+		if rec.Metadata == nil || rec.Metadata.RequestingUser == nil {
+			continue
+		}
+		if !strings.EqualFold(rec.Metadata.RequestingUser.Username, username) {
+			continue
+		}
+		if !rec.State.finished() || rec.State == jobDone {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// allowNewVersion allows a new registration of the repository revision of the
+// job with the given ID.
+func (s *JobStore) allowNewVersion(id string) {
+	s.update(id, func(rec *JobRecord) {
+		rec.AllowNewVersion = true
+	})
+}
+
+// unfinished returns all job records that have not reached a final state and
+// can be resumed by the service. Records of jobs run by the register command
+// and records without metadata are skipped.
+func (s *JobStore) unfinished() ([]*JobRecord, error) {
+	records, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]*JobRecord, 0, len(records))
+	for _, rec := range records {
+		if rec.State.finished() || rec.Interactive {
+			continue
+		}
+		if rec.Metadata == nil {
+			log.Printf("Skipping job record %q without metadata", rec.ID)
+			continue
+		}
+		pending = append(pending, rec)
+	}
+	return pending, nil
+}
+
+// prune removes the records of failed, released, and rejected jobs that have
+// not been updated for longer than the given retention period. Records of
+// unfinished jobs and of jobs waiting for review are always kept. It returns
+// the number of removed records.
+func (s *JobStore) prune(retention time.Duration) (int, error) {
+	records, err := s.list()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-retention)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	nremoved := 0
+	for _, rec := range records {
+		if !(rec.State.closed() || rec.State == jobFailed) || rec.Updated.After(cutoff) {
+			continue
+		}
+		if err := os.Remove(s.recordPath(rec.ID)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove job record %q: %s", rec.ID, err.Error())
+			continue
+		}
+		nremoved++
+	}
+	return nremoved, nil
+}
+
+// pruneJobs removes expired job records on startup and then once a day for
+// as long as the service is running.
+func pruneJobs(live *liveConfig) {
+	for {
+		conf := live.get()
+		if n, err := conf.Jobs.prune(conf.JobRetention); err != nil {
+			log.Printf("Failed to prune job records: %s", err.Error())
+		} else if n > 0 {
+			log.Printf("Removed %d expired job records", n)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// read loads a single job record from a file. The caller must hold the lock.
+func (s *JobStore) read(fname string) (*JobRecord, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	rec := new(JobRecord)
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// write saves a job record. The record is written to a temporary file first
+// and then moved in place to avoid leaving broken records behind if the
+// service is stopped mid-write. The caller must hold the lock.
+func (s *JobStore) write(rec *JobRecord) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	fname := s.recordPath(rec.ID)
+	tmpfname := fname + ".tmp"
+	if err := ioutil.WriteFile(tmpfname, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpfname, fname)
+}
+
+// requeueJobs adds all unfinished jobs found in the job store to the job
+// queue. It is called on service startup to resume registrations that were
+// interrupted by a restart.
+func requeueJobs(jobQueue chan *RegistrationJob, conf *Configuration) {
+	records, err := conf.Jobs.unfinished()
+	if err != nil {
+		log.Printf("Failed to read unfinished jobs: %s", err.Error())
+		return
+	}
+	for _, rec := range records {
+		log.Printf("Resuming job %s for %q (state: %s)", rec.ID, rec.Metadata.SourceRepository, rec.State)
+		discardPartialOutput(conf, rec.ID)
+		conf.Jobs.setState(rec.ID, jobQueued)
+		jobQueue <- &RegistrationJob{Metadata: rec.Metadata, Config: conf, Revision: rec.Revision}
+	}
+}
+
+// discardPartialOutput removes the archives of an unfinished job from the
+// storage backend. They may have been left incomplete when the job was
+// interrupted and are created again when the job is resumed.
+func discardPartialOutput(conf *Configuration, id string) {
+	storage := conf.Storage.Backend
+	if storage == nil {
+		return
+	}
+	archives, err := datasetArchives(storage, id)
+	if err != nil {
+		log.Printf("Failed to list partial output of job %s: %s", id, err.Error())
+		return
+	}
+	for _, archive := range archives {
+		log.Printf("Removing partial output %s", archive)
+		if err := storage.Remove(archive); err != nil {
+			log.Printf("Failed to remove partial output %s: %s", archive, err.Error())
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+// newTestJob returns a RegistrationJob with the minimal metadata required
+// by the job store.
+func newTestJob(doi, repo string) *RegistrationJob {
+	job := &RegistrationJob{
+		Metadata: new(libgin.RepositoryMetadata),
+		Config:   new(Configuration),
+	}
+	job.Metadata.DataCite = new(libgin.DataCite)
+	job.Metadata.Identifier.ID = doi
+	job.Metadata.SourceRepository = repo
+	return job
+}
+
+func TestJobStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_jobstore")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+
+	jobs := []*RegistrationJob{
+		newTestJob("10.12751/g-node.aaaaaa", "owner/one"),
+		newTestJob("10.12751/g-node.bbbbbb", "owner/two"),
+		newTestJob("10.12751/g-node.cccccc", "owner/three"),
+	}
+	for _, job := range jobs {
+		if err := store.add(job); err != nil {
+			t.Fatalf("Error adding job: %v", err)
+		}
+	}
+
+	rec, err := store.get("10.12751/g-node.bbbbbb")
+	if err != nil {
+		t.Fatalf("Error reading job: %v", err)
+	}
+	if rec.State != jobQueued {
+		t.Fatalf("Unexpected state of new job: %s", rec.State)
+	}
+	if rec.Metadata.SourceRepository != "owner/two" {
+		t.Fatalf("Unexpected repository of stored job: %s", rec.Metadata.SourceRepository)
+	}
+
+	store.setState("10.12751/g-node.aaaaaa", jobDone)
+	store.setState("10.12751/g-node.bbbbbb", jobFailed)
+	store.setState("10.12751/g-node.cccccc", jobZipping)
+
+	records, err := store.list()
+	if err != nil {
+		t.Fatalf("Error listing jobs: %v", err)
+	}
+	if len(records) != len(jobs) {
+		t.Fatalf("Unexpected number of jobs: %d (expected %d)", len(records), len(jobs))
+	}
+
+	pending, err := store.unfinished()
+	if err != nil {
+		t.Fatalf("Error listing unfinished jobs: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != "10.12751/g-node.cccccc" {
+		t.Fatalf("Unexpected unfinished jobs: %+v", pending)
+	}
+
+	// A new store on the same directory must see the same records
+	store, err = newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error reopening job store: %v", err)
+	}
+	rec, err = store.get("10.12751/g-node.cccccc")
+	if err != nil {
+		t.Fatalf("Error reading job from reopened store: %v", err)
+	}
+	if rec.State != jobZipping {
+		t.Fatalf("Unexpected state of reopened job: %s", rec.State)
+	}
+
+	// Operations on a nil store must not fail
+	var nilstore *JobStore
+	if err := nilstore.add(jobs[0]); err != nil {
+		t.Fatalf("Error adding job to nil store: %v", err)
+	}
+	nilstore.setState("10.12751/g-node.aaaaaa", jobDone)
+}
+
+func TestJobStoreSkipsBrokenRecords(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_jobstore")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+
+	// Record without metadata, e.g., after a manual edit
+	broken := []byte(`{"ID": "10.12751/g-node.broken", "State": "cloning"}`)
+	if err := ioutil.WriteFile(store.recordPath("10.12751/g-node.broken"), broken, 0644); err != nil {
+		t.Fatalf("Error writing broken record: %v", err)
+	}
+	if err := store.add(newTestJob("10.12751/g-node.aaaaaa", "owner/one")); err != nil {
+		t.Fatalf("Error adding job: %v", err)
+	}
+
+	pending, err := store.unfinished()
+	if err != nil {
+		t.Fatalf("Error listing unfinished jobs: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != "10.12751/g-node.aaaaaa" {
+		t.Fatalf("Unexpected unfinished jobs: %+v", pending)
+	}
+
+	// Operations on a nil store must not panic
+	var nilstore *JobStore
+	if _, err := nilstore.get("10.12751/g-node.aaaaaa"); err == nil {
+		t.Fatal("Reading from nil store did not fail")
+	}
+	if records, err := nilstore.unfinished(); err != nil || len(records) != 0 {
+		t.Fatalf("Unexpected result from nil store: %v %v", records, err)
+	}
+}
+
+func TestJobStorePrune(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_jobstore")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	for _, doi := range []string{"10.12751/g-node.aaaaaa", "10.12751/g-node.bbbbbb", "10.12751/g-node.cccccc"} {
+		if err := store.add(newTestJob(doi, "owner/repo")); err != nil {
+			t.Fatalf("Error adding job: %v", err)
+		}
+	}
+	store.setState("10.12751/g-node.aaaaaa", jobReleased)
+	store.setState("10.12751/g-node.bbbbbb", jobFailed)
+	store.setState("10.12751/g-node.cccccc", jobDone)
+
+	// Nothing has expired yet
+	if n, err := store.prune(time.Hour); err != nil || n != 0 {
+		t.Fatalf("Unexpected prune result: %d %v", n, err)
+	}
+	// All jobs have expired; the one waiting for review must be kept
+	if n, err := store.prune(-time.Hour); err != nil || n != 2 {
+		t.Fatalf("Unexpected prune result: %d %v", n, err)
+	}
+	records, err := store.list()
+	if err != nil {
+		t.Fatalf("Error listing jobs: %v", err)
+	}
+	if len(records) != 1 || records[0].ID != "10.12751/g-node.cccccc" {
+		t.Fatalf("Unexpected jobs after pruning: %+v", records)
+	}
+}
+
+func TestJobStoreFindDuplicate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_jobstore")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	add := func(doi, repo, revision string, state JobState) {
+		job := newTestJob(doi, repo)
+		job.Revision = revision
+		if err := store.add(job); err != nil {
+			t.Fatalf("Error adding job: %v", err)
+		}
+		store.setState(doi, state)
+	}
+	add("10.12751/g-node.aaaaaa", "owner/running", "", jobCloning)
+	add("10.12751/g-node.bbbbbb", "owner/failed", "", jobFailed)
+	add("10.12751/g-node.cccccc", "owner/done", "v1.0", jobDone)
+	store.setCommit("10.12751/g-node.cccccc", "0123456789abcdef0123456789abcdef01234567")
+	add("10.12751/g-node.dddddd", "owner/rejected", "", jobRejected)
+
+	now := time.Now()
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	other := "fedcba9876543210fedcba9876543210fedcba98"
+	for _, test := range []struct {
+		repo, revision, commit string
+		since                  time.Time
+		duplicate              string
+	}{
+		// Commit of the submission unknown
+		{"owner/running", "", "", now, "10.12751/g-node.aaaaaa"},
+		{"Owner/Running", "", "", now.Add(time.Hour), "10.12751/g-node.aaaaaa"},
+		{"owner/running", "v2.0", "", now, ""},
+		{"owner/failed", "", "", now.Add(-time.Hour), ""},
+		{"owner/done", "v1.0", "", now.Add(-time.Hour), "10.12751/g-node.cccccc"},
+		{"owner/done", "01234567", "", now.Add(-time.Hour), "10.12751/g-node.cccccc"},
+		{"owner/done", "", "", now.Add(-time.Hour), ""},
+		{"owner/done", "v1.0", "", now.Add(time.Hour), ""},
+		{"owner/rejected", "", "", now.Add(-time.Hour), ""},
+		{"owner/other", "", "", now.Add(-time.Hour), ""},
+		// Commit of the submission known
+		{"owner/running", "", other, now, "10.12751/g-node.aaaaaa"},
+		{"owner/done", "", commit, now.Add(-time.Hour), "10.12751/g-node.cccccc"},
+		{"owner/done", "v1.0", commit, now.Add(-time.Hour), "10.12751/g-node.cccccc"},
+		{"owner/done", "v1.0", other, now.Add(-time.Hour), ""},
+	} {
+		rec, err := store.findDuplicate(test.repo, test.revision, test.commit, test.since)
+		if err != nil {
+			t.Fatalf("Error looking for duplicates: %v", err)
+		}
+		id := ""
+		if rec != nil {
+			id = rec.ID
+		}
+		if id != test.duplicate {
+			t.Errorf("Unexpected duplicate of %s@%s (%s): %q (expected %q)", test.repo, test.revision, test.commit, id, test.duplicate)
+		}
+	}
+
+	// Curators can allow a new version
+	store.allowNewVersion("10.12751/g-node.aaaaaa")
+	if rec, err := store.findDuplicate("owner/running", "", "", now); err != nil || rec != nil {
+		t.Errorf("Unexpected duplicate after allowing a new version: %+v %v", rec, err)
+	}
+}
+
+func TestRequeueInterruptedJob(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_jobstore")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	conf := &Configuration{Jobs: store}
+	conf.Storage.Backend = NewLocalStorage(filepath.Join(tmpDir, "target"))
+
+	doi := "10.12751/g-node.aaaaaa"
+	if err := store.add(newTestJob(doi, "owner/one")); err != nil {
+		t.Fatalf("Error adding job: %v", err)
+	}
+	store.startStage(doi, "create archive")
+	store.setState(doi, jobZipping)
+
+	// Incomplete archive left behind by the interrupted job
+	dir := filepath.Join(tmpDir, "target", "10.12751", "g-node.aaaaaa")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatalf("Error creating dataset directory: %v", err)
+	}
+	partial := filepath.Join(dir, "10.12751_g-node.aaaaaa.zip")
+	if err := ioutil.WriteFile(partial, []byte("PK"), 0666); err != nil {
+		t.Fatalf("Error writing partial archive: %v", err)
+	}
+
+	store.interrupt(doi)
+	rec, err := store.get(doi)
+	if err != nil {
+		t.Fatalf("Error reading job: %v", err)
+	}
+	if n := len(rec.Stages); rec.State != jobQueued || n != 2 || rec.Stages[0].Finished == nil || rec.Stages[1].Name != "interrupted by service shutdown" {
+		t.Fatalf("Unexpected interrupted job: %+v", rec)
+	}
+
+	jobQueue := make(chan *RegistrationJob, 1)
+	requeueJobs(jobQueue, conf)
+	select {
+	case job := <-jobQueue:
+		if job.Metadata.Identifier.ID != doi {
+			t.Fatalf("Unexpected resumed job: %s", job.Metadata.Identifier.ID)
+		}
+	default:
+		t.Fatal("Interrupted job was not resumed")
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Fatalf("Partial archive was not removed: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+// schemaOrgContext is the JSON-LD context of the structured data embedded in
+// the landing pages.
+const schemaOrgContext = "https://schema.org"
+
+// ldThing holds the properties common to the schema.org types used in the
+// dataset description. Empty properties are omitted.
+type ldThing struct {
+	Type       string `json:"@type"`
+	ID         string `json:"@id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+// ldPerson is a schema.org Person; used for the dataset creators.
+type ldPerson struct {
+	ldThing
+	GivenName   string   `json:"givenName,omitempty"`
+	FamilyName  string   `json:"familyName,omitempty"`
+	Affiliation *ldThing `json:"affiliation,omitempty"`
+}
+
+// ldDataDownload is a schema.org DataDownload; used for the dataset archive.
+type ldDataDownload struct {
+	Type           string `json:"@type"`
+	ContentURL     string `json:"contentUrl"`
+	EncodingFormat string `json:"encodingFormat,omitempty"`
+	ContentSize    string `json:"contentSize,omitempty"`
+	SHA256         string `json:"sha256,omitempty"`
+}
+
+// ldDataset is a schema.org Dataset describing a registered dataset.
+type ldDataset struct {
+	Context             string           `json:"@context"`
+	Type                string           `json:"@type"`
+	ID                  string           `json:"@id,omitempty"`
+	URL                 string           `json:"url,omitempty"`
+	Identifier          string           `json:"identifier,omitempty"`
+	Name                string           `json:"name"`
+	Description         string           `json:"description,omitempty"`
+	Creator             []ldPerson       `json:"creator,omitempty"`
+	License             string           `json:"license,omitempty"`
+	Keywords            []string         `json:"keywords,omitempty"`
+	Funder              []ldThing        `json:"funder,omitempty"`
+	DatePublished       string           `json:"datePublished,omitempty"`
+	Publisher           *ldThing         `json:"publisher,omitempty"`
+	Version             string           `json:"version,omitempty"`
+	IsBasedOn           []string         `json:"isBasedOn,omitempty"`
+	IsAccessibleForFree bool             `json:"isAccessibleForFree"`
+	Distribution        []ldDataDownload `json:"distribution,omitempty"`
+}
+
+// doiURL returns the resolver URL of a DOI.
+func doiURL(doi string) string {
+	return fmt.Sprintf("https://doi.org/%s", doi)
+}
+
+// ldCreator converts a DataCite creator to a schema.org Person. ORCIDs are
+// used as the identifier of the person.
+func ldCreator(creator libgin.Creator) ldPerson {
+	person := ldPerson{ldThing: ldThing{Type: "Person", Name: creator.Name}}
+	// Author names are LastName, FirstName
+	if namesplit := strings.SplitN(creator.Name, ",", 2); len(namesplit) == 2 {
+		person.FamilyName = strings.TrimSpace(namesplit[0])
+		person.GivenName = strings.TrimSpace(namesplit[1])
+		person.Name = fmt.Sprintf("%s %s", person.GivenName, person.FamilyName)
+	}
+	if creator.Identifier != nil && creator.Identifier.ID != "" {
+		id := creator.Identifier.ID
+		if strings.EqualFold(creator.Identifier.Scheme, "ORCID") {
+			// Canonical form of ORCID iDs
+			id = "https://orcid.org/" + id
+		} else if creator.Identifier.SchemeURI != "" {
+			id = creator.Identifier.SchemeURI + id
+		}
+		person.ID = id
+		person.Identifier = id
+	}
+	if creator.Affiliation != "" {
+		person.Affiliation = &ldThing{Type: "Organization", Name: creator.Affiliation}
+	}
+	return person
+}
+
+// datasetLD builds the schema.org Dataset description of a registered dataset.
+// The archive checksum is added to the archive download if it is not empty.
+func datasetLD(md *libgin.RepositoryMetadata, checksum string) *ldDataset {
+	dataset := &ldDataset{
+		Context:             schemaOrgContext,
+		Type:                "Dataset",
+		Version:             md.Version,
+		IsAccessibleForFree: true,
+	}
+	if md.Identifier.ID != "" {
+		dataset.ID = doiURL(md.Identifier.ID)
+		dataset.URL = dataset.ID
+		dataset.Identifier = dataset.ID
+	}
+	if len(md.Titles) > 0 {
+		dataset.Name = md.Titles[0]
+	}
+	for _, desc := range md.Descriptions {
+		if desc.Type == "Abstract" || dataset.Description == "" {
+			dataset.Description = desc.Content
+		}
+	}
+	for _, creator := range md.Creators {
+		dataset.Creator = append(dataset.Creator, ldCreator(creator))
+	}
+	if len(md.RightsList) > 0 {
+		dataset.License = md.RightsList[0].URL
+	}
+	if md.Subjects != nil {
+		dataset.Keywords = *md.Subjects
+	}
+	if md.FundingReferences != nil {
+		for _, funding := range *md.FundingReferences {
+			funder := ldThing{Type: "Organization", Name: funding.Funder}
+			if funding.Identifier != nil {
+				funder.Identifier = funding.Identifier.ID
+			}
+			dataset.Funder = append(dataset.Funder, funder)
+		}
+	}
+	for _, mddate := range md.Dates {
+		if mddate.Type == "Issued" {
+			dataset.DatePublished = mddate.Value
+			break
+		}
+	}
+	if md.Publisher != "" {
+		dataset.Publisher = &ldThing{Type: "Organization", Name: md.Publisher}
+	}
+	for _, relid := range md.RelatedIdentifiers {
+		if relid.RelationType == "IsNewVersionOf" && relid.Type == "DOI" {
+			// This dataset is based on the previous version
+			dataset.IsBasedOn = append(dataset.IsBasedOn, doiURL(relid.Identifier))
+		}
+	}
+	if archive := archiveURL(md); archive != "" && !isEmbargoed(md, time.Now()) {
+		download := ldDataDownload{
+			Type:           "DataDownload",
+			ContentURL:     archive,
+			EncodingFormat: "application/zip",
+			SHA256:         checksum,
+		}
+		if ArchiveType(md) == "TAR" {
+			download.EncodingFormat = "application/x-tar"
+		}
+		if md.Sizes != nil && len(*md.Sizes) > 0 {
+			download.ContentSize = (*md.Sizes)[0]
+		}
+		dataset.Distribution = []ldDataDownload{download}
+	}
+	return dataset
+}
+
+// DatasetJSONLD returns the schema.org Dataset description of a registered
+// dataset as JSON-LD for embedding in a script element of the landing page.
+// This is a utility function for the landing page HTML template.
+func DatasetJSONLD(md *libgin.RepositoryMetadata, checksum string) template.JS {
+	// Marshal escapes <, >, and & so the data cannot close the script element
+	data, err := json.MarshalIndent(datasetLD(md, checksum), "", "  ")
+	if err != nil {
+		log.Printf("Failed to create JSON-LD for %s: %s", md.Identifier.ID, err.Error())
+		return ""
+	}
+	return template.JS(data)
+}
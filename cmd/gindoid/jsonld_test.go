@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestDatasetJSONLD(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_jsonld")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	yamldata := &libgin.RepositoryYAML{
+		Authors: []libgin.Author{
+			{FirstName: "Alice", LastName: "Doe", Affiliation: "G-Node", ID: "ORCID:0000-0002-1825-0097"},
+			{FirstName: "Bob", LastName: "Roe"},
+		},
+		Title:        "A dataset </script><script>alert(1)</script>",
+		Description:  "Recordings & analysis",
+		Keywords:     []string{"Neuroscience", "Electrophysiology"},
+		License:      &libgin.License{Name: "CC-BY", URL: "https://creativecommons.org/licenses/by/4.0/"},
+		Funding:      []string{"DFG; 12345"},
+		ResourceType: "Dataset",
+	}
+	metadata := &libgin.RepositoryMetadata{
+		YAMLData: yamldata,
+		DataCite: libgin.NewDataCiteFromYAML(yamldata),
+	}
+	doi := "10.12751/g-node.aaaaaa"
+	metadata.Identifier.ID = doi
+	metadata.Sizes = &[]string{"1.2 GiB"}
+	metadata.AddURLs("https://gin.g-node.org/owner/repo", "https://gin.g-node.org/doi/repo", "https://doi.example.org/10.12751/g-node.aaaaaa/10.12751_g-node.aaaaaa.zip")
+	metadata.RelatedIdentifiers = append(metadata.RelatedIdentifiers, libgin.RelatedIdentifier{Identifier: "10.12751/g-node.bbbbbb", Type: "DOI", RelationType: "IsNewVersionOf"})
+
+	if err := createLandingPage(NewLocalStorage(tmpDir), metadata, "index.html", "", "abcdef0123"); err != nil {
+		t.Fatalf("Error creating landing page: %v", err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(tmpDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Error reading landing page: %v", err)
+	}
+	if strings.Contains(string(page), "</script><script>alert(1)") {
+		t.Fatal("Title was not escaped in the landing page")
+	}
+
+	start := strings.Index(string(page), `<script type="application/ld+json">`)
+	if start < 0 {
+		t.Fatal("JSON-LD missing from landing page")
+	}
+	jsonld := string(page[start+len(`<script type="application/ld+json">`):])
+	jsonld = jsonld[:strings.Index(jsonld, "</script>")]
+
+	var dataset map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonld), &dataset); err != nil {
+		t.Fatalf("Invalid JSON-LD in landing page: %v\n%s", err, jsonld)
+	}
+	expected := map[string]interface{}{
+		"@context":      "https://schema.org",
+		"@type":         "Dataset",
+		"@id":           "https://doi.org/" + doi,
+		"name":          yamldata.Title,
+		"description":   yamldata.Description,
+		"license":       yamldata.License.URL,
+		"datePublished": metadata.Dates[0].Value,
+	}
+	for key, value := range expected {
+		if dataset[key] != value {
+			t.Errorf("Unexpected value for %q: %v (expected %v)", key, dataset[key], value)
+		}
+	}
+
+	ld := datasetLD(metadata, "abcdef0123")
+	if len(ld.Creator) != 2 {
+		t.Fatalf("Unexpected number of creators: %d", len(ld.Creator))
+	}
+	if alice := ld.Creator[0]; alice.Name != "Alice Doe" || alice.FamilyName != "Doe" || alice.ID != "https://orcid.org/0000-0002-1825-0097" || alice.Affiliation == nil || alice.Affiliation.Name != "G-Node" {
+		t.Errorf("Unexpected creator: %+v", alice)
+	}
+	if bob := ld.Creator[1]; bob.ID != "" || bob.Affiliation != nil {
+		t.Errorf("Unexpected creator: %+v", bob)
+	}
+	if strings.Join(ld.Keywords, ",") != "Neuroscience,Electrophysiology" {
+		t.Errorf("Unexpected keywords: %v", ld.Keywords)
+	}
+	if len(ld.Funder) != 1 || ld.Funder[0].Name != "DFG" {
+		t.Errorf("Unexpected funders: %+v", ld.Funder)
+	}
+	if len(ld.IsBasedOn) != 1 || ld.IsBasedOn[0] != "https://doi.org/10.12751/g-node.bbbbbb" {
+		t.Errorf("Unexpected previous versions: %v", ld.IsBasedOn)
+	}
+	if len(ld.Distribution) != 1 {
+		t.Fatalf("Unexpected distribution: %+v", ld.Distribution)
+	}
+	download := ld.Distribution[0]
+	if download.ContentURL != "https://doi.example.org/10.12751/g-node.aaaaaa/10.12751_g-node.aaaaaa.zip" || download.ContentSize != "1.2 GiB" || download.SHA256 != "abcdef0123" || download.EncodingFormat != "application/zip" {
+		t.Errorf("Unexpected archive download: %+v", download)
+	}
+
+	// Without an archive link there is no distribution
+	metadata.RelatedIdentifiers = nil
+	if ld := datasetLD(metadata, ""); ld.Distribution != nil {
+		t.Errorf("Unexpected distribution without archive: %+v", ld.Distribution)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/G-Node/libgin/libgin"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// defaultKeywordsJSON are curated keywords with common alternative spellings
+// and synonyms that are merged into them on keyword pages and landing page
+// links.
+const defaultKeywordsJSON = `[
+{
+	"Name":  "Electrophysiology",
+	"Alias": [
+	"ephys",
+	"electro-physiology",
+	"electrophysiological recordings"
+	]
+},
+{
+	"Name":  "Electroencephalography",
+	"Alias": [
+	"EEG"
+	]
+},
+{
+	"Name":  "Functional magnetic resonance imaging",
+	"Alias": [
+	"fMRI",
+	"functional MRI"
+	]
+},
+{
+	"Name":  "Calcium imaging",
+	"Alias": [
+	"Ca imaging",
+	"Ca2+ imaging"
+	]
+},
+{
+	"Name":  "Neuroscience",
+	"Alias": [
+	"Neurosciences",
+	"Neuro science"
+	]
+}
+]`
+
+// DOIKeyword is a curated keyword with the alternative spellings and synonyms
+// that are merged into it.
+type DOIKeyword struct {
+	Name  string
+	Alias []string
+}
+
+// keywordNormaliser maps keywords to their canonical name using the curated
+// keyword list.
+type keywordNormaliser struct {
+	// names maps folded keywords and aliases to the canonical name
+	names map[string]string
+}
+
+// newKeywordNormaliser creates a keywordNormaliser from a curated keyword
+// list.
+func newKeywordNormaliser(keywords []DOIKeyword) *keywordNormaliser {
+	kn := &keywordNormaliser{names: make(map[string]string)}
+	for _, kw := range keywords {
+		name := cleanKeyword(kw.Name)
+		kn.names[foldKeyword(name)] = name
+		for _, alias := range kw.Alias {
+			kn.names[foldKeyword(alias)] = name
+		}
+	}
+	return kn
+}
+
+// Name returns the canonical name of a keyword: the curated name if the
+// keyword or one of its aliases is in the curated list, otherwise the keyword
+// with normalised unicode and whitespace.
+func (kn *keywordNormaliser) Name(kw string) string {
+	kw = cleanKeyword(kw)
+	if name, ok := kn.names[foldKeyword(kw)]; ok {
+		return name
+	}
+	return kw
+}
+
+// Path returns the URL path of the keyword page of a keyword.
+func (kn *keywordNormaliser) Path(kw string) string {
+	return slugKeyword(kn.Name(kw))
+}
+
+// cleanKeyword applies unicode compatibility normalisation to a keyword and
+// collapses all whitespace to single spaces.
+func cleanKeyword(kw string) string {
+	return strings.Join(strings.Fields(norm.NFKC.String(kw)), " ")
+}
+
+// foldKeyword returns the form of a keyword that is used to compare keywords:
+// cleaned, lowercase and without diacritics.
+func foldKeyword(kw string) string {
+	stripmarks := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(stripmarks, cleanKeyword(kw))
+	if err != nil {
+		folded = cleanKeyword(kw)
+	}
+	return strings.ToLower(folded)
+}
+
+// slugKeyword returns a keyword sanitised for use in a URL path: the folded
+// keyword with every sequence of characters other than letters and digits
+// replaced by a single underscore.
+func slugKeyword(kw string) string {
+	var slug strings.Builder
+	sep := false
+	for _, r := range foldKeyword(kw) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if sep && slug.Len() > 0 {
+				slug.WriteRune('_')
+			}
+			slug.WriteRune(r)
+			sep = false
+			continue
+		}
+		sep = true
+	}
+	return slug.String()
+}
+
+var (
+	curatedKeywordsMutex sync.Mutex
+	curatedKeywords      *keywordNormaliser
+)
+
+// defaultKeywordNormaliser returns the keyword normaliser for the curated
+// keyword list, which is read on first use and when the service reloads its
+// configuration.
+func defaultKeywordNormaliser() *keywordNormaliser {
+	curatedKeywordsMutex.Lock()
+	defer curatedKeywordsMutex.Unlock()
+	if curatedKeywords == nil {
+		curatedKeywords = newKeywordNormaliser(ReadCuratedKeywords())
+	}
+	return curatedKeywords
+}
+
+// reloadCuratedKeywords reads the curated keyword list again and returns the
+// number of keywords.
+func reloadCuratedKeywords() int {
+	keywords := ReadCuratedKeywords()
+	normaliser := newKeywordNormaliser(keywords)
+	curatedKeywordsMutex.Lock()
+	curatedKeywords = normaliser
+	curatedKeywordsMutex.Unlock()
+	return len(keywords)
+}
+
+// ReadCuratedKeywords returns the list of curated keywords with their aliases.
+// The keywords are read from a "doi-keywords.json" file found besides the DOI
+// environment variables file. If this file is not available, the default
+// keyword list is used. If none of the lists can be read, an empty
+// []DOIKeyword is returned.
+func ReadCuratedKeywords() []DOIKeyword {
+	// try to load custom keyword file from the env var directory
+	filepath := filepath.Join(libgin.ReadConf("configdir"), "doi-keywords.json")
+	keywords, err := keywordsFromFile(filepath)
+	if err == nil {
+		log.Println("Using custom keywords")
+		return keywords
+	}
+
+	var defaultKeywords []DOIKeyword
+	if err = json.Unmarshal([]byte(defaultKeywordsJSON), &defaultKeywords); err == nil {
+		log.Println("Using default keywords")
+		return defaultKeywords
+	}
+
+	log.Println("Could not load keywords")
+	return []DOIKeyword{}
+}
+
+// keywordsFromFile reads a curated keyword list from a JSON file.
+func keywordsFromFile(filepath string) ([]DOIKeyword, error) {
+	jdata, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	var keywords []DOIKeyword
+	if err = json.Unmarshal(jdata, &keywords); err != nil {
+		return nil, err
+	}
+	return keywords, nil
+}
+
+// keywordUse is a keyword spelling and the DOIs of the datasets using it.
+type keywordUse struct {
+	Keyword string
+	DOIs    []string
+}
+
+// keywordGroup is a set of keyword pages that are so similar that they should
+// probably be merged, with all spellings of the keywords on each page.
+type keywordGroup struct {
+	Paths []string
+	Uses  []keywordUse
+}
+
+// nearDuplicateKeywords groups the keywords of the given datasets that end up
+// on different keyword pages although they only differ by separators or by a
+// small number of characters. Groups are sorted by their first keyword path.
+func nearDuplicateKeywords(kn *keywordNormaliser, datasets []*libgin.RepositoryMetadata) []keywordGroup {
+	// collect the spellings of each keyword page
+	uses := make(map[string]map[string][]string)
+	for _, md := range datasets {
+		if md.Subjects == nil {
+			continue
+		}
+		for _, kw := range *md.Subjects {
+			path := kn.Path(kw)
+			if path == "" {
+				continue
+			}
+			if uses[path] == nil {
+				uses[path] = make(map[string][]string)
+			}
+			uses[path][kw] = append(uses[path][kw], md.Identifier.ID)
+		}
+	}
+	paths := make([]string, 0, len(uses))
+	for path := range uses {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// union-find over similar paths
+	parent := make([]int, len(paths))
+	for idx := range parent {
+		parent[idx] = idx
+	}
+	var find func(int) int
+	find = func(idx int) int {
+		if parent[idx] != idx {
+			parent[idx] = find(parent[idx])
+		}
+		return parent[idx]
+	}
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			if !similarKeywords(paths[i], paths[j]) {
+				continue
+			}
+			// the smallest index is the root so groups keep the path order
+			ri, rj := find(i), find(j)
+			if ri < rj {
+				parent[rj] = ri
+			} else {
+				parent[ri] = rj
+			}
+		}
+	}
+
+	members := make(map[int][]string)
+	for idx, path := range paths {
+		root := find(idx)
+		members[root] = append(members[root], path)
+	}
+	groups := make([]keywordGroup, 0)
+	for idx := range paths {
+		group, ok := members[idx]
+		if !ok || len(group) < 2 {
+			continue
+		}
+		kwgroup := keywordGroup{Paths: group}
+		for _, path := range group {
+			spellings := make([]string, 0, len(uses[path]))
+			for kw := range uses[path] {
+				spellings = append(spellings, kw)
+			}
+			sort.Strings(spellings)
+			for _, kw := range spellings {
+				kwgroup.Uses = append(kwgroup.Uses, keywordUse{Keyword: kw, DOIs: uses[path][kw]})
+			}
+		}
+		groups = append(groups, kwgroup)
+	}
+	return groups
+}
+
+// similarKeywords returns true if two keyword paths are equal when ignoring
+// separators or if their edit distance is small compared to their length.
+func similarKeywords(a, b string) bool {
+	compact := func(s string) string { return strings.ReplaceAll(s, "_", "") }
+	if compact(a) == compact(b) {
+		return true
+	}
+	ra, rb := []rune(a), []rune(b)
+	shortest := len(ra)
+	if len(rb) < shortest {
+		shortest = len(rb)
+	}
+	switch {
+	case shortest < 4:
+		// short keywords (abbreviations) are too ambiguous
+		return false
+	case shortest < 8:
+		return editDistance(ra, rb) <= 1
+	default:
+		return editDistance(ra, rb) <= 2
+	}
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
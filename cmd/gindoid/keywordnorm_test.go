@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestKeywordNormaliser(t *testing.T) {
+	kn := newKeywordNormaliser([]DOIKeyword{
+		{Name: "Electrophysiology", Alias: []string{"ephys", "electro-physiology"}},
+		{Name: "Functional  MRI", Alias: []string{"fMRI"}},
+	})
+
+	for kw, expected := range map[string][2]string{
+		"Electrophysiology":           {"Electrophysiology", "electrophysiology"},
+		" electrophysiology\t":        {"Electrophysiology", "electrophysiology"},
+		"EPHYS":                       {"Electrophysiology", "electrophysiology"},
+		"Electro-Physiology":          {"Electrophysiology", "electrophysiology"},
+		"fmri":                        {"Functional MRI", "functional_mri"},
+		"Spike   sorting":             {"Spike sorting", "spike_sorting"},
+		"Spike/LFP":                   {"Spike/LFP", "spike_lfp"},
+		"Réseaux de neurones":         {"Réseaux de neurones", "reseaux_de_neurones"},
+		"Ｆｕｌｌｗｉｄｔｈ":                   {"Fullwidth", "fullwidth"},
+		"(Ca2+) imaging":              {"(Ca2+) imaging", "ca2_imaging"},
+		"Resting state":               {"Resting state", "resting_state"},
+		"Magnetoencephalography, MEG": {"Magnetoencephalography, MEG", "magnetoencephalography_meg"},
+	} {
+		if name := kn.Name(kw); name != expected[0] {
+			t.Errorf("Unexpected name for %q: %q (expected %q)", kw, name, expected[0])
+		}
+		if path := kn.Path(kw); path != expected[1] {
+			t.Errorf("Unexpected path for %q: %q (expected %q)", kw, path, expected[1])
+		}
+	}
+}
+
+func TestReadCuratedKeywords(t *testing.T) {
+	// check loading default keywords
+	if kwlist := ReadCuratedKeywords(); len(kwlist) < 2 {
+		t.Fatalf("Could not read default keywords")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_readCuratedKeywords")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	content := `[{"Name": "Neuroinformatics", "Alias": ["NI"]}]`
+	if err := writeTmpFile(filepath.Join(tmpDir, "doi-keywords.json"), content); err != nil {
+		t.Fatalf("Error creating json file: '%s'", err.Error())
+	}
+	defer os.Setenv("configdir", os.Getenv("configdir"))
+	if err := os.Setenv("configdir", tmpDir); err != nil {
+		t.Fatalf("Error setting environment: %s", err.Error())
+	}
+	kwlist := ReadCuratedKeywords()
+	if len(kwlist) != 1 || kwlist[0].Name != "Neuroinformatics" || len(kwlist[0].Alias) != 1 {
+		t.Fatalf("Unexpected custom keywords: %+v", kwlist)
+	}
+}
+
+func TestNearDuplicateKeywords(t *testing.T) {
+	kn := newKeywordNormaliser([]DOIKeyword{{Name: "Electrophysiology", Alias: []string{"ephys"}}})
+	datasets := []*libgin.RepositoryMetadata{
+		testKeywordMetadata("10.12751/g-node.aaaaaa", "A", "2020-01-01", "Electrophysiology", "Spike sorting", "EEG"),
+		testKeywordMetadata("10.12751/g-node.bbbbbb", "B", "2020-01-01", "ephys", "Spikesorting", "ECG"),
+		testKeywordMetadata("10.12751/g-node.cccccc", "C", "2020-01-01", "Electrophysiolgy", "Calcium imaging"),
+		testKeywordMetadata("10.12751/g-node.dddddd", "D", "2020-01-01", "Calcium imagings", "Neuroscience"),
+	}
+	groups := nearDuplicateKeywords(kn, datasets)
+	if len(groups) != 3 {
+		t.Fatalf("Unexpected number of groups: %+v", groups)
+	}
+	expected := []string{
+		"calcium_imaging,calcium_imagings",
+		"electrophysiolgy,electrophysiology",
+		"spike_sorting,spikesorting",
+	}
+	for idx, group := range groups {
+		if paths := strings.Join(group.Paths, ","); paths != expected[idx] {
+			t.Errorf("Unexpected group %d: %s (expected %s)", idx, paths, expected[idx])
+		}
+	}
+	// All spellings of merged keywords are listed
+	uses := groups[1].Uses
+	if len(uses) != 3 || uses[1].Keyword != "Electrophysiology" || uses[2].Keyword != "ephys" || uses[2].DOIs[0] != "10.12751/g-node.bbbbbb" {
+		t.Errorf("Unexpected keyword uses: %+v", uses)
+	}
+	// Short keywords are not compared
+	if similarKeywords("eeg", "ecg") {
+		t.Error("Abbreviations reported as near-duplicates")
+	}
+}
@@ -1,95 +1,262 @@
 package main
 
 import (
-	"encoding/xml"
+	"encoding/json"
 	"fmt"
-	"log"
+	"html/template"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/G-Node/libgin/libgin"
 	"github.com/spf13/cobra"
 )
 
-func mkkeywords(cmd *cobra.Command, args []string) {
-	keywordMap := make(map[string][]*libgin.RepositoryMetadata) // map keywords to DOIs
-	fmt.Println("Reading files")
-	for idx, filearg := range args {
-		var contents []byte
-		var err error
-		if isURL(filearg) {
-			contents, err = readFileAtURL(filearg)
-		} else {
-			contents, err = readFileAtPath(filearg)
+// keywordIndexFile is the name of the file that stores the keyword index next
+// to the keyword pages.
+const keywordIndexFile = "keywords.json"
+
+// keywordEntry is the information about a dataset that is required for
+// listing it on a keyword page. Keyword is the display name of the keyword as
+// used by the dataset. LegacyPath is the path of the keyword page before
+// keywords were normalised, if it differs from the current path; a redirect
+// page is kept there so that existing links keep working.
+type keywordEntry struct {
+	Keyword    string   `json:"keyword,omitempty"`
+	LegacyPath string   `json:"legacypath,omitempty"`
+	DOI        string   `json:"doi"`
+	Title      string   `json:"title"`
+	Date       string   `json:"date"`
+	Authors    []string `json:"authors"`
+}
+
+// newKeywordEntry extracts the keyword page listing information from the
+// metadata of a dataset.
+func newKeywordEntry(md *libgin.RepositoryMetadata) keywordEntry {
+	entry := keywordEntry{
+		DOI:     md.Identifier.ID,
+		Date:    issuedDate(md),
+		Authors: make([]string, len(md.Creators)),
+	}
+	if len(md.Titles) > 0 {
+		entry.Title = md.Titles[0]
+	}
+	for idx, creator := range md.Creators {
+		entry.Authors[idx] = creator.Name
+	}
+	return entry
+}
+
+// metadata reconstructs the parts of the dataset metadata that are used by
+// the Keyword template.
+func (entry keywordEntry) metadata() *libgin.RepositoryMetadata {
+	datacite := new(libgin.DataCite)
+	datacite.Identifier.ID = entry.DOI
+	datacite.Titles = []string{entry.Title}
+	datacite.Dates = []libgin.Date{{Value: entry.Date, Type: "Issued"}}
+	for _, name := range entry.Authors {
+		datacite.Creators = append(datacite.Creators, libgin.Creator{Name: name})
+	}
+	return &libgin.RepositoryMetadata{DataCite: datacite}
+}
+
+// keywordIndex maps keywords to the datasets that use them. It is persisted
+// next to the generated pages so that the pages can be updated incrementally
+// without reading the XML files of all published datasets.
+type keywordIndex map[string][]keywordEntry
+
+// readKeywordIndex reads the keyword index from the given directory. A missing
+// index file results in an empty index.
+func readKeywordIndex(dir string) (keywordIndex, error) {
+	kwindex := make(keywordIndex)
+	data, err := ioutil.ReadFile(filepath.Join(dir, keywordIndexFile))
+	if os.IsNotExist(err) {
+		return kwindex, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &kwindex); err != nil {
+		return nil, fmt.Errorf("invalid keyword index %s: %s", keywordIndexFile, err.Error())
+	}
+	return kwindex, nil
+}
+
+// write stores the keyword index in the given directory.
+func (kwindex keywordIndex) write(dir string) error {
+	data, err := json.MarshalIndent(kwindex, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, keywordIndexFile), data, 0666)
+}
+
+// add lists a dataset under each of its keywords, replacing any previous
+// entries of the same DOI. It returns the keywords whose pages changed.
+func (kwindex keywordIndex) add(md *libgin.RepositoryMetadata) []string {
+	changed := kwindex.remove(md.Identifier.ID)
+	if md.Subjects == nil {
+		return changed
+	}
+	entry := newKeywordEntry(md)
+	for _, kw := range *md.Subjects {
+		kwentry := entry
+		kwentry.Keyword = KeywordName(kw)
+		legacy := legacyKeywordPath(kw)
+		kw = KeywordPath(kw)
+		if legacy != kw {
+			kwentry.LegacyPath = legacy
 		}
-		if err != nil {
-			fmt.Printf("Failed to read file at %q: %s\n", filearg, err.Error())
+		if kw == "" || containsEntry(kwindex[kw], entry.DOI) {
+			// keyword without letters or digits, or keyword that appears
+			// more than once in the dataset
 			continue
 		}
+		datasets := append(kwindex[kw], kwentry)
+		// Sort by date, lex order, which for ISO date strings should work fine
+		sort.SliceStable(datasets, func(i, j int) bool {
+			if datasets[i].Date == datasets[j].Date {
+				return datasets[i].DOI < datasets[j].DOI
+			}
+			return datasets[i].Date > datasets[j].Date
+		})
+		kwindex[kw] = datasets
+		changed = append(changed, kw)
+	}
+	return changed
+}
 
-		datacite := new(libgin.DataCite)
-		err = xml.Unmarshal(contents, datacite)
-		if err != nil {
-			fmt.Printf("Failed to unmarshal contents of %q: %s\n", filearg, err.Error())
+// remove deletes all entries of the given DOI from the index. Keywords that
+// are left without datasets are removed. It returns the keywords whose pages
+// changed.
+func (kwindex keywordIndex) remove(doi string) []string {
+	var changed []string
+	for kw, datasets := range kwindex {
+		if !containsEntry(datasets, doi) {
 			continue
 		}
-		metadata := &libgin.RepositoryMetadata{
-			DataCite: datacite,
+		remaining := make([]keywordEntry, 0, len(datasets)-1)
+		for _, entry := range datasets {
+			if entry.DOI != doi {
+				remaining = append(remaining, entry)
+			}
 		}
-
-		for _, kw := range *metadata.Subjects {
-			kw = KeywordPath(kw)
-			datasets := keywordMap[kw]
-			datasets = append(datasets, metadata)
-			keywordMap[kw] = datasets
+		if len(remaining) == 0 {
+			delete(kwindex, kw)
+		} else {
+			kwindex[kw] = remaining
 		}
-		fmt.Printf(" %d/%d\r", idx+1, len(args))
+		changed = append(changed, kw)
 	}
+	return changed
+}
 
-	fmt.Printf("\nFound %d keywords\n", len(keywordMap))
-	fmt.Println("Creating pages")
+// name returns the display name of a keyword, which is the name used by the
+// most recent dataset. The keyword path is returned for entries without a
+// name.
+func (kwindex keywordIndex) name(kw string) string {
+	if entries := kwindex[kw]; len(entries) > 0 && entries[0].Keyword != "" {
+		return entries[0].Keyword
+	}
+	return kw
+}
 
-	for kw, datasets := range keywordMap {
-		tmpl, err := prepareTemplates("Keyword")
-		if err != nil {
-			continue
+// containsEntry returns true if the list of entries includes the given DOI.
+func containsEntry(entries []keywordEntry, doi string) bool {
+	for _, entry := range entries {
+		if entry.DOI == doi {
+			return true
 		}
-		err = os.MkdirAll(kw, 0777)
-		if err != nil {
-			log.Printf("Could not create the keyword page dir: %s", err.Error())
-			continue
+	}
+	return false
+}
+
+// createKeywordPage renders the page of a single keyword in the given
+// directory. If no dataset uses the keyword any more, the page is deleted.
+func createKeywordPage(dir string, kwindex keywordIndex, kw string) error {
+	entries, ok := kwindex[kw]
+	if !ok {
+		if err := os.Remove(filepath.Join(dir, kw, "index.html")); err != nil && !os.IsNotExist(err) {
+			return err
 		}
+		// Only removes the directory if nothing else was put in it
+		os.Remove(filepath.Join(dir, kw))
+		return nil
+	}
+	storage := NewLocalStorage(dir)
+	if err := storage.MkdirAll(kw); err != nil {
+		return fmt.Errorf("could not create the keyword page dir: %s", err.Error())
+	}
+	datasets := make([]*libgin.RepositoryMetadata, len(entries))
+	for idx, entry := range entries {
+		datasets[idx] = entry.metadata()
+	}
+	data := make(map[string]interface{})
+	data["Keyword"] = kwindex.name(kw)
+	data["Datasets"] = datasets
+	if err := renderTemplate(storage, filepath.Join(kw, "index.html"), data, "Keyword"); err != nil {
+		return err
+	}
+	return createKeywordRedirects(storage, kwindex, kw)
+}
 
-		fp, err := os.Create(fmt.Sprintf("%s/index.html", kw))
-		if err != nil {
-			log.Printf("Could not create the keyword page file: %s", err.Error())
+// keywordRedirectPage is the page stored at the path a keyword page had before
+// keywords were normalised. It forwards to the current keyword page.
+const keywordRedirectPage = `<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+		<meta http-equiv="refresh" content="0; url=%[1]s">
+		<link rel="canonical" href="%[1]s">
+		<title>%[2]s</title>
+	</head>
+	<body>
+		<p>The page for the keyword %[2]s has moved to <a href="%[1]s">%[1]s</a>.</p>
+	</body>
+</html>
+`
+
+// createKeywordRedirects stores a redirect page to the page of the given
+// keyword at each of the legacy paths of its entries. Legacy paths that are
+// the path of another keyword page or that are not a single path element are
+// skipped.
+func createKeywordRedirects(storage StorageBackend, kwindex keywordIndex, kw string) error {
+	target := "../" + url.PathEscape(kw) + "/"
+	name := template.HTMLEscapeString(kwindex.name(kw))
+	done := make(map[string]bool)
+	for _, entry := range kwindex[kw] {
+		legacy := entry.LegacyPath
+		if legacy == "" || done[legacy] {
 			continue
 		}
-		defer fp.Close()
-		data := make(map[string]interface{})
-		data["Keyword"] = kw
-		// Sort by date, lex order, which for ISO date strings should work fine
-		sort.Slice(datasets, func(i, j int) bool {
-			return datasets[i].Dates[0].Value > datasets[j].Dates[0].Value
-		})
-		data["Datasets"] = datasets
-		if err := tmpl.Execute(fp, data); err != nil {
-			log.Printf("Error rendering the keyword: %s", err.Error())
+		done[legacy] = true
+		if _, ok := kwindex[legacy]; ok || legacy == "." || legacy == ".." || filepath.Base(legacy) != legacy {
 			continue
 		}
-		continue
+		if err := storage.MkdirAll(legacy); err != nil {
+			return fmt.Errorf("could not create the keyword redirect dir: %s", err.Error())
+		}
+		page := fmt.Sprintf(keywordRedirectPage, target, name)
+		if err := writeStorageFile(storage, filepath.Join(legacy, "index.html"), []byte(page)); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// make keyword index page
-	keywordList := make([]string, 0, len(keywordMap))
-
+// createKeywordIndexPage renders the page listing all keywords in the given
+// directory.
+func createKeywordIndexPage(dir string, kwindex keywordIndex) error {
 	// collect keywords in slice and sort by the number of datasets for each
-	for kw := range keywordMap {
+	keywordList := make([]string, 0, len(kwindex))
+	for kw := range kwindex {
 		keywordList = append(keywordList, kw)
 	}
 	sort.Slice(keywordList, func(i, j int) bool {
-		ilen := len(keywordMap[keywordList[i]])
-		jlen := len(keywordMap[keywordList[j]])
+		ilen := len(kwindex[keywordList[i]])
+		jlen := len(kwindex[keywordList[j]])
 		if ilen == jlen {
 			// sort alphabetically
 			return keywordList[i] < keywordList[j]
@@ -97,21 +264,132 @@ func mkkeywords(cmd *cobra.Command, args []string) {
 		return ilen > jlen
 	})
 
+	keywordNames := make(map[string]string, len(kwindex))
+	for kw := range kwindex {
+		keywordNames[kw] = kwindex.name(kw)
+	}
+
 	data := make(map[string]interface{})
 	data["KeywordList"] = keywordList
-	data["KeywordMap"] = keywordMap
-	tmpl, err := prepareTemplates("KeywordIndex")
-	if err != nil {
-		return
+	data["KeywordMap"] = kwindex
+	data["KeywordNames"] = keywordNames
+	return renderTemplate(NewLocalStorage(dir), "index.html", data, "KeywordIndex")
+}
+
+// updateKeywordPages renders the pages of the given keywords and the keyword
+// index page and stores the keyword index in the given directory.
+func updateKeywordPages(dir string, kwindex keywordIndex, keywords []string) error {
+	done := make(map[string]bool, len(keywords))
+	for _, kw := range keywords {
+		if done[kw] {
+			continue
+		}
+		done[kw] = true
+		if err := createKeywordPage(dir, kwindex, kw); err != nil {
+			return fmt.Errorf("failed to update the page for keyword %q: %s", kw, err.Error())
+		}
 	}
-	fp, err := os.Create("index.html")
+	if err := createKeywordIndexPage(dir, kwindex); err != nil {
+		return fmt.Errorf("failed to update the keyword index page: %s", err.Error())
+	}
+	return kwindex.write(dir)
+}
+
+// removeKeywords removes the dataset with the given DOI from the keyword index
+// in the given directory and updates the affected keyword pages. It returns
+// the number of keyword pages that changed.
+func removeKeywords(dir string, doi string) (int, error) {
+	kwindex, err := readKeywordIndex(dir)
 	if err != nil {
-		log.Printf("Could not create the keyword page file: %s", err.Error())
-		return
+		return 0, fmt.Errorf("failed to read the keyword index: %s", err.Error())
 	}
-	defer fp.Close()
+	changed := kwindex.remove(doi)
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	return len(changed), updateKeywordPages(dir, kwindex, changed)
+}
 
-	if err := tmpl.Execute(fp, data); err != nil {
-		log.Printf("Error rendering keyword list page: %s", err.Error())
+func mkkeywords(cmd *cobra.Command, args []string) {
+	add, _ := cmd.Flags().GetBool("add")
+	remove, _ := cmd.Flags().GetBool("remove")
+	if add && remove {
+		fmt.Fprintln(os.Stderr, "ERROR: --add and --remove cannot be used together")
+		os.Exit(1)
+	}
+
+	kwindex := make(keywordIndex)
+	if add || remove {
+		var err error
+		kwindex, err = readKeywordIndex(".")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Failed to read the keyword index: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var changed []string
+	if remove {
+		for _, doi := range args {
+			kws := kwindex.remove(doi)
+			if len(kws) == 0 {
+				fmt.Printf("DOI %q not found in the keyword index\n", doi)
+			}
+			changed = append(changed, kws...)
+		}
+	} else {
+		fmt.Println("Reading files")
+		for idx, filearg := range args {
+			metadata, err := readMetadataXML(filearg)
+			if err != nil {
+				fmt.Println(err.Error())
+				continue
+			}
+			if isWithdrawn(metadata) {
+				// withdrawn datasets are not listed
+				changed = append(changed, kwindex.remove(metadata.Identifier.ID)...)
+				continue
+			}
+			changed = append(changed, kwindex.add(metadata)...)
+			fmt.Printf(" %d/%d\r", idx+1, len(args))
+		}
+		fmt.Println()
+	}
+	if !add && !remove {
+		// Full rebuild
+		changed = changed[:0]
+		for kw := range kwindex {
+			changed = append(changed, kw)
+		}
+	}
+
+	fmt.Printf("Found %d keywords\n", len(kwindex))
+	fmt.Println("Creating pages")
+	if err := updateKeywordPages(".", kwindex, changed); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// checkkeywords reads the provided XML files or URLs and prints the groups of
+// near-duplicate keywords that would end up on different keyword pages.
+func checkkeywords(cmd *cobra.Command, args []string) {
+	datasets := make([]*libgin.RepositoryMetadata, 0, len(args))
+	for _, filearg := range args {
+		metadata, err := readMetadataXML(filearg)
+		if err != nil {
+			fmt.Println(err.Error())
+			continue
+		}
+		datasets = append(datasets, metadata)
+	}
+
+	groups := nearDuplicateKeywords(defaultKeywordNormaliser(), datasets)
+	fmt.Printf("Found %d groups of near-duplicate keywords in %d datasets\n", len(groups), len(datasets))
+	for idx, group := range groups {
+		fmt.Printf("\n%d: %s\n", idx+1, strings.Join(group.Paths, ", "))
+		for _, use := range group.Uses {
+			fmt.Printf("\t%q (%d): %s\n", use.Keyword, len(use.DOIs), strings.Join(use.DOIs, ", "))
+		}
 	}
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func testKeywordMetadata(doi, title, date string, keywords ...string) *libgin.RepositoryMetadata {
+	datacite := new(libgin.DataCite)
+	datacite.Identifier.ID = doi
+	datacite.Titles = []string{title}
+	datacite.Creators = []libgin.Creator{{Name: "Doe, Alice"}}
+	datacite.Dates = []libgin.Date{{Value: date, Type: "Issued"}}
+	datacite.Subjects = &keywords
+	return &libgin.RepositoryMetadata{DataCite: datacite}
+}
+
+func TestKeywordPages(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_keywords")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	kwindex, err := readKeywordIndex(tmpDir)
+	if err != nil || len(kwindex) != 0 {
+		t.Fatalf("Unexpected result reading missing index: %v %v", kwindex, err)
+	}
+	kwindex.add(testKeywordMetadata("10.12751/g-node.aaaaaa", "First", "2020-01-01", "Neuroscience", "Spike  sorting"))
+	changed := kwindex.add(testKeywordMetadata("10.12751/g-node.bbbbbb", "Second", "2020-02-01", "Neuroscience", "neuroscience"))
+	if len(changed) != 1 || changed[0] != "neuroscience" {
+		t.Fatalf("Unexpected changed keywords: %v", changed)
+	}
+	if err := updateKeywordPages(tmpDir, kwindex, []string{"neuroscience", "spike_sorting"}); err != nil {
+		t.Fatalf("Error creating keyword pages: %v", err)
+	}
+
+	readPage := func(kw string) string {
+		data, err := ioutil.ReadFile(filepath.Join(tmpDir, kw, "index.html"))
+		if err != nil {
+			t.Fatalf("Error reading keyword page %q: %v", kw, err)
+		}
+		return string(data)
+	}
+	page := readPage("neuroscience")
+	first, second := strings.Index(page, "10.12751/g-node.aaaaaa"), strings.Index(page, "10.12751/g-node.bbbbbb")
+	if first < 0 || second < 0 || second > first {
+		t.Fatalf("Keyword page does not list datasets newest first:\n%s", page)
+	}
+	if !strings.Contains(page, "Doe, Alice") || !strings.Contains(page, "01 Feb. 2020") {
+		t.Errorf("Keyword page is missing dataset details:\n%s", page)
+	}
+	if page := readPage("spike_sorting"); !strings.Contains(page, "with keyword: Spike sorting</h2>") {
+		t.Errorf("Keyword page does not show the keyword name:\n%s", page)
+	}
+
+	// Incremental update from the stored index
+	kwindex, err = readKeywordIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("Error reading keyword index: %v", err)
+	}
+	if len(kwindex) != 2 || len(kwindex["neuroscience"]) != 2 {
+		t.Fatalf("Unexpected keyword index: %+v", kwindex)
+	}
+	// Updating a dataset moves it from one keyword to another
+	changed = kwindex.add(testKeywordMetadata("10.12751/g-node.aaaaaa", "First, revised", "2020-01-01", "Neuroscience", "ECoG"))
+	if err := updateKeywordPages(tmpDir, kwindex, changed); err != nil {
+		t.Fatalf("Error updating keyword pages: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "spike_sorting")); !os.IsNotExist(err) {
+		t.Errorf("Page of unused keyword was not removed: %v", err)
+	}
+	if page := readPage("ecog"); !strings.Contains(page, "First, revised") {
+		t.Errorf("Page of new keyword not rendered:\n%s", page)
+	}
+	if page := readPage("neuroscience"); strings.Contains(page, ">First<") {
+		t.Errorf("Keyword page not updated:\n%s", page)
+	}
+
+	// Removing a withdrawn dataset
+	changed = kwindex.remove("10.12751/g-node.bbbbbb")
+	if len(changed) != 1 || changed[0] != "neuroscience" {
+		t.Fatalf("Unexpected changed keywords: %v", changed)
+	}
+	if err := updateKeywordPages(tmpDir, kwindex, changed); err != nil {
+		t.Fatalf("Error updating keyword pages: %v", err)
+	}
+	if page := readPage("neuroscience"); strings.Contains(page, "10.12751/g-node.bbbbbb") {
+		t.Errorf("Removed dataset still listed:\n%s", page)
+	}
+	index, err := ioutil.ReadFile(filepath.Join(tmpDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Error reading keyword index page: %v", err)
+	}
+	if !strings.Contains(string(index), `href="ecog">ECoG</a>`) || strings.Contains(string(index), `href="spike_sorting"`) {
+		t.Errorf("Unexpected keyword index page:\n%s", index)
+	}
+	if len(kwindex.remove("10.12751/g-node.cccccc")) != 0 {
+		t.Error("Removing an unknown DOI changed keywords")
+	}
+}
+
+func TestKeywordRedirects(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_keywords")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	kwindex := make(keywordIndex)
+	changed := kwindex.add(testKeywordMetadata("10.12751/g-node.aaaaaa", "First", "2020-01-01", "Spike sorting", "Électrophysiologie", "Neuroscience"))
+	if err := updateKeywordPages(tmpDir, kwindex, changed); err != nil {
+		t.Fatalf("Error creating keyword pages: %v", err)
+	}
+	for legacy, kw := range map[string]string{
+		"spike sorting":      "spike_sorting",
+		"électrophysiologie": "electrophysiologie",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(tmpDir, legacy, "index.html"))
+		if err != nil {
+			t.Errorf("Error reading redirect page %q: %v", legacy, err)
+			continue
+		}
+		if target := `url=../` + kw + `/"`; !strings.Contains(string(data), target) {
+			t.Errorf("Redirect page %q does not point to %q:\n%s", legacy, kw, data)
+		}
+	}
+	// Unchanged paths are the keyword page itself
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, "neuroscience", "index.html"))
+	if err != nil || strings.Contains(string(data), "url=../") {
+		t.Errorf("Keyword page replaced by a redirect: %v", err)
+	}
+
+	// The legacy paths are kept in the stored index for incremental updates
+	kwindex, err = readKeywordIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("Error reading keyword index: %v", err)
+	}
+	if entries := kwindex["spike_sorting"]; len(entries) != 1 || entries[0].LegacyPath != "spike sorting" {
+		t.Errorf("Unexpected keyword index entries: %+v", entries)
+	}
+}
@@ -169,6 +169,7 @@ func sendMail(to []string, subject, body string, conf *Configuration) error {
 	c, err := smtp.Dial(conf.Email.Server)
 	if err != nil {
 		log.Print("Could not reach server")
+		conf.Metrics.countEmailFailure()
 		return err
 	}
 	defer c.Close()
@@ -197,6 +198,7 @@ func sendMail(to []string, subject, body string, conf *Configuration) error {
 		err = c.Rcpt(DEFAULTTO)
 		if err != nil {
 			log.Printf("Error: Could not add mail recipient: %q", err.Error())
+			conf.Metrics.countEmailFailure()
 			return err
 		}
 		message = fmt.Sprintf("%s\nTo: %s", message, DEFAULTTO)
@@ -210,6 +212,7 @@ func sendMail(to []string, subject, body string, conf *Configuration) error {
 	wc, err := c.Data()
 	if err != nil {
 		log.Print("Could not write mail")
+		conf.Metrics.countEmailFailure()
 		return err
 	}
 	defer wc.Close()
@@ -259,6 +262,7 @@ func createIssue(job *RegistrationJob, content string, conf *Configuration) (int
 	}
 	if posterr != nil {
 		log.Printf("Failed to create issue or comment on XML repo: %s", posterr.Error())
+		conf.Metrics.countIssueFailure()
 		return -1, posterr
 	} else if resp.StatusCode != http.StatusCreated {
 		var errmsg string
@@ -269,6 +273,7 @@ func createIssue(job *RegistrationJob, content string, conf *Configuration) (int
 			errmsg = fmt.Sprintf("Failed to create issue or comment on XML repo: [%d] %s", resp.StatusCode, msg)
 		}
 		log.Print(errmsg)
+		conf.Metrics.countIssueFailure()
 		return -1, fmt.Errorf(errmsg)
 	}
 	if existingIssue > 0 {
@@ -25,7 +25,7 @@ func setUpCommands(verstr string) *cobra.Command {
 		Version:               fmt.Sprintln(verstr),
 		DisableFlagsInUseLine: true,
 	}
-	cmds := make([]*cobra.Command, 5)
+	cmds := make([]*cobra.Command, 11)
 	cmds[0] = &cobra.Command{
 		Use:                   "start",
 		Short:                 "Start the GIN DOI service",
@@ -35,13 +35,24 @@ func setUpCommands(verstr string) *cobra.Command {
 		DisableFlagsInUseLine: true,
 	}
 	cmds[1] = &cobra.Command{
-		Use:                   "register <repopath>",
-		Short:                 "Register a repository",
+		Use:   "register <repopath>",
+		Short: "Register a repository",
+		Long: `Register a repository.
+
+The command runs the full registration of a GIN repository, specified as "owner/repository", without going through the GIN web request flow. It uses the same configuration as the service, validates the datacite.yml and LICENSE files of the repository, reserves a new DOI (unless one is specified with --doi) and creates the archive of the default branch (or of the revision specified with --revision), landing page and XML file. The registration runs synchronously and the progress is printed while it runs.
+
+Notification emails and the XML repository issue are created as with a web request, unless the --no-notify flag is set.
+
+An embargo date given with --embargo (or in the 'embargo' field of the datacite.yml file) keeps the archive inaccessible after the release until that date; the service makes it available automatically when the embargo ends.`,
 		Args:                  cobra.ExactArgs(1),
 		Run:                   register,
 		Version:               verstr,
 		DisableFlagsInUseLine: true,
 	}
+	cmds[1].Flags().String("doi", "", "Use the given `DOI` instead of reserving a new one (e.g., to re-run a registration)")
+	cmds[1].Flags().String("revision", "", "Register the given `revision` (commit hash or tag) instead of the default branch")
+	cmds[1].Flags().Bool("no-notify", false, "Do not send notification emails or create an issue on the XML repository")
+	cmds[1].Flags().String("embargo", "", "Keep the data inaccessible until the given `date` (YYYY-MM-DD)")
 	cmds[2] = &cobra.Command{
 		Use:   "make-html <xml file>...",
 		Short: "Generate the HTML landing page from one or more DataCite XML files",
@@ -54,18 +65,20 @@ The command accepts file paths and URLs (mixing allowed) and will generate one H
 		DisableFlagsInUseLine: true,
 	}
 	cmds[3] = &cobra.Command{
-		Use:   "make-keyword-pages <xml file>...",
+		Use:   "make-keyword-pages <xml file>... | --remove <doi>...",
 		Short: "Generate keyword index pages",
 		Long: `Generate keyword index pages.
 
 The command accepts file paths and URLs (mixing allowed) and will generate one HTML page for each unique keyword found in the XML files. Each page lists (and links to) all datasets that use the keyword.
 
-Previously generated pages are overwritten, so this command only makes sense if using all published XML files to generate complete listings.`,
+The keywords and the datasets listed on each page are stored in the keyword index file (keywords.json) next to the pages. By default, the index and the pages are rebuilt from the given XML files, so this only makes sense if using all published XML files to generate complete listings. With --add, the given datasets are added to the existing index (replacing earlier entries of the same DOI) and only the affected keyword pages and the keyword list are rendered again. With --remove, the arguments are DOIs which are removed from the index and the affected pages; pages of keywords that are no longer used are deleted. Withdrawn datasets are removed from the index instead of being added.`,
 		Args:                  cobra.MinimumNArgs(1),
 		Run:                   mkkeywords,
 		Version:               verstr,
 		DisableFlagsInUseLine: true,
 	}
+	cmds[3].Flags().Bool("add", false, "Add the datasets to the existing keyword index and only update the affected pages")
+	cmds[3].Flags().Bool("remove", false, "Remove the datasets with the given DOIs from the existing keyword index and pages")
 	cmds[4] = &cobra.Command{
 		Use:   "make-xml <yml file>...",
 		Short: "Generate the doi.xml file from one or more DataCite YAML files",
@@ -77,6 +90,95 @@ The command accepts GIN repositories of format "GIN:owner/repository", yaml file
 		Version:               verstr,
 		DisableFlagsInUseLine: true,
 	}
+	cmds[5] = &cobra.Command{
+		Use:   "verify <doi>...",
+		Short: "Verify the integrity of published archives",
+		Long: `Verify the integrity of published archives.
+
+The command re-hashes the archive of each given DOI in the storage target directory and compares the result to the checksum manifest that was created during registration. If an archive does not match, the files in the archive are checked individually and every modified, missing, or unexpected file is reported. If no target directory is specified with --target, the storage backend configured for the service is used.`,
+		Args:                  cobra.MinimumNArgs(1),
+		Run:                   verify,
+		Version:               verstr,
+		DisableFlagsInUseLine: true,
+	}
+	cmds[5].Flags().String("target", "", "Storage target `directory` containing the published datasets")
+	cmds[6] = &cobra.Command{
+		Use:   "cite <xml file>...",
+		Short: "Print the citation of one or more datasets from their DataCite XML files",
+		Long: `Print the citation of one or more datasets from their DataCite XML files.
+
+The command accepts file paths and URLs (mixing allowed) and prints the citation of each dataset to stdout in the format specified with --format. The supported formats are bibtex, ris, csl-json, and datacite-json. The same files are written next to each landing page during registration and by the make-html command.`,
+		Args:                  cobra.MinimumNArgs(1),
+		Run:                   cite,
+		Version:               verstr,
+		DisableFlagsInUseLine: true,
+	}
+	cmds[6].Flags().String("format", "bibtex", "Citation `format` (bibtex, ris, csl-json, datacite-json)")
+	cmds[7] = &cobra.Command{
+		Use:   "make-index <xml file>...",
+		Short: "Generate the dataset index pages, sitemap, and Atom feed",
+		Long: `Generate the dataset index pages, sitemap, and Atom feed.
+
+The command accepts file paths and URLs (mixing allowed) and generates, in the current directory, a chronological listing of all datasets split into pages (index.html, index-2.html, ...), a sitemap.xml covering the index pages, the landing page of each dataset and the keyword pages, and an Atom feed (atom.xml) of the most recently published datasets. URLs in the sitemap and feed are built from the base URL specified with --url.
+
+Previously generated files are overwritten, so this command only makes sense if using all published XML files to generate complete listings. Withdrawn datasets are skipped.`,
+		Args:                  cobra.MinimumNArgs(1),
+		Run:                   mkindex,
+		Version:               verstr,
+		DisableFlagsInUseLine: true,
+	}
+	cmds[7].Flags().String("url", "https://doi.gin.g-node.org", "Base `URL` where the landing pages and the generated files are served")
+	cmds[7].Flags().Int("page-size", 50, "Number of datasets per index `page`")
+	cmds[7].Flags().Int("feed-size", 20, "Number of datasets in the Atom `feed`")
+	cmds[8] = &cobra.Command{
+		Use:   "check-keywords <xml file>...",
+		Short: "Report near-duplicate keywords",
+		Long: `Report near-duplicate keywords.
+
+The command accepts file paths and URLs (mixing allowed) and lists groups of keywords that end up on different keyword pages although they only differ by separators or a few characters (e.g., typos, singular and plural forms). For each keyword, the spellings used by the datasets and their DOIs are printed so that the keywords can be fixed or added as aliases to the curated keyword list.
+
+Keywords are normalised before they are compared: unicode and whitespace are normalised, case and diacritics are ignored, and synonyms are merged using the curated keyword list, which is read from the doi-keywords.json file in the configuration directory if available.`,
+		Args:                  cobra.MinimumNArgs(1),
+		Run:                   checkkeywords,
+		Version:               verstr,
+		DisableFlagsInUseLine: true,
+	}
+	cmds[9] = &cobra.Command{
+		Use:   "withdraw <doi>...",
+		Short: "Withdraw published datasets",
+		Long: `Withdraw published datasets.
+
+The command withdraws each given DOI from the storage backend configured for the service: the archive is moved to a directory that is not publicly accessible (or deleted, if --purge is set), the archive link is removed from the doi.xml file and a withdrawal notice with the reason given with --reason is added, and the landing page is replaced with a tombstone page that keeps the citation and the basic metadata. The DOI is hidden at DataCite if the DataCite API is configured, so that it still resolves but is no longer listed in searches.
+
+Withdrawn datasets are left out of the dataset index pages and the keyword pages by the make-index and make-keyword-pages commands, and are reported as deleted records via OAI-PMH. The withdrawn datasets are removed from the keyword pages right away if the directory of the keyword pages is configured with the keyworddir setting or specified with --keyword-dir, which takes precedence.`,
+		Args:                  cobra.MinimumNArgs(1),
+		Run:                   withdraw,
+		Version:               verstr,
+		DisableFlagsInUseLine: true,
+	}
+	cmds[9].Flags().String("reason", "", "Reason for the withdrawal, shown on the tombstone page (required)")
+	cmds[9].Flags().Bool("purge", false, "Delete the archive instead of moving it to the restricted quarantine directory")
+	cmds[9].Flags().String("keyword-dir", "", "Update the keyword pages and index in the given `directory` instead of the configured one")
+	cmds[10] = &cobra.Command{
+		Use:                   "config",
+		Short:                 "Inspect the service configuration",
+		Args:                  cobra.NoArgs,
+		Version:               verstr,
+		DisableFlagsInUseLine: true,
+	}
+	cmds[10].AddCommand(&cobra.Command{
+		Use:   "check",
+		Short: "Validate the configuration and print the effective settings",
+		Long: `Validate the configuration and print the effective settings.
+
+The service reads its settings from the environment and from an optional YAML configuration file, which maps the names of the settings (e.g., port, ginurl, preparation) to their values. The file is specified with the 'configfile' environment variable; by default, the gindoid.yml file in the configuration directory ('configdir') is read if it exists. Environment variables take precedence over the file. The value of a setting can be read from a file instead, e.g., a Docker secret, by specifying the file path with the name of the setting followed by '_file' (e.g., ginpassword_file).
+
+The command prints the effective value of each setting and where it was read from, with secrets masked, followed by every problem found: missing required settings, malformed URLs and addresses, missing directories, invalid numbers, and an encryption key of the wrong length. The command exits with a non-zero status if the configuration is invalid. The service refuses to start with an invalid configuration.`,
+		Args:                  cobra.NoArgs,
+		Run:                   checkconfig,
+		Version:               verstr,
+		DisableFlagsInUseLine: true,
+	})
 
 	rootCmd.AddCommand(cmds...)
 	return rootCmd
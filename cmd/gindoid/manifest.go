@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestfname is the name of the checksum manifest file that is stored next
+// to the doi.xml file of each registered dataset.
+const manifestfname = "manifest.json"
+
+// ManifestEntry holds the size and SHA-256 checksum of a single file.
+type ManifestEntry struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// Manifest lists the checksums of a published archive and of all files of the
+// repository content that went into it. It is used to verify the integrity of
+// stored archives.
+type Manifest struct {
+	DOI     string
+	Created time.Time
+	// Packaging mode of the archive (see Configuration.Storage.Packaging)
+	Packaging string
+	Archive   ManifestEntry
+	// Files of the repository content; the paths are relative to the
+	// repository root (or the payload directory of a BagIt bag)
+	Files []ManifestEntry
+}
+
+// sha256Sum returns the hex encoded SHA-256 checksum of the data read from r
+// and the number of bytes read.
+func sha256Sum(r io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return "", size, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// sha256File returns the hex encoded SHA-256 checksum and the size of the
+// file at the given path.
+func sha256File(path string) (string, int64, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", -1, err
+	}
+	defer fp.Close()
+	return sha256Sum(fp)
+}
+
+// checksumWriter passes all writes through to the underlying writer and
+// computes the size and SHA-256 checksum of the written data.
+type checksumWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: w, hash: sha256.New()}
+}
+
+func (cw *checksumWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.hash.Write(p[:n])
+	cw.size += int64(n)
+	return n, err
+}
+
+// entry returns the manifest entry with the given path for the written data.
+func (cw *checksumWriter) entry(path string) ManifestEntry {
+	return ManifestEntry{Path: path, Size: cw.size, SHA256: hex.EncodeToString(cw.hash.Sum(nil))}
+}
+
+// createManifest returns a manifest for the archive entry and the given file
+// entries of its content.
+func createManifest(doi string, archive ManifestEntry, packaging string, files []ManifestEntry) *Manifest {
+	return &Manifest{
+		DOI:       doi,
+		Created:   time.Now(),
+		Packaging: packaging,
+		Archive:   archive,
+		Files:     files,
+	}
+}
+
+// hashFiles computes the checksums of all files under the source directory,
+// except the ones handed over via the exclude parameter. The files are
+// handled like in MakeZip: symlinks are not followed and the link target is
+// used as file content instead, so that the checksums match the content of
+// the archive.
+func hashFiles(source string, exclude []string) ([]ManifestEntry, error) {
+	files := make([]ManifestEntry, 0)
+	walker := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relpath, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		for i := range exclude {
+			if exclude[i] == relpath {
+				return filepath.SkipDir
+			}
+		}
+		if fi.Mode().IsDir() {
+			return nil
+		}
+
+		var checksum string
+		var size int64
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			checksum, size, err = sha256Sum(strings.NewReader(target))
+			if err != nil {
+				return err
+			}
+		} else {
+			checksum, size, err = sha256File(path)
+			if err != nil {
+				return err
+			}
+		}
+		files = append(files, ManifestEntry{Path: filepath.ToSlash(relpath), Size: size, SHA256: checksum})
+		return nil
+	}
+	if err := filepath.Walk(source, walker); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// writeManifest writes the manifest as JSON to the manifest file in the given
+// directory of the storage backend.
+func writeManifest(storage StorageBackend, manifest *Manifest, dir string) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	fp, err := storage.Create(path.Join(dir, manifestfname))
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// readManifest reads the manifest file from the given directory of the
+// storage backend.
+func readManifest(storage StorageBackend, dir string) (*Manifest, error) {
+	fp, err := storage.Open(path.Join(dir, manifestfname))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	data, err := ioutil.ReadAll(fp)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+// parseManifest unmarshals the JSON content of a manifest file.
+func parseManifest(data []byte) (*Manifest, error) {
+	manifest := new(Manifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -4,19 +4,36 @@ const (
 	msgInvalidRequest    = `Invalid request data received.  Please note that requests should only be submitted through repository pages on <a href="https://gin.g-node.org">GIN</a>.  If you followed the instructions in the <a href="https://gin.g-node.org/G-Node/Info/wiki/DOIfile">DOI registration guide</a> and arrived at this error page, please <a href="mailto:gin@g-node.org">contact us</a> for assistance.`
 	msgInvalidDOI        = `The DOI file is missing or not valid. See the messages below for specific issues with the provided data.<br>Also, please see <a href="https://gin.g-node.org/G-Node/Info/wiki/DOIfile">the DOI guide</a> for detailed instructions.`
 	msgInvalidURI        = "Please provide a valid repository URI"
+	msgRequestExpired    = `This registration link has expired. Please start the DOI registration again from the repository page on <a href="https://gin.g-node.org">GIN</a>.`
+	msgRequestUsed       = `This registration request has already been submitted. The progress of the registration is reported by email. To register the repository again, please start the DOI registration from the repository page on <a href="https://gin.g-node.org">GIN</a>.`
+	msgTooManyRequests   = `Too many requests have been received from your network. Please wait a minute and try again.`
+	msgTooManySubmitted  = `You have submitted too many registration requests in a short time. Please try again later or <a href="mailto:gin@g-node.org">contact us</a> if you need to register more datasets.`
+	msgTooManyOpen       = `You already have %d registrations that are being processed or waiting for review. Please wait until they have been reviewed before submitting another dataset, or <a href="mailto:gin@g-node.org">contact us</a> if you need to register more datasets.`
+	msgServiceBusy       = `The DOI service is busy and cannot start your registration right now. Please submit the request again in a few minutes.`
 	msgAlreadyRegistered = `<div class="content">
 								<div class="header"> A DOI is already registered for your dataset.</div>
 								Your DOI is: <br>
 								<div class ="ui label label-default"><a href="https://doi.org/%s">%s</a></div></br>
 								If this is incorrect or you would like to register a new version of your dataset, please <a href=mailto:gin@g-node.org>contact us</a>.
 							</div>`
-	msgServerIsArchiving = `<div class="content">
+	msgDuplicateSubmission = `<div class="content">
+			<div class="header">This repository has already been submitted for registration.</div>
+		The following DOI has been reserved for your dataset:<br>
+		<div class="ui label label-default">%s</div><br>
+		The current state of the registration is: %s.<br>
+		The progress can be followed on the <a href="/status/%s?format=html">registration status page</a>.<br>
+		%s
+		If you would like to register a new version of your dataset, please <a href=mailto:gin@g-node.org>contact us</a>.
+		</div>`
+	msgDuplicateUnresolved = `The current commit of your repository could not be determined, so this request was matched by the repository and revision only.<br>`
+	msgServerIsArchiving   = `<div class="content">
 			<div class="header">The DOI server has started archiving your repository.</div>
 		We have reserved the following DOI for your dataset:<br>
 		<div class="ui label label-default">%s</div><br>
 		An email has been sent containing the above information to your registered address on GIN.<br>
 		Please note that the registration process includes a manual curation step. It may therefore take up to two work days until the DOI is available. If any changes to the repository should be necessary you will be contacted by the curation team.<br>
 		We will notify you via email once the process is finished.<br>
+		The progress of the archiving can be followed on the <a href="/status/%s?format=html">registration status page</a>.<br>
 		<div class="ui tabs divider"> </div>
 		<b>This page can safely be closed. You do not need to keep it open.</b>
 		</div>
@@ -30,6 +47,40 @@ Please note that the registration process includes a manual curation step. It ma
 We will notify you via email once the process is finished.
 
 If you would like to make any changes to the dataset before it is published, or if you have any questions or concerns, feel free to contact us at gin@g-node.org.
+`
+	msgReleasedEmail = `Dear %s,
+
+The dataset of the GIN repository %s has been reviewed by the curation team and published with the DOI %s.
+The landing page of the dataset is available at %s
+Please note that it may take a few hours until the DOI resolves to the landing page.
+
+Thank you for publishing your data with GIN. If you have any questions, feel free to contact us at gin@g-node.org.
+`
+	msgEmbargoedEmail = `Dear %s,
+
+The dataset of the GIN repository %s has been reviewed by the curation team and published with the DOI %s.
+The landing page of the dataset is available at %s
+Please note that it may take a few hours until the DOI resolves to the landing page.
+
+As requested, the data are embargoed: the landing page shows the metadata of the dataset, but the archive and the DOI fork of the repository will only become available on %s. We will notify you once the data are available.
+
+Thank you for publishing your data with GIN. If you have any questions, feel free to contact us at gin@g-node.org.
+`
+	msgEmbargoEndedEmail = `Dear %s,
+
+The embargo of the dataset of the GIN repository %s (DOI %s) has ended and the data are now publicly available.
+The landing page of the dataset is available at %s
+
+Thank you for publishing your data with GIN. If you have any questions, feel free to contact us at gin@g-node.org.
+`
+	msgRejectedEmail = `Dear %s,
+
+The curation team has reviewed your request to publish the GIN repository %s (reserved DOI %s).
+The dataset could not be published for the following reason:
+
+%s
+
+Please feel free to contact us at gin@g-node.org if you have any questions or once the issues have been resolved.
 `
 	msgNotLoggedIn      = `You are not logged in with the gin service. Login <a href="http://gin.g-node.org/">here</a>`
 	msgNoToken          = "No authentication token provided"
@@ -42,10 +93,13 @@ If you would like to make any changes to the dataset before it is published, or
 	msgNoLicenseFile    = `The LICENSE file is missing. The full text of the license is required to be in the repository when publishing. See the <a href="https://gin.g-node.org/G-Node/Info/wiki/Licensing">Licensing</a> help page for details and links to recommended data licenses.`
 	msgLicenseMismatch  = `The LICENSE file does not match the license specified in the metadata. See the <a href="https://gin.g-node.org/G-Node/Info/wiki/Licensing">Licensing</a> help page for links to full text for available licenses.`
 	msgInvalidReference = `Not all <b>Reference</b> entries are valid. Please provide the full citation and type of the reference.`
+	msgInvalidRevision  = `The requested repository revision is not valid. Please provide a commit hash or tag name.`
+	msgInvalidEmbargo   = `The <b>embargo</b> date is not valid. Please provide the date on which the data should become available in the format YYYY-MM-DD.`
 	msgBadEncoding      = `There was an issue with the content of the DOI file (datacite.yml). This might mean that the encoding is wrong. Please see <a href="https://gin.g-node.org/G-Node/Info/wiki/DOIfile">the DOI guide</a> for detailed instructions or contact gin@g-node.org for assistance.`
 
 	msgSubmitError     = "An internal error occurred while we were processing your request.  The G-Node team has been notified of the problem and will attempt to repair it and process your request.  We may contact you for further information regarding your request.  Feel free to <a href=mailto:gin@g-node.org>contact us</a> if you would like to provide more information or ask about the status of your request."
 	msgSubmitFailed    = "An internal error occurred while we were processing your request.  Your request was not submitted and the service failed to notify the G-Node team.  Please <a href=mailto:gin@g-node.org>contact us</a> to report this error."
+	msgShuttingDown    = "The service is restarting and cannot accept new requests at the moment.  Please try again in a few minutes."
 	msgNoTemplateError = "An internal error occurred while we were processing your request.  The G-Node team has been notified of the problem and will attempt to repair it and process your request.  We may contact you for further information regarding your request.  Feel free to contact us at gin@g-node.org if you would like to provide more information or ask about the status of your request."
 	// Log Prefixes
 	lpAuth    = "GinOAP"
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsPrefix is the common prefix of the names of all metrics exported by
+// the service.
+const metricsPrefix = "gindoid_"
+
+// Rejection reasons of the repository validation that are counted in the
+// metrics.
+const (
+	rejectInvalidRevision = "invalid_revision"
+	rejectNoLicenseFile   = "missing_license_file"
+	rejectNoDataCite      = "missing_datacite_file"
+	rejectBadDataCite     = "invalid_datacite_file"
+	rejectInvalidMetadata = "invalid_metadata"
+	rejectInvalidEmbargo  = "invalid_embargo"
+)
+
+// Reasons for turning away registration requests that are counted in the
+// metrics.
+const (
+	throttleClientRate = "client_rate_limit"
+	throttleUserRate   = "user_rate_limit"
+	throttleOpenJobs   = "open_registrations"
+	throttleQueueFull  = "queue_full"
+)
+
+var (
+	// stageBuckets are the upper bounds in seconds of the histogram buckets
+	// of the processing stage durations.
+	stageBuckets = []float64{1, 5, 15, 60, 300, 900, 3600, 3 * 3600, 12 * 3600}
+	// archiveBuckets are the upper bounds in bytes of the histogram buckets
+	// of the archive sizes.
+	archiveBuckets = []float64{1 << 20, 10 << 20, 100 << 20, 1 << 30, 10 << 30, 100 << 30, 1 << 40}
+)
+
+// histogram counts observations in buckets with fixed upper bounds.
+type histogram struct {
+	bounds []float64
+	// Number of observations per bucket; the last element counts the
+	// observations above the largest bound
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, value)]++
+	h.sum += value
+	h.count++
+}
+
+// write writes the cumulative buckets, sum, and count of the histogram in the
+// Prometheus text format. The labels are added to each sample.
+func (h *histogram) write(w io.Writer, name string, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for idx, bound := range h.bounds {
+		cumulative += h.counts[idx]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=%q} %d\n", name, labels, sep, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.count)
+}
+
+// Metrics collects the counters and histograms of the registration processing
+// that are exported on the /metrics endpoint. The methods may be called on a
+// nil Metrics, in which case nothing is recorded.
+type Metrics struct {
+	mutex sync.Mutex
+	// Finished registration jobs by final state
+	registrations map[string]uint64
+	emailFailures uint64
+	issueFailures uint64
+	// Failed repository validations by reason
+	rejections map[string]uint64
+	// Registration requests turned away by reason
+	throttled map[string]uint64
+	// Durations of the processing stages by stage name
+	stages       map[string]*histogram
+	archiveSizes *histogram
+}
+
+// newMetrics returns an empty Metrics collection.
+func newMetrics() *Metrics {
+	return &Metrics{
+		registrations: make(map[string]uint64),
+		rejections:    make(map[string]uint64),
+		throttled:     make(map[string]uint64),
+		stages:        make(map[string]*histogram),
+		archiveSizes:  newHistogram(archiveBuckets),
+	}
+}
+
+// countRegistration counts a registration job that finished in the given
+// state.
+func (m *Metrics) countRegistration(state JobState) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.registrations[string(state)]++
+}
+
+// countEmailFailure counts a notification email that could not be sent.
+func (m *Metrics) countEmailFailure() {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.emailFailures++
+}
+
+// countIssueFailure counts a failed attempt to create an issue or comment on
+// the XML repository.
+func (m *Metrics) countIssueFailure() {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.issueFailures++
+}
+
+// countRejection counts a failed repository validation with the given reason.
+func (m *Metrics) countRejection(reason string) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.rejections[reason]++
+}
+
+// countThrottled counts a registration request that was turned away by a rate
+// limit, a quota, or a full job queue.
+func (m *Metrics) countThrottled(reason string) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.throttled[reason]++
+}
+
+// observeStage records the duration of a finished processing stage.
+func (m *Metrics) observeStage(name string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	hist, ok := m.stages[name]
+	if !ok {
+		hist = newHistogram(stageBuckets)
+		m.stages[name] = hist
+	}
+	hist.observe(duration.Seconds())
+}
+
+// observeArchiveSize records the size of a created archive in bytes.
+func (m *Metrics) observeArchiveSize(size int64) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.archiveSizes.observe(float64(size))
+}
+
+// write writes the collected metrics in the Prometheus text format.
+func (m *Metrics) write(w io.Writer) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	writeHeader(w, "registrations_total", "counter", "Number of finished registration jobs by final state.")
+	for _, state := range sortedKeys(m.registrations) {
+		fmt.Fprintf(w, "%sregistrations_total{state=%q} %d\n", metricsPrefix, state, m.registrations[state])
+	}
+
+	writeHeader(w, "email_failures_total", "counter", "Number of notification emails that could not be sent.")
+	fmt.Fprintf(w, "%semail_failures_total %d\n", metricsPrefix, m.emailFailures)
+	writeHeader(w, "issue_failures_total", "counter", "Number of failed attempts to create an issue or comment on the XML repository.")
+	fmt.Fprintf(w, "%sissue_failures_total %d\n", metricsPrefix, m.issueFailures)
+
+	writeHeader(w, "validation_rejections_total", "counter", "Number of failed repository validations by reason.")
+	for _, reason := range sortedKeys(m.rejections) {
+		fmt.Fprintf(w, "%svalidation_rejections_total{reason=%q} %d\n", metricsPrefix, reason, m.rejections[reason])
+	}
+
+	writeHeader(w, "throttled_requests_total", "counter", "Number of registration requests turned away by rate limits, quotas, or a full job queue by reason.")
+	for _, reason := range sortedKeys(m.throttled) {
+		fmt.Fprintf(w, "%sthrottled_requests_total{reason=%q} %d\n", metricsPrefix, reason, m.throttled[reason])
+	}
+
+	writeHeader(w, "stage_duration_seconds", "histogram", "Duration of the processing stages of registration jobs.")
+	names := make([]string, 0, len(m.stages))
+	for name := range m.stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.stages[name].write(w, metricsPrefix+"stage_duration_seconds", fmt.Sprintf("stage=%q", name))
+	}
+
+	writeHeader(w, "archive_size_bytes", "histogram", "Size of the created dataset archives.")
+	m.archiveSizes.write(w, metricsPrefix+"archive_size_bytes", "")
+}
+
+// serveMetrics returns the handler of the /metrics endpoint. It reports the
+// state of the job queue and the workers of the dispatcher, the number of
+// job records by state, and the collected metrics of the configuration.
+func serveMetrics(conf *Configuration, jobQueue chan *RegistrationJob, dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		writeHeader(w, "queue_length", "gauge", "Number of registration jobs waiting for a worker.")
+		fmt.Fprintf(w, "%squeue_length %d\n", metricsPrefix, len(jobQueue)+dispatcher.waiting())
+		writeHeader(w, "queue_capacity", "gauge", "Maximum number of queued registration jobs (MaxQueue).")
+		fmt.Fprintf(w, "%squeue_capacity %d\n", metricsPrefix, cap(jobQueue))
+		busy := dispatcher.busy()
+		writeHeader(w, "workers", "gauge", "Number of workers by activity.")
+		fmt.Fprintf(w, "%sworkers{status=\"busy\"} %d\n", metricsPrefix, busy)
+		fmt.Fprintf(w, "%sworkers{status=\"idle\"} %d\n", metricsPrefix, dispatcher.size()-busy)
+		writeHeader(w, "workers_max", "gauge", "Configured number of workers (MaxWorkers).")
+		fmt.Fprintf(w, "%sworkers_max %d\n", metricsPrefix, dispatcher.size())
+
+		if records, err := conf.Jobs.list(); err == nil {
+			counts := make(map[string]uint64)
+			for _, rec := range records {
+				counts[string(rec.State)]++
+			}
+			writeHeader(w, "jobs", "gauge", "Number of stored job records by state.")
+			for _, state := range sortedKeys(counts) {
+				fmt.Fprintf(w, "%sjobs{state=%q} %d\n", metricsPrefix, state, counts[state])
+			}
+		} else {
+			log.Printf("Failed to list jobs for the metrics: %s", err.Error())
+		}
+
+		conf.Metrics.write(w)
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric.
+func writeHeader(w io.Writer, name, mtype, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s %s\n", metricsPrefix, name, mtype)
+}
+
+// sortedKeys returns the keys of a map of counts in sorted order.
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// braces encloses a non-empty label list in curly braces.
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// formatFloat formats a sample value without exponent for integral values.
+func formatFloat(value float64) string {
+	return strings.TrimSuffix(strconv.FormatFloat(value, 'f', -1, 64), ".0")
+}
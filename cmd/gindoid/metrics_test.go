@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_metrics")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	conf := &Configuration{Jobs: store, Metrics: newMetrics()}
+	store.metrics = conf.Metrics
+
+	// Stage durations, archive sizes and final states are recorded by the
+	// job store
+	for _, doi := range []string{"10.12751/g-node.aaaaaa", "10.12751/g-node.bbbbbb"} {
+		if err := store.add(newTestJob(doi, "owner/repo")); err != nil {
+			t.Fatalf("Error adding job: %v", err)
+		}
+		store.startStage(doi, "clone repository")
+		store.startStage(doi, "create archive")
+		store.setArchiveSize(doi, 5<<20)
+	}
+	store.finish("10.12751/g-node.aaaaaa", jobDone, nil, nil)
+	store.finish("10.12751/g-node.bbbbbb", jobFailed, []string{"error"}, nil)
+	if err := store.add(newTestJob("10.12751/g-node.cccccc", "owner/repo")); err != nil {
+		t.Fatalf("Error adding job: %v", err)
+	}
+
+	conf.Metrics.countRejection(rejectNoLicenseFile)
+	conf.Metrics.countRejection(rejectNoLicenseFile)
+	conf.Metrics.countRejection(rejectInvalidMetadata)
+	conf.Metrics.countEmailFailure()
+	conf.Metrics.observeStage("render landing page", 2*time.Hour)
+
+	jobQueue := make(chan *RegistrationJob, 10)
+	jobQueue <- newTestJob("10.12751/g-node.dddddd", "owner/repo")
+	dispatcher := newDispatcher(jobQueue, 3)
+
+	rec := httptest.NewRecorder()
+	serveMetrics(conf, jobQueue, dispatcher)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Unexpected response: [%d] %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, expected := range []string{
+		"# TYPE gindoid_queue_length gauge\ngindoid_queue_length 1\n",
+		"gindoid_queue_capacity 10\n",
+		"gindoid_workers{status=\"busy\"} 0\n",
+		"gindoid_workers{status=\"idle\"} 3\n",
+		"gindoid_workers_max 3\n",
+		"gindoid_jobs{state=\"done\"} 1\n",
+		"gindoid_jobs{state=\"failed\"} 1\n",
+		"gindoid_jobs{state=\"queued\"} 1\n",
+		"gindoid_registrations_total{state=\"done\"} 1\n",
+		"gindoid_registrations_total{state=\"failed\"} 1\n",
+		"gindoid_email_failures_total 1\n",
+		"gindoid_issue_failures_total 0\n",
+		"gindoid_validation_rejections_total{reason=\"invalid_metadata\"} 1\n",
+		"gindoid_validation_rejections_total{reason=\"missing_license_file\"} 2\n",
+		"gindoid_stage_duration_seconds_count{stage=\"clone repository\"} 2\n",
+		"gindoid_stage_duration_seconds_bucket{stage=\"create archive\",le=\"1\"} 2\n",
+		"gindoid_stage_duration_seconds_bucket{stage=\"render landing page\",le=\"3600\"} 0\n",
+		"gindoid_stage_duration_seconds_bucket{stage=\"render landing page\",le=\"10800\"} 1\n",
+		"gindoid_stage_duration_seconds_sum{stage=\"render landing page\"} 7200\n",
+		"gindoid_archive_size_bytes_bucket{le=\"1048576\"} 0\n",
+		"gindoid_archive_size_bytes_bucket{le=\"10485760\"} 2\n",
+		"gindoid_archive_size_bytes_bucket{le=\"+Inf\"} 2\n",
+		"gindoid_archive_size_bytes_sum 10485760\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Metrics do not contain %q:\n%s", expected, body)
+		}
+	}
+
+	// Recording on nil metrics must not fail
+	var nilmetrics *Metrics
+	nilmetrics.countRegistration(jobDone)
+	nilmetrics.observeStage("clone repository", time.Second)
+	nilmetrics.write(rec.Body)
+}
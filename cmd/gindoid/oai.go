@@ -0,0 +1,757 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+const (
+	// oaiNamespace is the XML namespace of OAI-PMH 2.0 responses.
+	oaiNamespace = "http://www.openarchives.org/OAI/2.0/"
+	// oaiSchemaLocation is the schema location of OAI-PMH 2.0 responses.
+	oaiSchemaLocation = "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd"
+	// oaiDatestampFormat is the format of the datestamps with the
+	// granularity supported by the repository.
+	oaiDatestampFormat = "2006-01-02T15:04:05Z"
+	// oaiDateFormat is the format of day granularity datestamps, which
+	// harvesters may always use for selective harvesting.
+	oaiDateFormat = "2006-01-02"
+	// oaiPageSize is the number of headers or records returned in a single
+	// list response. Incomplete lists have a resumption token.
+	oaiPageSize = 100
+	// oaiIndexTTL is the time after which the index of published datasets
+	// is rebuilt from the storage backend.
+	oaiIndexTTL = time.Minute
+)
+
+// oaiMetadataFormat describes a metadata format supported by the OAI-PMH
+// endpoint.
+type oaiMetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+// oaiMetadataFormats lists the supported metadata formats.
+var oaiMetadataFormats = []oaiMetadataFormat{
+	{Prefix: "oai_dc", Schema: "http://www.openarchives.org/OAI/2.0/oai_dc.xsd", Namespace: "http://www.openarchives.org/OAI/2.0/oai_dc/"},
+	{Prefix: "oai_datacite", Schema: "http://schema.datacite.org/oai/oai-1.1/oai.xsd", Namespace: "http://schema.datacite.org/oai/oai-1.1/"},
+}
+
+// OAI-PMH error codes.
+const (
+	oaiBadArgument             = "badArgument"
+	oaiBadResumptionToken      = "badResumptionToken"
+	oaiBadVerb                 = "badVerb"
+	oaiCannotDisseminateFormat = "cannotDisseminateFormat"
+	oaiIDDoesNotExist          = "idDoesNotExist"
+	oaiNoRecordsMatch          = "noRecordsMatch"
+	oaiNoSetHierarchy          = "noSetHierarchy"
+)
+
+// oaiVerbArgs lists the arguments allowed for each verb. Required arguments
+// are marked true. The resumptionToken is exclusive and therefore not
+// required for any verb.
+var oaiVerbArgs = map[string]map[string]bool{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier": false},
+	"ListSets":            {"resumptionToken": false},
+	"GetRecord":           {"identifier": true, "metadataPrefix": true},
+	"ListIdentifiers":     {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	"ListRecords":         {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+}
+
+// oaiRequest is the echo of the request in the response. The arguments are
+// omitted for badVerb and badArgument errors.
+type oaiRequest struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL         string `xml:",chardata"`
+}
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type oaiIdentify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiHeader struct {
+	Status     string `xml:"status,attr,omitempty"`
+	Identifier string `xml:"identifier"`
+	Datestamp  string `xml:"datestamp"`
+}
+
+type oaiMetadata struct {
+	Content []byte `xml:",innerxml"`
+}
+
+type oaiRecord struct {
+	Header   oaiHeader    `xml:"header"`
+	Metadata *oaiMetadata `xml:"metadata,omitempty"`
+}
+
+type oaiResumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+type oaiListMetadataFormats struct {
+	Formats []oaiMetadataFormat `xml:"metadataFormat"`
+}
+
+type oaiListIdentifiers struct {
+	Headers         []oaiHeader         `xml:"header"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiListRecords struct {
+	Records         []oaiRecord         `xml:"record"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiGetRecord struct {
+	Record oaiRecord `xml:"record"`
+}
+
+// oaiResponse is the OAI-PMH response document.
+type oaiResponse struct {
+	XMLName             xml.Name                `xml:"OAI-PMH"`
+	Namespace           string                  `xml:"xmlns,attr"`
+	XSI                 string                  `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string                  `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string                  `xml:"responseDate"`
+	Request             oaiRequest              `xml:"request"`
+	Errors              []oaiError              `xml:"error"`
+	Identify            *oaiIdentify            `xml:"Identify,omitempty"`
+	ListMetadataFormats *oaiListMetadataFormats `xml:"ListMetadataFormats,omitempty"`
+	ListIdentifiers     *oaiListIdentifiers     `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *oaiListRecords         `xml:"ListRecords,omitempty"`
+	GetRecord           *oaiGetRecord           `xml:"GetRecord,omitempty"`
+}
+
+// oaiItem is a published dataset in the OAI-PMH index. Withdrawn datasets
+// are kept as deleted records.
+type oaiItem struct {
+	DOI       string
+	Datestamp time.Time
+	Deleted   bool
+}
+
+// OAIProvider serves the OAI-PMH 2.0 interface for harvesting the metadata
+// of all published datasets. The published datasets are the directories of
+// the storage backend that contain a doi.xml file and are publicly
+// accessible. The datestamp of a record is the modification time of its
+// doi.xml file or the time of its release, whichever is later. Withdrawn
+// datasets are reported as deleted records, with the time of the withdrawal
+// as datestamp.
+type OAIProvider struct {
+	conf *Configuration
+	// Size of the list responses
+	pageSize int
+	// Index of published datasets ordered by datestamp and DOI
+	sync.Mutex
+	items   []oaiItem
+	indexed time.Time
+}
+
+// NewOAIProvider returns an OAIProvider for the datasets in the configured
+// storage backend.
+func NewOAIProvider(conf *Configuration) *OAIProvider {
+	return &OAIProvider{conf: conf, pageSize: oaiPageSize}
+}
+
+// index returns the published datasets. The index is cached for
+// oaiIndexTTL.
+func (p *OAIProvider) index() ([]oaiItem, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.items != nil && time.Since(p.indexed) < oaiIndexTTL {
+		return p.items, nil
+	}
+
+	storage := p.conf.Storage.Backend
+	files, err := storage.List("")
+	if err != nil {
+		return nil, err
+	}
+	withdrawn := make(map[string]time.Time)
+	for _, file := range files {
+		if path.Base(file.Name) == withdrawalfname {
+			withdrawn[path.Dir(file.Name)] = file.ModTime
+		}
+	}
+	items := make([]oaiItem, 0)
+	for _, file := range files {
+		if path.Base(file.Name) != "doi.xml" {
+			continue
+		}
+		doi := path.Dir(file.Name)
+		if doi == "." {
+			continue
+		}
+		if public, err := storage.IsPublic(doi); err != nil || !public {
+			continue
+		}
+		// Datasets are made public after the doi.xml file was written; use
+		// the release time so incremental harvests pick them up
+		datestamp := file.ModTime
+		if rec, err := p.conf.Jobs.get(doi); err == nil && rec.State == jobReleased && rec.Updated.After(datestamp) {
+			datestamp = rec.Updated
+		}
+		wdate, deleted := withdrawn[doi]
+		if deleted && wdate.After(datestamp) {
+			datestamp = wdate
+		}
+		items = append(items, oaiItem{DOI: doi, Datestamp: datestamp.UTC().Truncate(time.Second), Deleted: deleted})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Datestamp.Equal(items[j].Datestamp) {
+			return items[i].DOI < items[j].DOI
+		}
+		return items[i].Datestamp.Before(items[j].Datestamp)
+	})
+	p.items = items
+	p.indexed = time.Now()
+	return items, nil
+}
+
+// repositoryIdentifier returns the repository part of the OAI identifiers,
+// which is the host name of the storage URL.
+func (p *OAIProvider) repositoryIdentifier() string {
+	if storeURL, err := url.Parse(p.conf.Storage.StoreURL); err == nil && storeURL.Hostname() != "" {
+		return storeURL.Hostname()
+	}
+	return "gin-doi"
+}
+
+// oaiIdentifier returns the OAI identifier of a dataset.
+func (p *OAIProvider) oaiIdentifier(doi string) string {
+	return fmt.Sprintf("oai:%s:%s", p.repositoryIdentifier(), doi)
+}
+
+// header returns the record header of an indexed dataset.
+func (p *OAIProvider) header(item oaiItem) oaiHeader {
+	header := oaiHeader{Identifier: p.oaiIdentifier(item.DOI), Datestamp: item.Datestamp.Format(oaiDatestampFormat)}
+	if item.Deleted {
+		header.Status = "deleted"
+	}
+	return header
+}
+
+// find returns the indexed dataset with the given OAI identifier.
+func (p *OAIProvider) find(identifier string) (oaiItem, bool) {
+	prefix := fmt.Sprintf("oai:%s:", p.repositoryIdentifier())
+	if !strings.HasPrefix(identifier, prefix) {
+		return oaiItem{}, false
+	}
+	doi := strings.TrimPrefix(identifier, prefix)
+	items, err := p.index()
+	if err != nil {
+		log.Printf("Failed to index published datasets: %s", err.Error())
+		return oaiItem{}, false
+	}
+	for _, item := range items {
+		if item.DOI == doi {
+			return item, true
+		}
+	}
+	return oaiItem{}, false
+}
+
+// ServeHTTP handles OAI-PMH GET and POST requests.
+func (p *OAIProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp := &oaiResponse{
+		Namespace:      oaiNamespace,
+		XSI:            "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: oaiSchemaLocation,
+		ResponseDate:   time.Now().UTC().Format(oaiDatestampFormat),
+		Request:        oaiRequest{BaseURL: requestBaseURL(r)},
+	}
+	if err := r.ParseForm(); err != nil {
+		resp.Errors = []oaiError{{Code: oaiBadArgument, Message: "Malformed request"}}
+	} else {
+		p.handle(r.Form, resp)
+	}
+
+	data, err := xml.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		log.Printf("Failed to render OAI-PMH response: %s", err.Error())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// requestBaseURL returns the URL of the endpoint as requested by the client.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+}
+
+// handle validates the request arguments and fills the response for the
+// requested verb.
+func (p *OAIProvider) handle(args url.Values, resp *oaiResponse) {
+	verb := args.Get("verb")
+	allowed, ok := oaiVerbArgs[verb]
+	if !ok || len(args["verb"]) != 1 {
+		resp.Errors = []oaiError{{Code: oaiBadVerb, Message: "Illegal OAI verb"}}
+		return
+	}
+	for name, values := range args {
+		if _, ok := allowed[name]; !ok && name != "verb" {
+			resp.Errors = append(resp.Errors, oaiError{Code: oaiBadArgument, Message: fmt.Sprintf("Illegal argument %q", name)})
+		} else if len(values) != 1 {
+			resp.Errors = append(resp.Errors, oaiError{Code: oaiBadArgument, Message: fmt.Sprintf("Repeated argument %q", name)})
+		}
+	}
+	if args.Get("resumptionToken") != "" {
+		if len(args) != 2 {
+			resp.Errors = append(resp.Errors, oaiError{Code: oaiBadArgument, Message: "The resumptionToken argument is exclusive"})
+		}
+	} else {
+		for name, required := range allowed {
+			if required && args.Get(name) == "" {
+				resp.Errors = append(resp.Errors, oaiError{Code: oaiBadArgument, Message: fmt.Sprintf("Missing argument %q", name)})
+			}
+		}
+	}
+	if len(resp.Errors) > 0 {
+		return
+	}
+
+	resp.Request.Verb = verb
+	resp.Request.Identifier = args.Get("identifier")
+	resp.Request.MetadataPrefix = args.Get("metadataPrefix")
+	resp.Request.From = args.Get("from")
+	resp.Request.Until = args.Get("until")
+	resp.Request.Set = args.Get("set")
+	resp.Request.ResumptionToken = args.Get("resumptionToken")
+
+	switch verb {
+	case "Identify":
+		p.identify(resp)
+	case "ListMetadataFormats":
+		p.listMetadataFormats(args, resp)
+	case "ListSets":
+		resp.Errors = []oaiError{{Code: oaiNoSetHierarchy, Message: "This repository does not support sets"}}
+	case "GetRecord":
+		p.getRecord(args, resp)
+	case "ListIdentifiers", "ListRecords":
+		p.list(verb, args, resp)
+	}
+}
+
+// identify describes the repository.
+func (p *OAIProvider) identify(resp *oaiResponse) {
+	earliest := time.Unix(0, 0).UTC()
+	if items, err := p.index(); err == nil && len(items) > 0 {
+		earliest = items[0].Datestamp
+	}
+	adminEmail := p.conf.Email.From
+	if adminEmail == "" {
+		adminEmail = "gin@g-node.org"
+	}
+	resp.Identify = &oaiIdentify{
+		RepositoryName:    "G-Node Open Data",
+		BaseURL:           resp.Request.BaseURL,
+		ProtocolVersion:   "2.0",
+		AdminEmail:        adminEmail,
+		EarliestDatestamp: earliest.Format(oaiDatestampFormat),
+		DeletedRecord:     "persistent",
+		Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+	}
+}
+
+// listMetadataFormats lists the supported metadata formats, which are
+// available for all records.
+func (p *OAIProvider) listMetadataFormats(args url.Values, resp *oaiResponse) {
+	if identifier := args.Get("identifier"); identifier != "" {
+		if _, ok := p.find(identifier); !ok {
+			resp.Errors = []oaiError{{Code: oaiIDDoesNotExist, Message: fmt.Sprintf("Unknown identifier %q", identifier)}}
+			return
+		}
+	}
+	resp.ListMetadataFormats = &oaiListMetadataFormats{Formats: oaiMetadataFormats}
+}
+
+// isOAIMetadataFormat reports whether the metadata prefix is supported.
+func isOAIMetadataFormat(prefix string) bool {
+	for _, format := range oaiMetadataFormats {
+		if format.Prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// getRecord returns a single record.
+func (p *OAIProvider) getRecord(args url.Values, resp *oaiResponse) {
+	prefix := args.Get("metadataPrefix")
+	identifier := args.Get("identifier")
+	item, ok := p.find(identifier)
+	if !ok {
+		resp.Errors = []oaiError{{Code: oaiIDDoesNotExist, Message: fmt.Sprintf("Unknown identifier %q", identifier)}}
+		return
+	}
+	if !isOAIMetadataFormat(prefix) {
+		resp.Errors = []oaiError{{Code: oaiCannotDisseminateFormat, Message: fmt.Sprintf("Unsupported metadata format %q", prefix)}}
+		return
+	}
+	record, err := p.record(item, prefix)
+	if err != nil {
+		log.Printf("Failed to create OAI-PMH record for %s: %s", item.DOI, err.Error())
+		resp.Errors = []oaiError{{Code: oaiIDDoesNotExist, Message: fmt.Sprintf("Metadata of %q is not available", identifier)}}
+		return
+	}
+	resp.GetRecord = &oaiGetRecord{Record: record}
+}
+
+// parseOAIDate parses a from or until argument. It returns whether the date
+// has day granularity.
+func parseOAIDate(value string) (time.Time, bool, error) {
+	if date, err := time.Parse(oaiDateFormat, value); err == nil {
+		return date, true, nil
+	}
+	date, err := time.Parse(oaiDatestampFormat, value)
+	return date, false, err
+}
+
+// oaiListArgs are the arguments of a list request, which are encoded in the
+// resumption token of incomplete lists.
+type oaiListArgs struct {
+	Verb           string
+	MetadataPrefix string
+	From           string
+	Until          string
+	Offset         int
+}
+
+// encodeToken returns the resumption token for the list arguments.
+func (a oaiListArgs) encodeToken() string {
+	values := url.Values{
+		"verb":           {a.Verb},
+		"metadataPrefix": {a.MetadataPrefix},
+		"from":           {a.From},
+		"until":          {a.Until},
+		"offset":         {strconv.Itoa(a.Offset)},
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(values.Encode()))
+}
+
+// decodeToken parses a resumption token.
+func decodeToken(token string) (oaiListArgs, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return oaiListArgs{}, err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return oaiListArgs{}, err
+	}
+	offset, err := strconv.Atoi(values.Get("offset"))
+	if err != nil || offset < 0 {
+		return oaiListArgs{}, fmt.Errorf("invalid offset %q", values.Get("offset"))
+	}
+	return oaiListArgs{
+		Verb:           values.Get("verb"),
+		MetadataPrefix: values.Get("metadataPrefix"),
+		From:           values.Get("from"),
+		Until:          values.Get("until"),
+		Offset:         offset,
+	}, nil
+}
+
+// list returns a page of the headers (ListIdentifiers) or records
+// (ListRecords) of the published datasets, optionally restricted to a range
+// of datestamps.
+func (p *OAIProvider) list(verb string, args url.Values, resp *oaiResponse) {
+	listargs := oaiListArgs{
+		Verb:           verb,
+		MetadataPrefix: args.Get("metadataPrefix"),
+		From:           args.Get("from"),
+		Until:          args.Get("until"),
+	}
+	if token := args.Get("resumptionToken"); token != "" {
+		var err error
+		listargs, err = decodeToken(token)
+		if err != nil || listargs.Verb != verb {
+			resp.Errors = []oaiError{{Code: oaiBadResumptionToken, Message: "Invalid or expired resumption token"}}
+			return
+		}
+	}
+
+	if args.Get("set") != "" {
+		resp.Errors = []oaiError{{Code: oaiNoSetHierarchy, Message: "This repository does not support sets"}}
+		return
+	}
+	if !isOAIMetadataFormat(listargs.MetadataPrefix) {
+		resp.Errors = []oaiError{{Code: oaiCannotDisseminateFormat, Message: fmt.Sprintf("Unsupported metadata format %q", listargs.MetadataPrefix)}}
+		return
+	}
+
+	var from, until time.Time
+	var fromDay, untilDay bool
+	var err error
+	if listargs.From != "" {
+		if from, fromDay, err = parseOAIDate(listargs.From); err != nil {
+			resp.Errors = []oaiError{{Code: oaiBadArgument, Message: fmt.Sprintf("Invalid from date %q", listargs.From)}}
+			return
+		}
+	}
+	if listargs.Until != "" {
+		if until, untilDay, err = parseOAIDate(listargs.Until); err != nil {
+			resp.Errors = []oaiError{{Code: oaiBadArgument, Message: fmt.Sprintf("Invalid until date %q", listargs.Until)}}
+			return
+		}
+		if untilDay {
+			// Day granularity includes the whole day
+			until = until.Add(24*time.Hour - time.Second)
+		}
+	}
+	if listargs.From != "" && listargs.Until != "" {
+		if fromDay != untilDay {
+			resp.Errors = []oaiError{{Code: oaiBadArgument, Message: "The from and until arguments must have the same granularity"}}
+			return
+		}
+		if until.Before(from) {
+			resp.Errors = []oaiError{{Code: oaiBadArgument, Message: "The from date is later than the until date"}}
+			return
+		}
+	}
+
+	items, err := p.index()
+	if err != nil {
+		log.Printf("Failed to index published datasets: %s", err.Error())
+		resp.Errors = []oaiError{{Code: oaiNoRecordsMatch, Message: "The list of records is currently unavailable"}}
+		return
+	}
+	selected := make([]oaiItem, 0, len(items))
+	for _, item := range items {
+		if listargs.From != "" && item.Datestamp.Before(from) {
+			continue
+		}
+		if listargs.Until != "" && item.Datestamp.After(until) {
+			continue
+		}
+		selected = append(selected, item)
+	}
+	if len(selected) == 0 {
+		resp.Errors = []oaiError{{Code: oaiNoRecordsMatch, Message: "No records match the request"}}
+		return
+	}
+	if listargs.Offset >= len(selected) {
+		resp.Errors = []oaiError{{Code: oaiBadResumptionToken, Message: "Invalid or expired resumption token"}}
+		return
+	}
+
+	end := listargs.Offset + p.pageSize
+	if end > len(selected) {
+		end = len(selected)
+	}
+	var token *oaiResumptionToken
+	if listargs.Offset > 0 || end < len(selected) {
+		// Incomplete list: the last page has an empty token
+		token = &oaiResumptionToken{CompleteListSize: len(selected), Cursor: listargs.Offset}
+		if end < len(selected) {
+			next := listargs
+			next.Offset = end
+			token.Token = next.encodeToken()
+		}
+	}
+	page := selected[listargs.Offset:end]
+
+	if verb == "ListIdentifiers" {
+		headers := make([]oaiHeader, len(page))
+		for idx, item := range page {
+			headers[idx] = p.header(item)
+		}
+		resp.ListIdentifiers = &oaiListIdentifiers{Headers: headers, ResumptionToken: token}
+		return
+	}
+	records := make([]oaiRecord, 0, len(page))
+	for _, item := range page {
+		record, err := p.record(item, listargs.MetadataPrefix)
+		if err != nil {
+			log.Printf("Failed to create OAI-PMH record for %s: %s", item.DOI, err.Error())
+			continue
+		}
+		records = append(records, record)
+	}
+	resp.ListRecords = &oaiListRecords{Records: records, ResumptionToken: token}
+}
+
+// record returns the record of a dataset with the metadata in the given
+// format. Records of withdrawn datasets only consist of the header.
+func (p *OAIProvider) record(item oaiItem, prefix string) (oaiRecord, error) {
+	if item.Deleted {
+		return oaiRecord{Header: p.header(item)}, nil
+	}
+	fp, err := p.conf.Storage.Backend.Open(path.Join(item.DOI, "doi.xml"))
+	if err != nil {
+		return oaiRecord{}, err
+	}
+	data, err := ioutil.ReadAll(fp)
+	fp.Close()
+	if err != nil {
+		return oaiRecord{}, err
+	}
+
+	var metadata []byte
+	switch prefix {
+	case "oai_datacite":
+		metadata, err = oaiDataCite(data, p.conf)
+	case "oai_dc":
+		metadata, err = oaiDublinCore(data)
+	default:
+		err = fmt.Errorf("unsupported metadata format %q", prefix)
+	}
+	if err != nil {
+		return oaiRecord{}, err
+	}
+	return oaiRecord{Header: p.header(item), Metadata: &oaiMetadata{Content: metadata}}, nil
+}
+
+// xmlDeclRE matches the XML declaration at the start of a document.
+var xmlDeclRE = regexp.MustCompile(`^\s*<\?xml[^>]*\?>\s*`)
+
+// oaiDataCite wraps the DataCite XML metadata of a dataset in the
+// oai_datacite format.
+func oaiDataCite(data []byte, conf *Configuration) ([]byte, error) {
+	// Check that the content is a DataCite resource before embedding it
+	if err := xml.Unmarshal(data, new(libgin.DataCite)); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString(`<oai_datacite xmlns="http://schema.datacite.org/oai/oai-1.1/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://schema.datacite.org/oai/oai-1.1/ http://schema.datacite.org/oai/oai-1.1/oai.xsd">`)
+	buf.WriteString("<schemaVersion>4</schemaVersion>")
+	if conf.DataCite != nil {
+		buf.WriteString("<datacentreSymbol>")
+		xml.EscapeText(buf, []byte(conf.DataCite.Username))
+		buf.WriteString("</datacentreSymbol>")
+	}
+	buf.WriteString("<payload>")
+	buf.Write(xmlDeclRE.ReplaceAll(data, nil))
+	buf.WriteString("</payload></oai_datacite>")
+	return buf.Bytes(), nil
+}
+
+// oaiDC is the simple Dublin Core representation of a dataset.
+type oaiDC struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	NamespaceOAIDC string   `xml:"xmlns:oai_dc,attr"`
+	NamespaceDC    string   `xml:"xmlns:dc,attr"`
+	NamespaceXSI   string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Titles         []string `xml:"dc:title"`
+	Creators       []string `xml:"dc:creator"`
+	Subjects       []string `xml:"dc:subject"`
+	Descriptions   []string `xml:"dc:description"`
+	Publisher      string   `xml:"dc:publisher,omitempty"`
+	Contributors   []string `xml:"dc:contributor"`
+	Dates          []string `xml:"dc:date"`
+	Types          []string `xml:"dc:type"`
+	Formats        []string `xml:"dc:format"`
+	Identifiers    []string `xml:"dc:identifier"`
+	Language       string   `xml:"dc:language,omitempty"`
+	Relations      []string `xml:"dc:relation"`
+	Rights         []string `xml:"dc:rights"`
+}
+
+// oaiDublinCore converts the DataCite XML metadata of a dataset to the
+// oai_dc format, following the DataCite to Dublin Core mapping.
+func oaiDublinCore(data []byte) ([]byte, error) {
+	datacite := new(libgin.DataCite)
+	if err := xml.Unmarshal(data, datacite); err != nil {
+		return nil, err
+	}
+	dc := oaiDC{
+		NamespaceOAIDC: "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		NamespaceDC:    "http://purl.org/dc/elements/1.1/",
+		NamespaceXSI:   "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Titles:         datacite.Titles,
+		Publisher:      datacite.Publisher,
+		Language:       datacite.Language,
+	}
+	for _, creator := range datacite.Creators {
+		dc.Creators = append(dc.Creators, creator.Name)
+	}
+	if datacite.Subjects != nil {
+		dc.Subjects = *datacite.Subjects
+	}
+	for _, desc := range datacite.Descriptions {
+		dc.Descriptions = append(dc.Descriptions, desc.Content)
+	}
+	for _, contributor := range datacite.Contributors {
+		dc.Contributors = append(dc.Contributors, contributor.Name)
+	}
+	for _, mddate := range datacite.Dates {
+		dc.Dates = append(dc.Dates, mddate.Value)
+	}
+	if datacite.ResourceType.General != "" {
+		dc.Types = append(dc.Types, datacite.ResourceType.General)
+	}
+	if datacite.ResourceType.Value != "" && datacite.ResourceType.Value != datacite.ResourceType.General {
+		dc.Types = append(dc.Types, datacite.ResourceType.Value)
+	}
+	if datacite.Sizes != nil {
+		dc.Formats = *datacite.Sizes
+	}
+	if datacite.Identifier.ID != "" {
+		dc.Identifiers = append(dc.Identifiers, doiURL(datacite.Identifier.ID))
+	}
+	for _, relid := range datacite.RelatedIdentifiers {
+		relation := relid.Identifier
+		if relid.Type == "DOI" {
+			relation = doiURL(relid.Identifier)
+		}
+		dc.Relations = append(dc.Relations, relation)
+	}
+	for _, rights := range datacite.RightsList {
+		dc.Rights = append(dc.Rights, rights.Name)
+		if rights.URL != "" {
+			dc.Rights = append(dc.Rights, rights.URL)
+		}
+	}
+	return xml.Marshal(dc)
+}
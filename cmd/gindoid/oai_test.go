@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestOAIProvider(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_oai")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	conf := &Configuration{DOIBase: "10.12751/g-node."}
+	conf.Storage.StoreURL = "https://doi.example.org"
+	conf.Storage.Backend = NewLocalStorage(tmpDir)
+
+	// Three published datasets and one that is not released
+	dois := []string{"10.12751/g-node.aaaaaa", "10.12751/g-node.bbbbbb", "10.12751/g-node.cccccc", "10.12751/g-node.dddddd"}
+	dates := []time.Time{
+		time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC),
+		time.Date(2020, 2, 10, 12, 0, 0, 0, time.UTC),
+		time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC),
+		time.Date(2020, 4, 10, 12, 0, 0, 0, time.UTC),
+	}
+	for idx, doi := range dois {
+		yamldata := &libgin.RepositoryYAML{
+			Authors:      []libgin.Author{{FirstName: "Alice", LastName: "Doe"}},
+			Title:        "Dataset " + doi,
+			Description:  "Description",
+			Keywords:     []string{"Neuroscience"},
+			License:      &libgin.License{Name: "CC-BY", URL: "https://creativecommons.org/licenses/by/4.0/"},
+			ResourceType: "Dataset",
+		}
+		datacite := libgin.NewDataCiteFromYAML(yamldata)
+		datacite.Identifier.ID = doi
+		data, err := datacite.Marshal()
+		if err != nil {
+			t.Fatalf("Error creating XML: %v", err)
+		}
+		xmlpath := filepath.Join(tmpDir, filepath.FromSlash(doi), "doi.xml")
+		if err := os.MkdirAll(filepath.Dir(xmlpath), 0777); err != nil {
+			t.Fatalf("Error creating dataset directory: %v", err)
+		}
+		if err := ioutil.WriteFile(xmlpath, []byte(data), 0666); err != nil {
+			t.Fatalf("Error writing XML: %v", err)
+		}
+		if err := os.Chtimes(xmlpath, dates[idx], dates[idx]); err != nil {
+			t.Fatalf("Error setting modification time: %v", err)
+		}
+	}
+	if err := conf.Storage.Backend.SetPublic(dois[3], false); err != nil {
+		t.Fatalf("Error restricting dataset: %v", err)
+	}
+
+	provider := NewOAIProvider(conf)
+	provider.pageSize = 2
+
+	request := func(args url.Values) *oaiResponse {
+		req := httptest.NewRequest(http.MethodGet, "/oai?"+args.Encode(), nil)
+		rec := httptest.NewRecorder()
+		provider.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/xml") {
+			t.Fatalf("Unexpected response: [%d] %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		resp := new(oaiResponse)
+		if err := xml.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatalf("Invalid response XML: %v\n%s", err, rec.Body.String())
+		}
+		return resp
+	}
+	expectError := func(resp *oaiResponse, code string) {
+		if len(resp.Errors) == 0 || resp.Errors[0].Code != code {
+			t.Fatalf("Expected error %q, got %+v", code, resp.Errors)
+		}
+	}
+
+	resp := request(url.Values{"verb": {"Identify"}})
+	if resp.Identify == nil || resp.Identify.EarliestDatestamp != "2020-01-10T12:00:00Z" || resp.Identify.BaseURL != "http://example.com/oai" {
+		t.Fatalf("Unexpected Identify response: %+v", resp.Identify)
+	}
+
+	expectError(request(url.Values{"verb": {"Publish"}}), oaiBadVerb)
+	expectError(request(url.Values{"verb": {"Identify"}, "foo": {"bar"}}), oaiBadArgument)
+	expectError(request(url.Values{"verb": {"ListRecords"}}), oaiBadArgument)
+	expectError(request(url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"marc"}}), oaiCannotDisseminateFormat)
+	expectError(request(url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"oai_dc"}, "set": {"data"}}), oaiNoSetHierarchy)
+	expectError(request(url.Values{"verb": {"ListSets"}}), oaiNoSetHierarchy)
+	expectError(request(url.Values{"verb": {"ListIdentifiers"}, "resumptionToken": {"invalid"}}), oaiBadResumptionToken)
+	expectError(request(url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}, "resumptionToken": {"x"}}), oaiBadArgument)
+
+	resp = request(url.Values{"verb": {"ListMetadataFormats"}})
+	if resp.ListMetadataFormats == nil || len(resp.ListMetadataFormats.Formats) != 2 {
+		t.Fatalf("Unexpected ListMetadataFormats response: %+v", resp.ListMetadataFormats)
+	}
+	expectError(request(url.Values{"verb": {"ListMetadataFormats"}, "identifier": {"oai:doi.example.org:" + dois[3]}}), oaiIDDoesNotExist)
+
+	// Paging with resumption tokens; the restricted dataset is not listed
+	resp = request(url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}})
+	list := resp.ListIdentifiers
+	if list == nil || len(list.Headers) != 2 || list.ResumptionToken == nil || list.ResumptionToken.Token == "" || list.ResumptionToken.CompleteListSize != 3 {
+		t.Fatalf("Unexpected first page: %+v", list)
+	}
+	if list.Headers[0].Identifier != "oai:doi.example.org:"+dois[0] || list.Headers[0].Datestamp != "2020-01-10T12:00:00Z" {
+		t.Fatalf("Unexpected header: %+v", list.Headers[0])
+	}
+	resp = request(url.Values{"verb": {"ListIdentifiers"}, "resumptionToken": {list.ResumptionToken.Token}})
+	list = resp.ListIdentifiers
+	if list == nil || len(list.Headers) != 1 || list.Headers[0].Identifier != "oai:doi.example.org:"+dois[2] {
+		t.Fatalf("Unexpected last page: %+v", list)
+	}
+	if list.ResumptionToken == nil || list.ResumptionToken.Token != "" || list.ResumptionToken.Cursor != 2 {
+		t.Fatalf("Unexpected resumption token on last page: %+v", list.ResumptionToken)
+	}
+	// Tokens are bound to the verb
+	expectError(request(url.Values{"verb": {"ListRecords"}, "resumptionToken": {resp.Request.ResumptionToken}}), oaiBadResumptionToken)
+
+	// Selective harvesting
+	resp = request(url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"oai_dc"}, "from": {"2020-02-10"}, "until": {"2020-02-10"}})
+	if resp.ListRecords == nil || len(resp.ListRecords.Records) != 1 || resp.ListRecords.ResumptionToken != nil {
+		t.Fatalf("Unexpected day range response: %+v", resp.ListRecords)
+	}
+	dc := string(resp.ListRecords.Records[0].Metadata.Content)
+	if !strings.Contains(dc, "<dc:title>Dataset "+dois[1]+"</dc:title>") || !strings.Contains(dc, "<dc:identifier>https://doi.org/"+dois[1]+"</dc:identifier>") {
+		t.Fatalf("Unexpected oai_dc metadata: %s", dc)
+	}
+	resp = request(url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}, "from": {"2020-02-10T12:00:01Z"}})
+	if resp.ListIdentifiers == nil || len(resp.ListIdentifiers.Headers) != 1 {
+		t.Fatalf("Unexpected from response: %+v", resp.ListIdentifiers)
+	}
+	expectError(request(url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}, "from": {"2021-01-01"}}), oaiNoRecordsMatch)
+	expectError(request(url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}, "from": {"2020-01-01"}, "until": {"2020-03-01T00:00:00Z"}}), oaiBadArgument)
+	expectError(request(url.Values{"verb": {"ListIdentifiers"}, "metadataPrefix": {"oai_dc"}, "from": {"01/01/2020"}}), oaiBadArgument)
+
+	// Single records
+	resp = request(url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"oai_datacite"}, "identifier": {"oai:doi.example.org:" + dois[0]}})
+	if resp.GetRecord == nil {
+		t.Fatalf("Missing record: %+v", resp.Errors)
+	}
+	datacite := string(resp.GetRecord.Record.Metadata.Content)
+	if !strings.Contains(datacite, "<payload><resource") || strings.Contains(datacite, "<?xml") || !strings.Contains(datacite, dois[0]) {
+		t.Fatalf("Unexpected oai_datacite metadata: %s", datacite)
+	}
+	expectError(request(url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"oai_dc"}, "identifier": {"oai:doi.example.org:" + dois[3]}}), oaiIDDoesNotExist)
+	expectError(request(url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"marc"}, "identifier": {"oai:doi.example.org:" + dois[0]}}), oaiCannotDisseminateFormat)
+
+	// Withdrawn datasets are deleted records
+	markerpath := filepath.Join(tmpDir, filepath.FromSlash(dois[1]), withdrawalfname)
+	if err := ioutil.WriteFile(markerpath, []byte("{}"), 0666); err != nil {
+		t.Fatalf("Error writing withdrawal marker: %v", err)
+	}
+	withdrawn := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(markerpath, withdrawn, withdrawn); err != nil {
+		t.Fatalf("Error setting modification time: %v", err)
+	}
+	provider.items = nil
+	resp = request(url.Values{"verb": {"Identify"}})
+	if resp.Identify == nil || resp.Identify.DeletedRecord != "persistent" {
+		t.Fatalf("Unexpected Identify response: %+v", resp.Identify)
+	}
+	resp = request(url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"oai_dc"}, "identifier": {"oai:doi.example.org:" + dois[1]}})
+	if resp.GetRecord == nil || resp.GetRecord.Record.Header.Status != "deleted" || resp.GetRecord.Record.Metadata != nil || resp.GetRecord.Record.Header.Datestamp != "2020-05-01T12:00:00Z" {
+		t.Fatalf("Unexpected record of withdrawn dataset: %+v", resp.GetRecord)
+	}
+	resp = request(url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"oai_dc"}, "from": {"2020-04-01"}})
+	if resp.ListRecords == nil || len(resp.ListRecords.Records) != 1 || resp.ListRecords.Records[0].Header.Status != "deleted" {
+		t.Fatalf("Withdrawal not harvested incrementally: %+v", resp.ListRecords)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clientRatePeriod is the period of the rate limit per client address.
+	clientRatePeriod = time.Minute
+	// userRatePeriod is the period of the rate limit of submissions per GIN
+	// user.
+	userRatePeriod = time.Hour
+	// busyRetryAfter is the time after which a client may submit a request
+	// again that was turned away because the job queue was full.
+	busyRetryAfter = 5 * time.Minute
+	// maxRateBuckets is the number of tracked clients above which the
+	// clients that have not been limited for a full period are forgotten.
+	maxRateBuckets = 10000
+)
+
+// privateNetworks are the address ranges of the reverse proxies whose
+// X-Forwarded-For header is trusted to determine the client address.
+var privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for idx, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[idx] = network
+	}
+	return networks
+}
+
+// rateBucket holds the tokens left for a single client.
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter limits the number of events per key, e.g., a client address or
+// a user name, within a period. Each key has a bucket that holds up to limit
+// tokens and is refilled at a rate of limit tokens per period; each event
+// takes a token. The methods may be called on a nil rateLimiter, in which case
+// nothing is limited.
+type rateLimiter struct {
+	mutex   sync.Mutex
+	period  time.Duration
+	buckets map[string]*rateBucket
+}
+
+// newRateLimiter returns a rateLimiter for the given period.
+func newRateLimiter(period time.Duration) *rateLimiter {
+	return &rateLimiter{period: period, buckets: make(map[string]*rateBucket)}
+}
+
+// allow takes a token from the bucket of the key and returns false if the
+// bucket is empty. A limit below 1 disables the limit.
+func (l *rateLimiter) allow(key string, limit int, now time.Time) bool {
+	if l == nil || limit < 1 {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.buckets) >= maxRateBuckets {
+		// Buckets that have been refilled completely are the same as new ones
+		for k, b := range l.buckets {
+			if now.Sub(b.updated) >= l.period {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	max := float64(limit)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: max, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.updated).Seconds() / l.period.Seconds() * max
+	if b.tokens > max {
+		b.tokens = max
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clientIP returns the address of the client of a request. The last address of
+// the X-Forwarded-For header is used if the request comes from a reverse
+// proxy on a loopback or private address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isProxyAddress(ip) {
+		return host
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if last := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(last) != nil {
+		return last
+	}
+	return host
+}
+
+// isProxyAddress returns true if the address is a loopback or private address.
+func isProxyAddress(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitClients wraps a handler of the registration pages with the rate limit
+// per client address. Requests above the limit are answered with status 429
+// and a page asking the user to try again later.
+func limitClients(conf *Configuration, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientIP(r)
+		if !conf.clientLimiter.allow(client, conf.RateLimit, time.Now()) {
+			log.Printf("Rate limit exceeded by %s for %s", client, r.URL.Path)
+			conf.Metrics.countThrottled(throttleClientRate)
+			renderBusy(w, conf, http.StatusTooManyRequests, clientRatePeriod, msgTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkUserLimits checks the rate limit of submissions and the number of open
+// registrations of a GIN user. It returns the message shown to the user and
+// the time after which the user may try again if a limit is exceeded, and an
+// empty message otherwise. Open registrations that cannot be counted are not
+// limited.
+func checkUserLimits(conf *Configuration, username string, now time.Time) (string, time.Duration) {
+	if !conf.userLimiter.allow(strings.ToLower(username), conf.UserRateLimit, now) {
+		log.Printf("Submission rate limit exceeded by user %s", username)
+		conf.Metrics.countThrottled(throttleUserRate)
+		return msgTooManySubmitted, userRatePeriod
+	}
+	if conf.MaxOpenJobs < 1 {
+		return "", 0
+	}
+	open, err := conf.Jobs.countOpen(username)
+	if err != nil {
+		log.Printf("Failed to count open registrations of user %s: %s", username, err.Error())
+		return "", 0
+	}
+	if open >= conf.MaxOpenJobs {
+		log.Printf("User %s has %d open registrations", username, open)
+		conf.Metrics.countThrottled(throttleOpenJobs)
+		return fmt.Sprintf(msgTooManyOpen, open), userRatePeriod
+	}
+	return "", 0
+}
+
+// queueFull returns true if the number of jobs in the job queue and of the
+// jobs taken from the queue by the dispatcher that are waiting for a free
+// worker has reached the configured maximum queue length.
+func queueFull(conf *Configuration, jobQueue chan *RegistrationJob, dispatcher *Dispatcher) bool {
+	return len(jobQueue)+dispatcher.waiting() >= conf.MaxQueue
+}
+
+// renderBusy renders the result page with the given status code and message
+// and asks the client to retry after the given time.
+func renderBusy(w http.ResponseWriter, conf *Configuration, code int, retry time.Duration, message string) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retry.Seconds())))
+	w.WriteHeader(code)
+	renderResult(w, &reqResultData{Level: "warning", Message: template.HTML(message)}, conf)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(time.Minute)
+	now := time.Now()
+	for idx := 0; idx < 3; idx++ {
+		if !limiter.allow("client", 3, now) {
+			t.Fatalf("Request %d within the limit rejected", idx)
+		}
+	}
+	if limiter.allow("client", 3, now) {
+		t.Error("Request above the limit accepted")
+	}
+	if !limiter.allow("other", 3, now) {
+		t.Error("Request of another client rejected")
+	}
+	// One token is refilled every 20 seconds
+	if limiter.allow("client", 3, now.Add(10*time.Second)) {
+		t.Error("Request accepted before a token was refilled")
+	}
+	if !limiter.allow("client", 3, now.Add(30*time.Second)) {
+		t.Error("Request rejected after a token was refilled")
+	}
+	// Disabled limits and nil limiters allow everything
+	if !limiter.allow("client", 0, now) {
+		t.Error("Request rejected with disabled limit")
+	}
+	var nolimiter *rateLimiter
+	if !nolimiter.allow("client", 1, now) {
+		t.Error("Request rejected by nil limiter")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for _, test := range []struct {
+		remote    string
+		forwarded string
+		expected  string
+	}{
+		{"203.0.113.7:5123", "", "203.0.113.7"},
+		// Forwarded addresses are only trusted from a reverse proxy
+		{"203.0.113.7:5123", "198.51.100.1", "203.0.113.7"},
+		{"127.0.0.1:5123", "198.51.100.1", "198.51.100.1"},
+		{"172.17.0.2:5123", "10.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"[::1]:5123", "2001:db8::1", "2001:db8::1"},
+		{"10.0.0.5:5123", "", "10.0.0.5"},
+		{"10.0.0.5:5123", "unknown", "10.0.0.5"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/register", nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if ip := clientIP(r); ip != test.expected {
+			t.Errorf("Unexpected client address for %s (%q): %s (expected %s)", test.remote, test.forwarded, ip, test.expected)
+		}
+	}
+}
+
+func TestCheckUserLimits(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_ratelimit")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	for idx, state := range []JobState{jobCloning, jobDone, jobFailed, jobReleased} {
+		job := newTestJob("10.12751/g-node.aaaaa"+string(rune('a'+idx)), "alice/data")
+		job.Metadata.RequestingUser = &libgin.GINUser{Username: "Alice"}
+		if err := store.add(job); err != nil {
+			t.Fatalf("Error adding job: %v", err)
+		}
+		store.setState(job.Metadata.Identifier.ID, state)
+	}
+	if open, err := store.countOpen("alice"); err != nil || open != 2 {
+		t.Fatalf("Unexpected number of open registrations: %d (%v)", open, err)
+	}
+
+	conf := &Configuration{
+		Jobs:        store,
+		MaxOpenJobs: 2,
+		userLimiter: newRateLimiter(userRatePeriod),
+	}
+	now := time.Now()
+	if message, _ := checkUserLimits(conf, "alice", now); message == "" {
+		t.Error("User with too many open registrations not limited")
+	}
+	if message, _ := checkUserLimits(conf, "bob", now); message != "" {
+		t.Errorf("User without open registrations limited: %s", message)
+	}
+
+	conf.MaxOpenJobs = 0
+	conf.UserRateLimit = 1
+	if message, _ := checkUserLimits(conf, "alice", now); message != "" {
+		t.Errorf("First submission limited: %s", message)
+	}
+	if message, retry := checkUserLimits(conf, "ALICE", now); message != msgTooManySubmitted || retry != userRatePeriod {
+		t.Errorf("Submission above the rate limit not limited: %q, %s", message, retry)
+	}
+}
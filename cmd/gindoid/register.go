@@ -2,12 +2,189 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/G-Node/libgin/libgin"
 	"github.com/spf13/cobra"
 )
 
+// register runs the full registration of a repository synchronously without
+// going through the GIN web request flow. It loads the service configuration,
+// validates the repository metadata, reserves a DOI (unless one is provided
+// with the --doi flag) and creates the registered dataset.
 func register(cmd *cobra.Command, args []string) {
-	reponame := args[0]
+	repopath := args[0]
+	doi, _ := cmd.Flags().GetString("doi")
+	revision, _ := cmd.Flags().GetString("revision")
+	nonotify, _ := cmd.Flags().GetBool("no-notify")
+	embargo, _ := cmd.Flags().GetString("embargo")
 
-	fmt.Printf("Registering %q\n", reponame)
+	conf, err := loadconfig()
+	if err != nil {
+		fmt.Printf("Failed to load configuration: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := checkRegisterArgs(repopath, doi, revision, conf.DOIBase); err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+	if embargo != "" {
+		if _, err := parseEmbargo(embargo); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+	if nonotify {
+		// Without a mail server and XML repository the notifications are only
+		// written to the log
+		conf.Email.Server = ""
+		conf.XMLRepo = ""
+	}
+
+	fmt.Printf("Logging in to GIN (%s) as %s\n", conf.GIN.Session.WebAddress(), conf.GIN.Username)
+	if err := conf.GIN.Session.Login(conf.GIN.Username, conf.GIN.Password, "gin-doi"); err != nil {
+		fmt.Printf("Login failed: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer conf.GIN.Session.Logout()
+
+	fmt.Printf("Validating %s\n", repopath)
+	repoMetadata, yamlEmbargo, err := readAndValidate(conf, repopath, revision)
+	if err != nil {
+		fmt.Printf("Repository metadata is not valid:\n%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if doi == "" {
+		doi, err = reserveDOI(conf)
+		if err != nil {
+			fmt.Printf("Failed to reserve DOI: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Reserved DOI %s\n", doi)
+	}
+
+	job := &RegistrationJob{
+		Metadata:    new(libgin.RepositoryMetadata),
+		Config:      conf,
+		Revision:    revision,
+		Interactive: true,
+	}
+	owner := strings.SplitN(repopath, "/", 2)[0]
+	requser := &libgin.GINUser{Username: owner}
+	if account, err := conf.GIN.Session.RequestAccount(owner); err == nil {
+		requser.RealName = account.FullName
+		requser.Email = account.Email
+	} else {
+		fmt.Printf("WARNING: Failed to get data for user %s: %s\n", owner, err.Error())
+	}
+	job.Metadata.RequestingUser = requser
+	job.Metadata.SourceRepository = repopath
+	job.Metadata.ForkRepository = path.Join("doi", strings.SplitN(repopath, "/", 2)[1])
+	job.Metadata.YAMLData = repoMetadata
+	job.Metadata.DataCite = libgin.NewDataCiteFromYAML(repoMetadata)
+	setEmbargo(job.Metadata, yamlEmbargo, embargo)
+	job.Metadata.Identifier.ID = doi
+	job.Metadata.Identifier.Type = "DOI"
+
+	if conf.DataCite != nil {
+		if err := conf.DataCite.CreateDraft(doi); err != nil {
+			fmt.Printf("WARNING: Failed to create draft DOI at DataCite: %s\n", err.Error())
+		}
+	}
+
+	if err := conf.Jobs.add(job); err != nil {
+		fmt.Printf("WARNING: Failed to store job: %s\n", err.Error())
+	}
+
+	// Mark the job as failed if the command is interrupted; the service does
+	// not resume jobs of the register command
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigchan
+		fmt.Printf("Registration of %s interrupted (%s)\n", repopath, sig)
+		conf.Jobs.finish(doi, jobFailed, []string{fmt.Sprintf("Registration interrupted (%s)", sig)}, nil)
+		os.Exit(1)
+	}()
+
+	// Initial notification with the full request info as for web requests;
+	// with --no-notify the notifications are only written to the log
+	if err := notifyAdmin(job, nil, nil, true); err != nil {
+		fmt.Printf("WARNING: %s\n", err.Error())
+	}
+	if job.Metadata.RequestingUser.Email != "" {
+		if err := notifyUser(job); err != nil {
+			fmt.Printf("WARNING: Failed to send user notification email: %s\n", err.Error())
+		}
+	}
+
+	fmt.Printf("Registering %s as %s\n", repopath, doi)
+	done := make(chan bool)
+	go printProgress(conf.Jobs, doi, done)
+	err = createRegisteredDataset(job)
+	close(done)
+	signal.Stop(sigchan)
+
+	rec, recerr := conf.Jobs.get(doi)
+	if recerr != nil {
+		fmt.Printf("Failed to read job record: %s\n", recerr.Error())
+		os.Exit(1)
+	}
+	for idx, msg := range rec.Errors {
+		fmt.Printf("Error %d: %s\n", idx+1, msg)
+	}
+	for idx, msg := range rec.Warnings {
+		fmt.Printf("Warning %d: %s\n", idx+1, msg)
+	}
+	if err != nil || rec.State != jobDone {
+		fmt.Printf("Registration of %s failed\n", repopath)
+		os.Exit(1)
+	}
+	fmt.Printf("Registration of %s as %s completed\n", repopath, doi)
+}
+
+// checkRegisterArgs validates the arguments of the register command. The
+// repository must be given as "owner/repository", and a DOI, if specified,
+// must consist of the configured prefix followed by an alphanumeric suffix,
+// like the DOIs created by reserveDOI, since it is used as a directory name
+// in the preparation and storage locations.
+func checkRegisterArgs(repopath, doi, revision, doibase string) error {
+	if parts := strings.SplitN(repopath, "/", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(parts[1], "/") {
+		return fmt.Errorf("invalid repository path %q: expected <owner>/<repository>", repopath)
+	}
+	if doi != "" && !isValidDOI(doi, doibase) {
+		return fmt.Errorf("invalid DOI %q: expected the configured prefix %q followed by a lowercase alphanumeric suffix", doi, doibase)
+	}
+	if revision != "" && !isValidRevision(revision) {
+		return fmt.Errorf("invalid revision %q: expected a commit hash or tag name", revision)
+	}
+	return nil
+}
+
+// printProgress prints the processing stages of the job with the given ID as
+// they are started, until the done channel is closed.
+func printProgress(jobs *JobStore, id string, done chan bool) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	nstages := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			rec, err := jobs.get(id)
+			if err != nil {
+				continue
+			}
+			for ; nstages < len(rec.Stages); nstages++ {
+				fmt.Printf(" -> %s\n", rec.Stages[nstages].Name)
+			}
+		}
+	}
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckRegisterArgs(t *testing.T) {
+	doibase := "10.12751/g-node."
+
+	valid := []struct {
+		repo     string
+		doi      string
+		revision string
+	}{
+		{"owner/repo", "", ""},
+		{"owner/repo", "10.12751/g-node.a1b2c3", ""},
+		{"owner/repo", "", "v1.0"},
+		{"owner/repo", "10.12751/g-node.abcdef", "0123456789abcdef0123456789abcdef01234567"},
+	}
+	for _, args := range valid {
+		if err := checkRegisterArgs(args.repo, args.doi, args.revision, doibase); err != nil {
+			t.Fatalf("Valid arguments %+v rejected: %s", args, err.Error())
+		}
+	}
+
+	invalidRepos := []string{"", "repo", "owner/", "/repo", "owner/repo/sub"}
+	for _, repo := range invalidRepos {
+		if err := checkRegisterArgs(repo, "", "", doibase); err == nil {
+			t.Fatalf("Invalid repository path %q accepted", repo)
+		}
+	}
+
+	invalidDOIs := []string{
+		"10.12751/g-node.",
+		"10.12751/g-node.ABCDEF",
+		"10.12751/g-node.abc/../../etc",
+		"10.12751/g-node.abc/def",
+		"10.12751/g-node.../abc",
+		"10.99999/other.abcdef",
+		"abcdef",
+	}
+	for _, doi := range invalidDOIs {
+		if err := checkRegisterArgs("owner/repo", doi, "", doibase); err == nil {
+			t.Fatalf("Invalid DOI %q accepted", doi)
+		}
+	}
+	// A DOI cannot be checked without a configured prefix
+	if err := checkRegisterArgs("owner/repo", "10.12751/g-node.abcdef", "", ""); err == nil {
+		t.Fatal("DOI accepted without a configured prefix")
+	}
+
+	if err := checkRegisterArgs("owner/repo", "", "--upload-pack=x", doibase); err == nil {
+		t.Fatal("Invalid revision accepted")
+	}
+}
+
+func TestReserveDOI(t *testing.T) {
+	origcheck := isRegisteredDOI
+	defer func() { isRegisteredDOI = origcheck }()
+
+	conf := &Configuration{DOIBase: "10.12751/g-node."}
+
+	// No DOI is registered; the first DOI is used
+	ncalls := 0
+	isRegisteredDOI = func(doi string) bool {
+		ncalls++
+		return false
+	}
+	doi, err := reserveDOI(conf)
+	if err != nil {
+		t.Fatalf("Failed to reserve DOI: %s", err.Error())
+	}
+	if ncalls != 1 {
+		t.Fatalf("Unexpected number of registry checks: %d", ncalls)
+	}
+	if !strings.HasPrefix(doi, conf.DOIBase) || len(doi) != len(conf.DOIBase)+6 {
+		t.Fatalf("Unexpected DOI format: %q", doi)
+	}
+	if !isValidDOI(doi, conf.DOIBase) {
+		t.Fatalf("Reserved DOI %q is not valid", doi)
+	}
+
+	// First two DOIs are taken
+	ncalls = 0
+	isRegisteredDOI = func(doi string) bool {
+		ncalls++
+		return ncalls <= 2
+	}
+	if _, err := reserveDOI(conf); err != nil {
+		t.Fatalf("Failed to reserve DOI: %s", err.Error())
+	}
+	if ncalls != 3 {
+		t.Fatalf("Unexpected number of registry checks: %d", ncalls)
+	}
+
+	// All DOIs are taken
+	isRegisteredDOI = func(doi string) bool {
+		return true
+	}
+	if _, err := reserveDOI(conf); err == nil {
+		t.Fatal("Reserving a DOI did not fail when all DOIs are taken")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// liveConfig holds the configuration of the running service. Reloading
+// replaces the configuration with an updated copy instead of modifying it, so
+// that requests and registration jobs keep using the configuration they
+// started with.
+type liveConfig struct {
+	mutex sync.RWMutex
+	conf  *Configuration
+	// reloading serialises reloads
+	reloading sync.Mutex
+}
+
+// newLiveConfig returns a liveConfig holding the given configuration.
+func newLiveConfig(conf *Configuration) *liveConfig {
+	return &liveConfig{conf: conf}
+}
+
+// get returns the current configuration.
+func (lc *liveConfig) get() *Configuration {
+	lc.mutex.RLock()
+	defer lc.mutex.RUnlock()
+	return lc.conf
+}
+
+// handler returns a handler that serves each request with the handler created
+// by newHandler for the current configuration. The handler is created again
+// after the configuration has been reloaded.
+func (lc *liveConfig) handler(newHandler func(*Configuration) http.Handler) http.Handler {
+	var mutex sync.Mutex
+	var conf *Configuration
+	var handler http.Handler
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := lc.get()
+		mutex.Lock()
+		if current != conf {
+			conf, handler = current, newHandler(current)
+		}
+		h := handler
+		mutex.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// reload reads the configuration, the custom licenses, and the curated
+// keywords again and applies the changes to the running service; the worker
+// pool of the dispatcher is resized if the number of workers changed. An
+// invalid configuration is not applied. It returns a description of each
+// change, which is also logged.
+func (lc *liveConfig) reload(dispatcher *Dispatcher) ([]string, error) {
+	lc.reloading.Lock()
+	defer lc.reloading.Unlock()
+
+	old := lc.get()
+	updated, changes, err := reloadConfig(old)
+	if err != nil {
+		log.Printf("Failed to reload the configuration: %s", err.Error())
+		return nil, err
+	}
+	lc.mutex.Lock()
+	lc.conf = updated
+	lc.mutex.Unlock()
+	if updated.MaxWorkers != old.MaxWorkers {
+		dispatcher.resize(updated.MaxWorkers)
+	}
+
+	// The custom licenses are read on each validation; reading them here
+	// reports a broken file right away
+	changes = append(changes, fmt.Sprintf("licenses: %d licenses available", len(ReadCommonLicenses())))
+	changes = append(changes, fmt.Sprintf("keywords: %d curated keywords loaded", reloadCuratedKeywords()))
+	for _, change := range changes {
+		log.Printf("Configuration reload: %s", change)
+	}
+	return changes, nil
+}
+
+// reloadConfig reads the configuration variables again and returns a copy of
+// the given configuration with the new values applied. Settings that only take
+// effect after a restart keep their previous values. It returns a description
+// of each changed setting; the values of secrets are not included.
+func reloadConfig(old *Configuration) (*Configuration, []string, error) {
+	values, err := readConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	var changes []string
+	for _, setting := range configSettings {
+		oldvalue, newvalue := old.settings[setting.Name], values[setting.Name]
+		if oldvalue.Value == newvalue.Value {
+			continue
+		}
+		change := fmt.Sprintf("%s: %q -> %q", setting.Name, oldvalue.Value, newvalue.Value)
+		if setting.Secret {
+			change = fmt.Sprintf("%s: changed", setting.Name)
+		}
+		if setting.Restart {
+			change += " (takes effect after a restart)"
+			values[setting.Name] = oldvalue
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		changes = append(changes, "configuration: no changes")
+	}
+
+	updated := *old
+	applySettings(&updated, values)
+	updated.settings = values
+	return &updated, changes, nil
+}
+
+// reloadResult is the response of the reload endpoint.
+type reloadResult struct {
+	Changes []string `json:",omitempty"`
+	Error   string   `json:",omitempty"`
+}
+
+// serveReload returns the handler of the admin endpoint that reloads the
+// configuration. The changes are reported as JSON; the status code is 422 if
+// the new configuration is invalid and has not been applied.
+func serveReload(live *liveConfig, dispatcher *Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Print("Reloading the configuration on admin request")
+		result := reloadResult{}
+		code := http.StatusOK
+		changes, err := live.reload(dispatcher)
+		if err != nil {
+			result.Error = err.Error()
+			code = http.StatusUnprocessableEntity
+		}
+		result.Changes = changes
+		data, _ := json.MarshalIndent(result, "", "  ")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(data)
+	}
+}
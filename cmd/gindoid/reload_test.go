@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_reload")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	conffile := filepath.Join(tmpDir, "gindoid.yml")
+	confdata := fmt.Sprintf(`key: 0123456789abcdef
+ginurl: https://gin.g-node.org:443
+giturl: git@gin.g-node.org:22
+ginuser: doi
+ginpassword: password
+doibase: 10.12751/g-node.
+preparation: %s
+target: %s
+storeurl: https://doi.gin.g-node.org
+maxworkers: 2
+`, tmpDir, tmpDir)
+	if err := ioutil.WriteFile(conffile, []byte(confdata), 0600); err != nil {
+		t.Fatalf("Error writing configuration file: %v", err)
+	}
+	defer setTestEnv(map[string]string{"configfile": conffile, "configdir": tmpDir})()
+
+	values, err := readConfig()
+	if err != nil {
+		t.Fatalf("Error reading configuration: %v", err)
+	}
+	conf := &Configuration{Port: 10443, settings: values}
+	applySettings(conf, values)
+	live := newLiveConfig(conf)
+
+	jobQueue := make(chan *RegistrationJob, 1)
+	dispatcher := newDispatcher(jobQueue, conf.MaxWorkers)
+	dispatcher.run(newWorker)
+	defer dispatcher.stop(time.Second)
+
+	// Handlers are created again for the new configuration
+	created := 0
+	handler := live.handler(func(conf *Configuration) http.Handler {
+		created++
+		return http.RedirectHandler(conf.Storage.StoreURL, http.StatusFound)
+	})
+	get := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Header().Get("Location")
+	}
+	get()
+	if location := get(); location != "https://doi.gin.g-node.org" || created != 1 {
+		t.Fatalf("Unexpected handler: %s (created %d times)", location, created)
+	}
+
+	// Reload with changed settings; the port and password only change after
+	// a restart
+	newdata := strings.Replace(confdata, "maxworkers: 2", "maxworkers: 4", 1)
+	newdata = strings.Replace(newdata, "ginpassword: password", "ginpassword: newpassword", 1)
+	newdata += "storeurl: https://doi.example.org\nport: 8443\nadmintoken: secret-token\n"
+	newdata = strings.Replace(newdata, "storeurl: https://doi.gin.g-node.org\n", "", 1)
+	if err := ioutil.WriteFile(conffile, []byte(newdata), 0600); err != nil {
+		t.Fatalf("Error writing configuration file: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	serveReload(live, dispatcher)(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected reload status: [%d] %s", rec.Code, rec.Body.String())
+	}
+	result := reloadResult{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Error parsing reload result: %v", err)
+	}
+	changes := strings.Join(result.Changes, "\n")
+	for _, expected := range []string{
+		`port: "10443" -> "8443" (takes effect after a restart)`,
+		`maxworkers: "2" -> "4"`,
+		`storeurl: "https://doi.gin.g-node.org" -> "https://doi.example.org"`,
+		"ginpassword: changed (takes effect after a restart)",
+		"admintoken: changed\n",
+		"keywords: ",
+	} {
+		if !strings.Contains(changes, expected) {
+			t.Errorf("Change %q not reported:\n%s", expected, changes)
+		}
+	}
+	if strings.Contains(changes, "secret-token") || strings.Contains(changes, "newpassword") {
+		t.Errorf("Secret values reported:\n%s", changes)
+	}
+
+	updated := live.get()
+	if updated == conf || conf.Storage.StoreURL != "https://doi.gin.g-node.org" {
+		t.Error("Configuration in use was modified")
+	}
+	if updated.Storage.StoreURL != "https://doi.example.org" || updated.AdminToken != "secret-token" || updated.MaxWorkers != 4 {
+		t.Errorf("Changes not applied: %+v", updated)
+	}
+	if updated.Port != 10443 || updated.settings.get("port") != "10443" || updated.settings.get("ginpassword") != "password" {
+		t.Errorf("Restart setting changed: %d %+v", updated.Port, updated.settings)
+	}
+	if size := dispatcher.size(); size != 4 {
+		t.Errorf("Worker pool not resized: %d", size)
+	}
+	if location := get(); location != "https://doi.example.org" || created != 2 {
+		t.Errorf("Handler not updated: %s (created %d times)", location, created)
+	}
+
+	// An invalid configuration is not applied
+	if err := ioutil.WriteFile(conffile, []byte(newdata+"maxqueue: none\n"), 0600); err != nil {
+		t.Fatalf("Error writing configuration file: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	serveReload(live, dispatcher)(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "maxqueue") {
+		t.Errorf("Unexpected reload of invalid configuration: [%d] %s", rec.Code, rec.Body.String())
+	}
+	if live.get() != updated {
+		t.Error("Invalid configuration applied")
+	}
+}
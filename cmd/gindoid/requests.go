@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// usedRequestsFile is the name of the file in the preparation directory that
+// keeps the nonces of the submitted registration requests.
+const usedRequestsFile = "usedrequests.json"
+
+const (
+	// maxClockSkew is the time by which the issue time of a request may lie
+	// in the future to allow for clocks of GIN and the DOI service that are
+	// slightly out of sync.
+	maxClockSkew = 5 * time.Minute
+	// csrfCookieName is the name of the cookie that binds the submission of a
+	// registration request to the browser the request page was shown in.
+	csrfCookieName = "gindoi_csrf"
+)
+
+var (
+	errRequestExpired = errors.New("request has expired")
+	errRequestUsed    = errors.New("request has already been submitted")
+)
+
+// RequestStore keeps track of the registration requests that have been
+// submitted, so that the encrypted request data cannot be used for another
+// submission. A request is remembered until it expires, after which it is
+// rejected anyway. The store is saved to a file to survive restarts.
+type RequestStore struct {
+	mutex sync.Mutex
+	path  string
+	// Expiry time of the used requests by nonce
+	used map[string]time.Time
+}
+
+// newRequestStore returns a RequestStore that is saved to the given file. The
+// nonces of a previous run are read from the file if it exists.
+func newRequestStore(path string) (*RequestStore, error) {
+	store := &RequestStore{path: path, used: make(map[string]time.Time)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read used requests: %s", err.Error())
+	}
+	if err := json.Unmarshal(data, &store.used); err != nil {
+		return nil, fmt.Errorf("failed to parse used requests %q: %s", path, err.Error())
+	}
+	return store, nil
+}
+
+// isUsed returns true if the request with the given nonce has been submitted.
+func (s *RequestStore) isUsed(nonce string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.used[nonce]
+	return ok
+}
+
+// use marks the request with the given nonce as submitted until the given
+// expiry time. It returns errRequestUsed if the request has been submitted
+// before. Expired entries are removed.
+func (s *RequestStore) use(nonce string, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.used[nonce]; ok {
+		return errRequestUsed
+	}
+	now := time.Now()
+	for used, expiry := range s.used {
+		if expiry.Before(now) {
+			delete(s.used, used)
+		}
+	}
+	s.used[nonce] = expires
+	return s.save()
+}
+
+// release forgets the request with the given nonce, so that it can be
+// submitted again; used if a submission could not be started.
+func (s *RequestStore) release(nonce string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.used, nonce)
+	return s.save()
+}
+
+// save writes the used requests to the file of the store. The caller must hold
+// the mutex.
+func (s *RequestStore) save() error {
+	data, err := json.MarshalIndent(s.used, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpfname := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpfname, data, 0644); err != nil {
+		return fmt.Errorf("failed to save used requests: %s", err.Error())
+	}
+	return os.Rename(tmpfname, s.path)
+}
+
+// checkRequest checks that the request data carry an issue time and a nonce,
+// that the request has not expired, and that it has not been submitted yet.
+func checkRequest(conf *Configuration, data *requestData, now time.Time) error {
+	if data.Nonce == "" || data.Issued.IsZero() {
+		return fmt.Errorf("invalid request: issue time or nonce missing")
+	}
+	if data.Issued.After(now.Add(maxClockSkew)) {
+		return fmt.Errorf("invalid request: issued in the future (%s)", data.Issued.Format(time.RFC3339))
+	}
+	if now.Sub(data.Issued) > conf.RequestMaxAge {
+		return errRequestExpired
+	}
+	if conf.Requests.isUsed(data.Nonce) {
+		return errRequestUsed
+	}
+	return nil
+}
+
+// requestMessage returns the message shown to the user for a request that
+// failed the checks of checkRequest.
+func requestMessage(err error) string {
+	switch err {
+	case errRequestExpired:
+		return msgRequestExpired
+	case errRequestUsed:
+		return msgRequestUsed
+	}
+	return msgInvalidRequest
+}
+
+// csrfCookie returns the value of the CSRF cookie of the request, and a new
+// random value if the request has no valid cookie. The cookie is set on the
+// response, so that it is sent with the submission of the request page.
+func csrfCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value, nil
+	}
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	cookie := &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    hex.EncodeToString(value),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, cookie)
+	return cookie.Value, nil
+}
+
+// csrfToken returns the token of the submission form of the request page. It
+// binds the submission to the request with the given nonce and to the
+// browser that holds the CSRF cookie.
+func csrfToken(key, cookie, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(cookie))
+	mac.Write([]byte{0})
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkCSRFToken checks that the submitted form token matches the CSRF cookie
+// of the request and the nonce of the submitted request data.
+func checkCSRFToken(r *http.Request, key, nonce string) bool {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil {
+		return false
+	}
+	token := r.PostFormValue("csrftoken")
+	return hmac.Equal([]byte(token), []byte(csrfToken(key, cookie.Value, nonce)))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckRequest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_requests")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fname := filepath.Join(tmpDir, usedRequestsFile)
+	store, err := newRequestStore(fname)
+	if err != nil {
+		t.Fatalf("Error creating request store: %v", err)
+	}
+	conf := &Configuration{Requests: store, RequestMaxAge: 24 * time.Hour}
+
+	now := time.Now()
+	data := &requestData{Issued: now.Add(-time.Hour), Nonce: "nonce-one"}
+	if err := checkRequest(conf, data, now); err != nil {
+		t.Fatalf("Valid request rejected: %v", err)
+	}
+	for _, invalid := range []*requestData{
+		{Issued: now},
+		{Nonce: "nonce-two"},
+		{Issued: now.Add(time.Hour), Nonce: "nonce-two"},
+	} {
+		if err := checkRequest(conf, invalid, now); err == nil {
+			t.Errorf("Invalid request accepted: %+v", invalid)
+		}
+	}
+	if err := checkRequest(conf, &requestData{Issued: now.Add(-25 * time.Hour), Nonce: "nonce-two"}, now); err != errRequestExpired {
+		t.Errorf("Expired request not rejected: %v", err)
+	}
+
+	// A submitted request cannot be used again, also after a restart
+	if err := store.use("nonce-one", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Error marking request as used: %v", err)
+	}
+	if err := store.use("nonce-one", now.Add(time.Hour)); err != errRequestUsed {
+		t.Errorf("Request used twice: %v", err)
+	}
+	store, err = newRequestStore(fname)
+	if err != nil {
+		t.Fatalf("Error reading request store: %v", err)
+	}
+	conf.Requests = store
+	if err := checkRequest(conf, data, now); err != errRequestUsed {
+		t.Errorf("Used request not rejected after restart: %v", err)
+	}
+	if requestMessage(errRequestUsed) != msgRequestUsed || requestMessage(errRequestExpired) != msgRequestExpired {
+		t.Error("Unexpected messages for rejected requests")
+	}
+
+	// Expired entries are removed
+	store.used["nonce-old"] = now.Add(-time.Minute)
+	if err := store.use("nonce-three", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Error marking request as used: %v", err)
+	}
+	if store.isUsed("nonce-old") {
+		t.Error("Expired entry was not removed")
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef"
+
+	// The request page sets the cookie and embeds the token bound to it
+	rec := httptest.NewRecorder()
+	cookie, err := csrfCookie(rec, httptest.NewRequest(http.MethodGet, "/register", nil))
+	if err != nil {
+		t.Fatalf("Error creating CSRF cookie: %v", err)
+	}
+	setcookie := rec.Header().Get("Set-Cookie")
+	if !strings.Contains(setcookie, csrfCookieName+"="+cookie) || !strings.Contains(setcookie, "HttpOnly") || !strings.Contains(setcookie, "SameSite=Strict") {
+		t.Fatalf("Unexpected cookie: %s", setcookie)
+	}
+	token := csrfToken(key, cookie, "nonce-one")
+
+	submit := func(token string, cookie string) *http.Request {
+		form := url.Values{"reqdata": {"data"}, "csrftoken": {token}}
+		req := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookie})
+		}
+		return req
+	}
+	if !checkCSRFToken(submit(token, cookie), key, "nonce-one") {
+		t.Error("Valid form token rejected")
+	}
+	if checkCSRFToken(submit(token, ""), key, "nonce-one") {
+		t.Error("Submission without cookie accepted")
+	}
+	if checkCSRFToken(submit(token, strings.Repeat("0", 64)), key, "nonce-one") {
+		t.Error("Submission with other cookie accepted")
+	}
+	if checkCSRFToken(submit(token, cookie), key, "nonce-two") {
+		t.Error("Form token accepted for other request")
+	}
+
+	// An existing cookie is reused for further request pages
+	req := httptest.NewRequest(http.MethodGet, "/register", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookie})
+	rec = httptest.NewRecorder()
+	if reused, _ := csrfCookie(rec, req); reused != cookie || rec.Header().Get("Set-Cookie") != "" {
+		t.Errorf("Cookie was not reused: %s", reused)
+	}
+}
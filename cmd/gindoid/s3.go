@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the size of the parts of multipart uploads. Files smaller
+	// than the part size are uploaded in a single request.
+	s3PartSize = 16 * 1024 * 1024
+	// emptySHA256 is the SHA-256 checksum of an empty request body.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config holds the connection settings for an S3-compatible object store.
+type S3Config struct {
+	// Endpoint URL of the object store (e.g., https://s3.example.org)
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	// SecretKey is excluded from JSON to keep it out of the logged
+	// configuration
+	SecretKey string `json:"-"`
+}
+
+// S3Storage stores files as objects in a bucket of an S3-compatible object
+// store. Objects are addressed path-style (<endpoint>/<bucket>/<name>) and
+// requests are authenticated with AWS Signature Version 4. New objects are
+// uploaded with a private ACL.
+type S3Storage struct {
+	S3Config
+	endpoint *url.URL
+	client   *http.Client
+	// partSize is the size of the parts of multipart uploads
+	partSize int
+	// now returns the current time; used for signing requests
+	now func() time.Time
+}
+
+// NewS3Storage returns an S3Storage for the given configuration.
+func NewS3Storage(conf S3Config) (*S3Storage, error) {
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		return nil, fmt.Errorf("S3 storage requires an endpoint and a bucket")
+	}
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %s", conf.Endpoint, err.Error())
+	}
+	return &S3Storage{S3Config: conf, endpoint: endpoint, client: &http.Client{}, partSize: s3PartSize, now: time.Now}, nil
+}
+
+// objectURL returns the URL of the object with the given name and query.
+func (s *S3Storage) objectURL(name string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = path.Join("/", u.Path, s.Bucket, name)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do signs and sends a request for the object with the given name and returns
+// the response. Responses with a status code other than 200 (or 204 for
+// deletions) are returned as an error.
+func (s *S3Storage) do(method string, name string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(name, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		checksum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(checksum[:])
+	}
+	signV4(req, s.AccessKey, s.SecretKey, s.Region, payloadHash, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s failed: %s: %s", method, name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// MkdirAll does nothing since object stores have no directories.
+func (s *S3Storage) MkdirAll(dir string) error {
+	return nil
+}
+
+// Create returns a writer that uploads the data to the object with the given
+// name. The data is buffered in memory up to the part size. Larger files are
+// uploaded in parts using a multipart upload.
+func (s *S3Storage) Create(name string) (io.WriteCloser, error) {
+	return &s3Writer{storage: s, name: name}, nil
+}
+
+// Open returns a reader for the content of the object with the given name.
+func (s *S3Storage) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, name, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Remove deletes the object with the given name.
+func (s *S3Storage) Remove(name string) error {
+	resp, err := s.do(http.MethodDelete, name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// SetPublic sets the ACL of all objects under the given directory prefix to
+// public-read or private.
+func (s *S3Storage) SetPublic(dir string, public bool) error {
+	acl := "private"
+	if public {
+		acl = "public-read"
+	}
+	files, err := s.List(dir)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"X-Amz-Acl": []string{acl}}
+	for _, file := range files {
+		resp, err := s.do(http.MethodPut, file.Name, url.Values{"acl": []string{""}}, header, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// allUsersGroup is the grantee URI of anonymous access in S3 ACLs.
+const allUsersGroup = "http://acs.amazonaws.com/groups/global/AllUsers"
+
+// IsPublic reports whether the doi.xml object of a dataset directory can be
+// read anonymously. All objects of a directory share the same ACL (see
+// SetPublic).
+func (s *S3Storage) IsPublic(dir string) (bool, error) {
+	type aclResult struct {
+		Grants []struct {
+			Grantee struct {
+				URI string
+			}
+			Permission string
+		} `xml:"AccessControlList>Grant"`
+	}
+
+	resp, err := s.do(http.MethodGet, path.Join(dir, "doi.xml"), url.Values{"acl": []string{""}}, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	result := aclResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to parse object ACL: %s", err.Error())
+	}
+	for _, grant := range result.Grants {
+		if grant.Grantee.URI == allUsersGroup && (grant.Permission == "READ" || grant.Permission == "FULL_CONTROL") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// List returns all objects under the given directory prefix.
+func (s *S3Storage) List(dir string) ([]StorageFile, error) {
+	type listResult struct {
+		Contents []struct {
+			Key          string
+			LastModified time.Time
+		}
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+
+	prefix := strings.TrimSuffix(dir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	files := make([]StorageFile, 0)
+	query := url.Values{"list-type": []string{"2"}, "prefix": []string{prefix}}
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := listResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse object list: %s", err.Error())
+		}
+		for _, obj := range result.Contents {
+			files = append(files, StorageFile{Name: obj.Key, ModTime: obj.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// s3Writer uploads the data written to it to an object.
+type s3Writer struct {
+	storage  *S3Storage
+	name     string
+	buf      bytes.Buffer
+	uploadID string
+	parts    []s3Part
+	closed   bool
+	err      error
+}
+
+// s3Part identifies an uploaded part of a multipart upload.
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, fmt.Errorf("write to closed object %q", w.name)
+	}
+	w.buf.Write(p)
+	for w.buf.Len() >= w.storage.partSize {
+		if w.err = w.uploadPart(w.buf.Next(w.storage.partSize)); w.err != nil {
+			w.abort()
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+// Close uploads the remaining data. If no part has been uploaded yet, the
+// data is uploaded with a single request, otherwise the multipart upload is
+// completed. Calling Close more than once has no further effect.
+func (w *s3Writer) Close() error {
+	if w.closed || w.err != nil {
+		return w.err
+	}
+	w.closed = true
+	w.err = w.finish()
+	return w.err
+}
+
+// Abort discards the written data and cancels a started multipart upload, so
+// that no object is stored. The writer cannot be used afterwards.
+func (w *s3Writer) Abort() error {
+	if w.closed && w.err == nil {
+		return fmt.Errorf("object %q has already been stored", w.name)
+	}
+	w.closed = true
+	if w.err == nil {
+		w.err = fmt.Errorf("upload of object %q aborted", w.name)
+	}
+	w.buf.Reset()
+	w.abort()
+	return nil
+}
+
+// finish uploads the buffered data and completes the upload.
+func (w *s3Writer) finish() error {
+	header := http.Header{"X-Amz-Acl": []string{"private"}}
+	if w.uploadID == "" {
+		resp, err := w.storage.do(http.MethodPut, w.name, nil, header, w.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			w.abort()
+			return err
+		}
+	}
+	type completeUpload struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}
+	body, err := xml.Marshal(completeUpload{Parts: w.parts})
+	if err != nil {
+		w.abort()
+		return err
+	}
+	resp, err := w.storage.do(http.MethodPost, w.name, url.Values{"uploadId": []string{w.uploadID}}, nil, body)
+	if err != nil {
+		w.abort()
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// uploadPart uploads the data as the next part of the multipart upload. The
+// multipart upload is started with the first part.
+func (w *s3Writer) uploadPart(data []byte) error {
+	if w.uploadID == "" {
+		header := http.Header{"X-Amz-Acl": []string{"private"}}
+		resp, err := w.storage.do(http.MethodPost, w.name, url.Values{"uploads": []string{""}}, header, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		result := struct {
+			UploadID string `xml:"UploadId"`
+		}{}
+		if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed to parse multipart upload response: %s", err.Error())
+		}
+		w.uploadID = result.UploadID
+	}
+
+	partnum := len(w.parts) + 1
+	query := url.Values{"partNumber": []string{fmt.Sprintf("%d", partnum)}, "uploadId": []string{w.uploadID}}
+	resp, err := w.storage.do(http.MethodPut, w.name, query, nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	w.parts = append(w.parts, s3Part{PartNumber: partnum, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+// abort cancels a started multipart upload so that the store can discard the
+// uploaded parts.
+func (w *s3Writer) abort() {
+	if w.uploadID == "" {
+		return
+	}
+	if resp, err := w.storage.do(http.MethodDelete, w.name, url.Values{"uploadId": []string{w.uploadID}}, nil, nil); err == nil {
+		resp.Body.Close()
+	}
+	w.uploadID = ""
+}
+
+// signV4 adds the AWS Signature Version 4 authorization header to a request.
+// All headers set on the request and the host are signed.
+func signV4(req *http.Request, accessKey, secretKey, region, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzdate := now.Format("20060102T150405Z")
+	datestamp := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	headernames := make([]string, 0, len(headers))
+	for key := range headers {
+		headernames = append(headernames, key)
+	}
+	sort.Strings(headernames)
+	var canonicalHeaders strings.Builder
+	for _, key := range headernames {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", key, headers[key])
+	}
+	signedHeaders := strings.Join(headernames, ";")
+
+	query := req.URL.Query()
+	querykeys := make([]string, 0, len(query))
+	for key := range query {
+		querykeys = append(querykeys, key)
+	}
+	sort.Strings(querykeys)
+	queryparts := make([]string, 0, len(querykeys))
+	for _, key := range querykeys {
+		for _, value := range query[key] {
+			queryparts = append(queryparts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+
+	canonicalPath := s3Escape(req.URL.Path, false)
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		strings.Join(queryparts, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", datestamp, region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzdate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), datestamp)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the given key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape URI-encodes a string as required for the canonical request of
+// Signature Version 4. All characters except the unreserved ones are encoded.
+// Slashes are kept if encodeSlash is false.
+func s3Escape(s string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			escaped.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Supported storage backends.
+const (
+	storageLocal = "local"
+	storageS3    = "s3"
+)
+
+// StorageBackend is the location where the published datasets (archive,
+// landing page, XML metadata, and manifest) are stored. All names are slash
+// separated paths relative to the root of the storage.
+type StorageBackend interface {
+	// MkdirAll creates a directory and all its parents if the backend has a
+	// notion of directories.
+	MkdirAll(dir string) error
+	// Create returns a writer for a new file with the given name. An existing
+	// file is overwritten. The file is complete only after the writer has
+	// been closed without error; a file that could not be written completely
+	// is discarded with abortFile instead of closing the writer.
+	Create(name string) (io.WriteCloser, error)
+	// Open returns a reader for the file with the given name.
+	Open(name string) (io.ReadCloser, error)
+	// Remove deletes the file with the given name.
+	Remove(name string) error
+	// SetPublic makes all files in a directory publicly accessible or
+	// restricts access to them. New files are not public until the
+	// directory is made public.
+	SetPublic(dir string, public bool) error
+	// IsPublic reports whether the files in a directory are publicly
+	// accessible.
+	IsPublic(dir string) (bool, error)
+	// List returns all files under a directory (recursively). An empty
+	// directory name lists the whole storage. The list of a directory that
+	// does not exist is empty.
+	List(dir string) ([]StorageFile, error)
+}
+
+// StorageFile describes a file in a storage backend.
+type StorageFile struct {
+	// Slash separated path relative to the root of the storage
+	Name    string
+	ModTime time.Time
+}
+
+// LocalStorage stores files in a directory of the local filesystem. Access
+// to a directory is denied via an .htaccess file for the web server that
+// serves the files.
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage returns a LocalStorage which stores all files under the
+// given root directory.
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+// path returns the local filesystem path of a storage file name.
+func (s *LocalStorage) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+// MkdirAll creates the directory and all its parents under the storage root.
+func (s *LocalStorage) MkdirAll(dir string) error {
+	return os.MkdirAll(s.path(dir), os.ModePerm)
+}
+
+// Create creates or truncates the file with the given name.
+func (s *LocalStorage) Create(name string) (io.WriteCloser, error) {
+	return os.Create(s.path(name))
+}
+
+// Open opens the file with the given name for reading.
+func (s *LocalStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+// Remove deletes the file with the given name.
+func (s *LocalStorage) Remove(name string) error {
+	return os.Remove(s.path(name))
+}
+
+// SetPublic removes the .htaccess file of a directory to make it public, or
+// creates one that denies all access.
+func (s *LocalStorage) SetPublic(dir string, public bool) error {
+	htaccess := s.path(filepath.Join(dir, ".htaccess"))
+	if public {
+		if err := os.Remove(htaccess); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	file, err := os.Create(htaccess)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write([]byte("deny from all"))
+	return err
+}
+
+// IsPublic reports whether a directory has no .htaccess file restricting the
+// access.
+func (s *LocalStorage) IsPublic(dir string) (bool, error) {
+	_, err := os.Stat(s.path(filepath.Join(dir, ".htaccess")))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// List walks the directory and returns all regular files.
+func (s *LocalStorage) List(dir string) ([]StorageFile, error) {
+	files := make([]StorageFile, 0)
+	if _, err := os.Stat(s.path(dir)); os.IsNotExist(err) {
+		return files, nil
+	}
+	root := s.path("")
+	err := filepath.Walk(s.path(dir), func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		files = append(files, StorageFile{Name: filepath.ToSlash(name), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// copyStorageFile copies a file within the storage backend.
+func copyStorageFile(storage StorageBackend, source, target string) error {
+	src, err := storage.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := storage.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// writeStorageFile writes the data to the file with the given name in the
+// storage backend.
+func writeStorageFile(storage StorageBackend, name string, data []byte) error {
+	fp, err := storage.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(data); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// moveStorageFile moves a file within the storage backend. The file is
+// copied and the source is removed after the copy is complete.
+func moveStorageFile(storage StorageBackend, source, target string) error {
+	if err := copyStorageFile(storage, source, target); err != nil {
+		return err
+	}
+	return storage.Remove(source)
+}
+
+// newStorageBackend returns the storage backend selected via the 'storage'
+// configuration variable. The local backend stores the files in the 'target'
+// directory.
+func newStorageBackend(values configValues) (StorageBackend, error) {
+	switch backend := values.get("storage"); backend {
+	case storageLocal:
+		return NewLocalStorage(values.get("target")), nil
+	case storageS3:
+		return NewS3Storage(S3Config{
+			Endpoint:  values.get("s3endpoint"),
+			Region:    values.get("s3region"),
+			Bucket:    values.get("s3bucket"),
+			AccessKey: values.get("s3accesskey"),
+			SecretKey: values.get("s3secretkey"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// aborter is implemented by the writers of storage backends that can discard
+// a file before it has been stored.
+type aborter interface {
+	Abort() error
+}
+
+// abortFile discards a file that was created with the Create method of the
+// storage backend and could not be written completely. The writer is aborted
+// if it supports it; otherwise it is closed and the incomplete file removed.
+func abortFile(storage StorageBackend, name string, fp io.WriteCloser) error {
+	if a, ok := fp.(aborter); ok {
+		return a.Abort()
+	}
+	fp.Close()
+	if err := storage.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
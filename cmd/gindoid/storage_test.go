@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_storage")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	storage := NewLocalStorage(tmpDir)
+	if err := storage.MkdirAll("10.12751/g-node.aaaaaa"); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	fp, err := storage.Create("10.12751/g-node.aaaaaa/doi.xml")
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	fp.Write([]byte("<xml/>"))
+	if err := fp.Close(); err != nil {
+		t.Fatalf("Error closing file: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(tmpDir, "10.12751", "g-node.aaaaaa", "doi.xml"))
+	if err != nil || string(data) != "<xml/>" {
+		t.Fatalf("Unexpected file content %q (%v)", string(data), err)
+	}
+
+	htaccess := filepath.Join(tmpDir, "10.12751", "g-node.aaaaaa", ".htaccess")
+	if err := storage.SetPublic("10.12751/g-node.aaaaaa", false); err != nil {
+		t.Fatalf("Error restricting access: %v", err)
+	}
+	if public, err := storage.IsPublic("10.12751/g-node.aaaaaa"); err != nil || public {
+		t.Fatalf("Restricted directory is public: %v", err)
+	}
+	if data, err := ioutil.ReadFile(htaccess); err != nil || string(data) != "deny from all" {
+		t.Fatalf("Unexpected .htaccess content %q (%v)", string(data), err)
+	}
+	if err := storage.SetPublic("10.12751/g-node.aaaaaa", true); err != nil {
+		t.Fatalf("Error releasing directory: %v", err)
+	}
+	if _, err := os.Stat(htaccess); !os.IsNotExist(err) {
+		t.Fatalf(".htaccess file was not removed: %v", err)
+	}
+	if public, err := storage.IsPublic("10.12751/g-node.aaaaaa"); err != nil || !public {
+		t.Fatalf("Released directory is not public: %v", err)
+	}
+	files, err := storage.List("")
+	if err != nil || len(files) != 1 || files[0].Name != "10.12751/g-node.aaaaaa/doi.xml" || files[0].ModTime.IsZero() {
+		t.Fatalf("Unexpected file list: %+v (%v)", files, err)
+	}
+	// Releasing twice must not fail
+	if err := storage.SetPublic("10.12751/g-node.aaaaaa", true); err != nil {
+		t.Fatalf("Error releasing public directory: %v", err)
+	}
+	if err := storage.Remove("10.12751/g-node.aaaaaa/doi.xml"); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+	if files, err := storage.List(""); err != nil || len(files) != 0 {
+		t.Fatalf("Unexpected file list after removal: %+v (%v)", files, err)
+	}
+}
+
+// TestS3ConfigJSON checks that the S3 secret key is not part of the JSON
+// representation of the configuration, which is logged on startup.
+func TestS3ConfigJSON(t *testing.T) {
+	storage, err := NewS3Storage(S3Config{Endpoint: "https://s3.example.org", Bucket: "doi", AccessKey: "testkey", SecretKey: "testsecret"})
+	if err != nil {
+		t.Fatalf("Error creating S3 storage: %v", err)
+	}
+	conf := Configuration{}
+	conf.Storage.Backend = storage
+	data, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatalf("Error marshalling configuration: %v", err)
+	}
+	if strings.Contains(string(data), "testsecret") {
+		t.Fatalf("Configuration JSON contains the S3 secret key: %s", string(data))
+	}
+}
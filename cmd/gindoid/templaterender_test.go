@@ -91,7 +91,7 @@ func TestRequestResultTemplate(t *testing.T) {
 	}
 
 	// success
-	message := fmt.Sprintf(msgServerIsArchiving, "test/DOI.xyz")
+	message := fmt.Sprintf(msgServerIsArchiving, "test/DOI.xyz", "test/DOI.xyz")
 	resData.Success = true
 	resData.Level = "success"
 	resData.Message = template.HTML(message)
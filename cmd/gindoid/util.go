@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
 	"strings"
 	"time"
 
@@ -176,6 +178,12 @@ func GetGINURL(conf *Configuration) string {
 	return address
 }
 
+// landingPageURL returns the URL of the landing page of the dataset with the
+// given DOI.
+func landingPageURL(conf *Configuration, doi string) string {
+	return fmt.Sprintf("%s/%s/", strings.TrimSuffix(conf.Storage.StoreURL, "/"), doi)
+}
+
 var templateMap = map[string]string{
 	"Nav":                gdtmpl.Nav,
 	"Footer":             gdtmpl.Footer,
@@ -186,6 +194,13 @@ var templateMap = map[string]string{
 	"LandingPage":        gdtmpl.LandingPage,
 	"KeywordIndex":       gdtmpl.KeywordIndex,
 	"Keyword":            gdtmpl.Keyword,
+	"JobStatus":          gdtmpl.JobStatus,
+	"AdminJobList":       gdtmpl.AdminJobList,
+	"AdminJob":           gdtmpl.AdminJob,
+	"DatasetIndex":       gdtmpl.DatasetIndex,
+	"Sitemap":            gdtmpl.Sitemap,
+	"AtomFeed":           gdtmpl.AtomFeed,
+	"Tombstone":          gdtmpl.Tombstone,
 }
 
 // prepareTemplates initialises and parses a sequence of templates in the order
@@ -229,11 +244,19 @@ var tmplfuncs = template.FuncMap{
 	"FormatCitation":   FormatCitation,
 	"FormatIssuedDate": FormatIssuedDate,
 	"KeywordPath":      KeywordPath,
+	"KeywordName":      KeywordName,
 	"FormatAuthorList": FormatAuthorList,
 	"NewVersionNotice": NewVersionNotice,
 	"OldVersionLink":   OldVersionLink,
+	"RevisionLink":     RevisionLink,
 	"GINServerURL":     GINServerURL,
+	"ArchiveChecksum":  ArchiveChecksum,
+	"ArchiveFilename":  ArchiveFilename,
+	"ArchiveType":      ArchiveType,
 	"HasGitModules":    HasGitModules,
+	"DatasetJSONLD":    DatasetJSONLD,
+	"CitationFormats":  CitationFormats,
+	"EmbargoDate":      EmbargoDate,
 }
 
 // FunderName splits the funder name from a funding string of the form <FunderName>; <AwardNumber>.
@@ -478,14 +501,27 @@ func FormatIssuedDate(md *libgin.RepositoryMetadata) string {
 	return date.Format("02 Jan. 2006")
 }
 
-// KeywordPath returns a keyword sanitised for use in a URL path:
-// Lowercase + replace / with _.
+// KeywordPath returns a keyword sanitised for use in a URL path. Synonyms of
+// curated keywords share the path of the curated keyword. See
+// keywordNormaliser.Path.
 func KeywordPath(kw string) string {
+	return defaultKeywordNormaliser().Path(kw)
+}
+
+// legacyKeywordPath returns the path of a keyword page as it was created
+// before keywords were normalised: lowercase + replace / with _.
+func legacyKeywordPath(kw string) string {
 	kw = strings.ToLower(kw)
 	kw = strings.ReplaceAll(kw, "/", "_")
 	return kw
 }
 
+// KeywordName returns the display name of a keyword: the curated keyword for
+// known synonyms or the keyword with normalised whitespace.
+func KeywordName(kw string) string {
+	return defaultKeywordNormaliser().Name(kw)
+}
+
 // FormatAuthorList returns a comma-separated list of the author names for a
 // dataset.
 func FormatAuthorList(md *libgin.RepositoryMetadata) string {
@@ -529,6 +565,24 @@ func OldVersionLink(md *libgin.RepositoryMetadata) template.HTML {
 	return ""
 }
 
+// commitURLRE matches URLs pointing to a specific commit of a repository on
+// GIN and captures the commit hash.
+var commitURLRE = regexp.MustCompile(`/src/([0-9a-f]{40})$`)
+
+// RevisionLink returns an HTML link to the registered commit of a given dataset
+// if the registration was made from a specific revision of the repository.
+func RevisionLink(md *libgin.RepositoryMetadata) template.HTML {
+	for _, relid := range md.RelatedIdentifiers {
+		if relid.RelationType != "IsVariantFormOf" {
+			continue
+		}
+		if match := commitURLRE.FindStringSubmatch(relid.Identifier); match != nil {
+			return template.HTML(fmt.Sprintf("<a href=%q>%s</a>", relid.Identifier, match[1][:10]))
+		}
+	}
+	return ""
+}
+
 // GINServerURL is the default template function returning
 // the main GIN server URL.  This function can be overriden
 // before calling HTML template execution to provide a different
@@ -537,6 +591,45 @@ func GINServerURL() string {
 	return "https://gin.g-node.org"
 }
 
+// ArchiveChecksum is the default template function returning the SHA-256
+// checksum of the dataset archive. It returns an empty string and is
+// overridden before rendering a landing page for which a checksum manifest
+// exists.
+func ArchiveChecksum() string {
+	return ""
+}
+
+// archiveURL returns the URL of the dataset archive from the related
+// identifiers. It returns an empty string if the metadata does not link to an
+// archive.
+func archiveURL(md *libgin.RepositoryMetadata) string {
+	for _, relid := range md.RelatedIdentifiers {
+		if relid.RelationType != "IsVariantFormOf" {
+			continue
+		}
+		if ext := path.Ext(relid.Identifier); ext == ".zip" || ext == ".tar" {
+			return relid.Identifier
+		}
+	}
+	return ""
+}
+
+// ArchiveFilename returns the file name of the dataset archive. The name is
+// taken from the archive URL in the related identifiers if available.
+// Otherwise the name of the default zip archive is returned.
+func ArchiveFilename(md *libgin.RepositoryMetadata) string {
+	if archive := archiveURL(md); archive != "" {
+		return path.Base(archive)
+	}
+	return strings.ReplaceAll(md.Identifier.ID, "/", "_") + ".zip"
+}
+
+// ArchiveType returns the upper case file format of the dataset archive
+// (ZIP or TAR) for display on the landing page.
+func ArchiveType(md *libgin.RepositoryMetadata) string {
+	return strings.ToUpper(strings.TrimPrefix(path.Ext(ArchiveFilename(md)), "."))
+}
+
 // URLexists runs a GET against an URL, returns true if
 // the return code is 200 and false otherwise.
 func URLexists(url string) bool {
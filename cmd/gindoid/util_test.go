@@ -11,6 +11,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/G-Node/libgin/libgin"
 )
 
 func TestReadFileAtPath(t *testing.T) {
@@ -210,3 +212,20 @@ func TestIsURL(t *testing.T) {
 		t.Fatalf("isURL returned false for test string %q", testURL)
 	}
 }
+
+func TestRevisionLink(t *testing.T) {
+	md := &libgin.RepositoryMetadata{DataCite: new(libgin.DataCite)}
+	if link := RevisionLink(md); link != "" {
+		t.Fatalf("Expected empty revision link, got %q", link)
+	}
+
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	md.RelatedIdentifiers = []libgin.RelatedIdentifier{
+		{Identifier: "https://gin.g-node.org/owner/repo", Type: "URL", RelationType: "IsVariantFormOf"},
+		{Identifier: "https://gin.g-node.org/owner/repo/src/" + commit, Type: "URL", RelationType: "IsVariantFormOf"},
+	}
+	link := string(RevisionLink(md))
+	if !strings.Contains(link, "/src/"+commit) || !strings.Contains(link, ">0123456789<") {
+		t.Fatalf("Unexpected revision link: %q", link)
+	}
+}
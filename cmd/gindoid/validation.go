@@ -45,7 +45,7 @@ func collectWarnings(job *RegistrationJob) (warnings []string) {
 	}
 
 	// Check licenses
-	repoLicURL := repoFileURL(job.Config, job.Metadata.SourceRepository, "LICENSE")
+	repoLicURL := repoFileURL(job.Config, job.Metadata.SourceRepository, job.Revision, "LICENSE")
 	warnings = licenseWarnings(job.Metadata.YAMLData, repoLicURL, warnings)
 
 	// Check if any funder IDs are missing
@@ -351,6 +351,29 @@ func validateDataCiteValues(info *libgin.RepositoryYAML) []string {
 	return invalid
 }
 
+// revisionRE matches the characters allowed in a revision name.
+var revisionRE = regexp.MustCompile(`^[[:alnum:]][[:alnum:]._/-]*$`)
+
+// isValidRevision checks whether a string can be used as a git revision
+// (commit hash, tag, or branch name) for a registration. Only a conservative
+// set of characters is allowed, and the revision may not start with a dash to
+// avoid it being interpreted as a command line option.
+func isValidRevision(revision string) bool {
+	return revisionRE.MatchString(revision) && !strings.Contains(revision, "..")
+}
+
+// doiSuffixRE matches the suffix of the DOIs created by reserveDOI.
+var doiSuffixRE = regexp.MustCompile(`^[0-9a-z]+$`)
+
+// isValidDOI checks whether a DOI consists of the given prefix followed by a
+// lowercase alphanumeric suffix.
+func isValidDOI(doi, doibase string) bool {
+	if doibase == "" || !strings.HasPrefix(doi, doibase) {
+		return false
+	}
+	return doiSuffixRE.MatchString(strings.TrimPrefix(doi, doibase))
+}
+
 // cleancompstr cleans up an input string.
 // Surrounding whitespaces are removed and
 // converted to lower case.
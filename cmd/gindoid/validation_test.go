@@ -448,3 +448,18 @@ func TestReferenceWarnings(t *testing.T) {
 		t.Fatalf("Invalid number of messages(%d): %v", len(warn), warn)
 	}
 }
+
+func TestIsValidRevision(t *testing.T) {
+	valid := []string{"v1.0", "a4f3c2d1e0b9", "release/2021-03", "Tag_1"}
+	for _, rev := range valid {
+		if !isValidRevision(rev) {
+			t.Fatalf("Valid revision %q rejected", rev)
+		}
+	}
+	invalid := []string{"", "--upload-pack=evil", "-b", "v1 .0", "master..dev", "tag;rm", "/abs"}
+	for _, rev := range invalid {
+		if isValidRevision(rev) {
+			t.Fatalf("Invalid revision %q accepted", rev)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// verify re-hashes the stored archives of the datasets with the given DOIs and
+// compares the checksums to the ones recorded in the checksum manifest of each
+// dataset.
+func verify(cmd *cobra.Command, args []string) {
+	var storage StorageBackend
+	location := "storage"
+	if targetdir, _ := cmd.Flags().GetString("target"); targetdir != "" {
+		storage = NewLocalStorage(targetdir)
+		location = targetdir
+	} else {
+		// Only the storage settings are needed; the others are not validated
+		values, problems := readConfigValues()
+		if len(problems) > 0 {
+			fmt.Printf("Failed to read the configuration:\n  %s\n", strings.Join(problems, "\n  "))
+			os.Exit(1)
+		}
+		var err error
+		storage, err = newStorageBackend(values)
+		if err != nil {
+			fmt.Printf("Failed to set up the storage backend: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if local, ok := storage.(*LocalStorage); ok {
+			if local.root == "" {
+				fmt.Println("No target directory specified; use --target or set the 'target' configuration variable")
+				os.Exit(1)
+			}
+			location = local.root
+		}
+	}
+
+	fmt.Printf("Verifying %d archives in %s\n", len(args), location)
+	var success int
+	for idx, doi := range args {
+		fmt.Printf("%3d: %s\n", idx, doi)
+		problems, err := verifyDataset(storage, doi)
+		if err != nil {
+			fmt.Printf("\tFAILED: %s\n", err.Error())
+			continue
+		}
+		if len(problems) > 0 {
+			fmt.Println("\tFAILED: archive does not match the manifest")
+			for _, msg := range problems {
+				fmt.Printf("\t  - %s\n", msg)
+			}
+			continue
+		}
+		fmt.Println("\tOK")
+		success++
+	}
+
+	fmt.Printf("%d/%d archives verified successfully\n", success, len(args))
+	if success != len(args) {
+		os.Exit(1)
+	}
+}
+
+// verifyDataset checks the archive in the given dataset directory of the
+// storage backend against the checksum manifest stored with it. It returns an
+// error if the manifest or the archive cannot be read and a list of problems
+// if the archive does not match the manifest. If the archive checksum does not
+// match, the files in the archive are checked individually to report which
+// ones differ.
+func verifyDataset(storage StorageBackend, dir string) ([]string, error) {
+	manifest, err := readManifest(storage, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum manifest: %s", err.Error())
+	}
+	archivename := path.Join(dir, manifest.Archive.Path)
+	fp, err := storage.Open(archivename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %s", err.Error())
+	}
+	checksum, size, err := sha256Sum(fp)
+	fp.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %s", err.Error())
+	}
+
+	problems := make([]string, 0)
+	if size != manifest.Archive.Size {
+		problems = append(problems, fmt.Sprintf("archive size is %d bytes, expected %d", size, manifest.Archive.Size))
+	}
+	if checksum != manifest.Archive.SHA256 {
+		problems = append(problems, fmt.Sprintf("archive checksum is %s, expected %s", checksum, manifest.Archive.SHA256))
+	}
+	if len(problems) == 0 {
+		return problems, nil
+	}
+
+	// The archive formats require random access or a local file; archives
+	// that are not stored locally are downloaded to a temporary file
+	archivefile, cleanup, err := localArchiveCopy(storage, archivename)
+	if err != nil {
+		return append(problems, fmt.Sprintf("failed to read archive: %s", err.Error())), nil
+	}
+	defer cleanup()
+	return append(problems, verifyArchiveFiles(archivefile, manifest)...), nil
+}
+
+// localArchiveCopy returns the path of a local file with the content of the
+// archive with the given name in the storage backend and a function that
+// removes the file when it is no longer needed. Files in local storage are
+// used in place.
+func localArchiveCopy(storage StorageBackend, name string) (string, func(), error) {
+	if local, ok := storage.(*LocalStorage); ok {
+		return local.path(name), func() {}, nil
+	}
+	fp, err := storage.Open(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer fp.Close()
+	tmpfp, err := ioutil.TempFile("", "gindoid-verify-*"+path.Ext(name))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(tmpfp.Name()) }
+	_, err = io.Copy(tmpfp, fp)
+	if closeerr := tmpfp.Close(); err == nil {
+		err = closeerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmpfp.Name(), cleanup, nil
+}
+
+// walkArchive calls the walker function for each regular file in a zip or tar
+// archive with the name of the file and a reader for its content. The archive
+// format is determined from the file extension.
+func walkArchive(archivefile string, walker func(name string, r io.Reader) error) error {
+	if filepath.Ext(archivefile) == ".tar" {
+		fp, err := os.Open(archivefile)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		tarreader := tar.NewReader(fp)
+		for {
+			header, err := tarreader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := walker(header.Name, tarreader); err != nil {
+				return err
+			}
+		}
+	}
+
+	zipreader, err := zip.OpenReader(archivefile)
+	if err != nil {
+		return err
+	}
+	defer zipreader.Close()
+	for _, zf := range zipreader.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = walker(zf.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyArchiveFiles compares the files in an archive to the file entries of
+// a manifest and returns a description of each difference. For BagIt bags
+// only the payload files are compared.
+func verifyArchiveFiles(archivefile string, manifest *Manifest) []string {
+	prefix := ""
+	if isBagIt(manifest.Packaging) {
+		// The bag is named after the stored archive, which can differ from
+		// the name of the local copy
+		archivename := path.Base(manifest.Archive.Path)
+		bagname := strings.TrimSuffix(archivename, path.Ext(archivename))
+		prefix = bagname + "/data/"
+	}
+
+	problems := make([]string, 0)
+	expected := make(map[string]ManifestEntry, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		expected[entry.Path] = entry
+	}
+	walker := func(name string, r io.Reader) error {
+		if !strings.HasPrefix(name, prefix) {
+			// BagIt tag file
+			return nil
+		}
+		name = strings.TrimPrefix(name, prefix)
+		entry, ok := expected[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unexpected file %q", name))
+			return nil
+		}
+		delete(expected, name)
+		checksum, size, err := sha256Sum(r)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("failed to read file %q: %s", name, err.Error()))
+		} else if size != entry.Size || checksum != entry.SHA256 {
+			problems = append(problems, fmt.Sprintf("file %q was modified", name))
+		}
+		return nil
+	}
+	if err := walkArchive(archivefile, walker); err != nil {
+		return append(problems, fmt.Sprintf("failed to read archive: %s", err.Error()))
+	}
+	for _, entry := range manifest.Files {
+		if _, missing := expected[entry.Path]; missing {
+			problems = append(problems, fmt.Sprintf("file %q is missing", entry.Path))
+		}
+	}
+	return problems
+}
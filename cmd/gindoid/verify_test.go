@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestManifestVerify(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_verify")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	source := filepath.Join(tmpDir, "repo")
+	target := filepath.Join(tmpDir, "target")
+	for _, dir := range []string{filepath.Join(source, "sub"), filepath.Join(source, ".git"), target} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Error creating directory %s: %v", dir, err)
+		}
+	}
+	files := map[string]string{
+		"README.md":     "readme",
+		"sub/data.txt":  "some data",
+		".git/excluded": "not archived",
+	}
+	for fname, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(source, fname), []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing file %s: %v", fname, err)
+		}
+	}
+	if err := os.Symlink("sub/data.txt", filepath.Join(source, "link")); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+
+	exclude := []string{".git"}
+	storage := NewLocalStorage(tmpDir)
+	archive, err := runzip(storage, source, "target/archive.zip", exclude)
+	if err != nil {
+		t.Fatalf("Error creating archive: %v", err)
+	}
+
+	entries, err := hashFiles(source, exclude)
+	if err != nil {
+		t.Fatalf("Error computing file checksums: %v", err)
+	}
+	manifest := createManifest("10.12751/g-node.aaaaaa", *archive, packagingZip, entries)
+	if len(manifest.Files) != 3 {
+		t.Fatalf("Unexpected number of files in manifest: %+v", manifest.Files)
+	}
+	if err := writeManifest(storage, manifest, "target"); err != nil {
+		t.Fatalf("Error writing manifest: %v", err)
+	}
+
+	problems, err := verifyDataset(storage, "target")
+	if err != nil {
+		t.Fatalf("Error verifying dataset: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("Unexpected problems for unmodified archive: %v", problems)
+	}
+
+	// Replace the archive with one of modified content
+	if err := ioutil.WriteFile(filepath.Join(source, "README.md"), []byte("tampered"), 0644); err != nil {
+		t.Fatalf("Error modifying file: %v", err)
+	}
+	if _, err := runzip(storage, source, "target/archive.zip", exclude); err != nil {
+		t.Fatalf("Error recreating archive: %v", err)
+	}
+	problems, err = verifyDataset(storage, "target")
+	if err != nil {
+		t.Fatalf("Error verifying modified dataset: %v", err)
+	}
+	var found bool
+	for _, msg := range problems {
+		if msg == `file "README.md" was modified` {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Modified file not reported: %v", problems)
+	}
+
+	// Missing manifest must fail
+	if _, err := verifyDataset(storage, "repo"); err == nil {
+		t.Fatalf("Verifying a directory without a manifest did not fail")
+	}
+}
+
+// TestBagItVerifyS3 checks that the payload files of a bag in a non-local
+// storage backend are compared after the bag was downloaded to a temporary
+// file.
+func TestBagItVerifyS3(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_verify")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	source := filepath.Join(tmpDir, "repo")
+	if err := os.MkdirAll(filepath.Join(source, "sub"), 0755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	files := map[string]string{
+		"README.md":    "readme",
+		"sub/data.txt": "some data",
+	}
+	for fname, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(source, fname), []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing file %s: %v", fname, err)
+		}
+	}
+
+	fake := newFakeS3("doi")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	storage, err := NewS3Storage(S3Config{Endpoint: server.URL, Region: "us-east-1", Bucket: "doi", AccessKey: "testkey", SecretKey: "testsecret"})
+	if err != nil {
+		t.Fatalf("Error creating S3 storage: %v", err)
+	}
+
+	datacite := libgin.NewDataCite()
+	metadata := &libgin.RepositoryMetadata{DataCite: &datacite}
+	metadata.Identifier.ID = "10.12751/g-node.aaaaaa"
+	metadata.SourceRepository = "owner/repo"
+
+	for _, packaging := range []string{packagingBagItZip, packagingBagItTar} {
+		dir := "10.12751/g-node.aaaaaa"
+		archivefile := dir + "/10.12751_g-node.aaaaaa" + archiveExtension(packaging)
+		if err := ioutil.WriteFile(filepath.Join(source, "README.md"), []byte("readme"), 0644); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+		archive, entries, err := runbag(storage, source, archivefile, nil, metadata, packaging)
+		if err != nil {
+			t.Fatalf("[%s] Error creating bag: %v", packaging, err)
+		}
+		manifest := createManifest(metadata.Identifier.ID, *archive, packaging, entries)
+		if err := writeManifest(storage, manifest, dir); err != nil {
+			t.Fatalf("[%s] Error writing manifest: %v", packaging, err)
+		}
+
+		// Replace the bag with one of modified content
+		if err := ioutil.WriteFile(filepath.Join(source, "README.md"), []byte("tampered"), 0644); err != nil {
+			t.Fatalf("Error modifying file: %v", err)
+		}
+		if _, _, err := runbag(storage, source, archivefile, nil, metadata, packaging); err != nil {
+			t.Fatalf("[%s] Error recreating bag: %v", packaging, err)
+		}
+		problems, err := verifyDataset(storage, dir)
+		if err != nil {
+			t.Fatalf("[%s] Error verifying modified dataset: %v", packaging, err)
+		}
+		var modified bool
+		for _, msg := range problems {
+			if msg == `file "README.md" was modified` {
+				modified = true
+			} else if strings.HasPrefix(msg, "file ") {
+				t.Fatalf("[%s] Unexpected file problem: %s", packaging, msg)
+			}
+		}
+		if !modified {
+			t.Fatalf("[%s] Modified file not reported: %v", packaging, problems)
+		}
+	}
+}
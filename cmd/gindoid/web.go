@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/G-Node/libgin/libgin"
 	"github.com/spf13/cobra"
@@ -20,6 +26,22 @@ type reqResultData struct {
 	Repository string
 }
 
+// jobStatus is the part of a JobRecord that is served by the job status
+// endpoint. It omits the personal data of the requesting user. The source
+// repository and the errors and warnings of the job are only included for
+// requests authenticated with the admin token.
+type jobStatus struct {
+	DOI         string
+	Repository  string `json:",omitempty"`
+	State       JobState
+	Created     time.Time
+	Updated     time.Time
+	Stages      []JobStage
+	ArchiveSize int64
+	Errors      []string `json:",omitempty"`
+	Warnings    []string `json:",omitempty"`
+}
+
 func web(cmd *cobra.Command, args []string) {
 	log.Printf("Starting up %s", cmd.Version)
 
@@ -32,6 +54,7 @@ func web(cmd *cobra.Command, args []string) {
 	cc := *config
 	cc.Key = "[HIDDEN]"
 	cc.GIN.Password = "[HIDDEN]"
+	cc.AdminToken = "[HIDDEN]"
 	j, _ := json.MarshalIndent(cc, "", "  ")
 	log.Print(string(j))
 
@@ -47,41 +70,174 @@ func web(cmd *cobra.Command, args []string) {
 	dispatcher := newDispatcher(jobQueue, config.MaxWorkers)
 	dispatcher.run(newWorker)
 
-	// Start the HTTP handlers.
+	// live holds the current configuration, which is replaced when the
+	// configuration is reloaded
+	live := newLiveConfig(config)
+
+	// Resume registrations that were interrupted by a restart
+	go requeueJobs(jobQueue, config)
+	// Remove old records of finished jobs
+	go pruneJobs(live)
+	// Release embargoed datasets when their embargo ends
+	go scheduleEmbargoes(live)
+
+	// The handlers are created again when the configuration is reloaded
+	handler := live.handler(func(conf *Configuration) http.Handler {
+		return newServeMux(conf, live, jobQueue, dispatcher)
+	})
+	server := &http.Server{Addr: fmt.Sprintf(":%d", config.Port), Handler: handler}
+	go func() {
+		fmt.Printf("Listening for connections on port %d\n", config.Port)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigchan {
+		if sig == syscall.SIGHUP {
+			log.Print("Received SIGHUP, reloading the configuration")
+			live.reload(dispatcher)
+			continue
+		}
+		log.Printf("Received %s, shutting down", sig)
+		shutdown(server, dispatcher, live.get())
+		return
+	}
+}
+
+// newServeMux returns the handlers of the service for the given
+// configuration.
+func newServeMux(conf *Configuration, live *liveConfig, jobQueue chan *RegistrationJob, dispatcher *Dispatcher) *http.ServeMux {
+	mux := http.NewServeMux()
 
 	// Root redirects to storage URL (DOI listing page)
-	http.Handle("/", http.RedirectHandler(config.Storage.StoreURL, http.StatusMovedPermanently))
+	mux.Handle("/", http.RedirectHandler(conf.Storage.StoreURL, http.StatusMovedPermanently))
 
 	// register renders the info page with the registration button
-	http.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/register", limitClients(conf, func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Got request: %s", r.URL.String())
-		renderRequestPage(w, r, config)
-	})
+		renderRequestPage(w, r, conf)
+	}))
 
 	// submit starts the registration job
-	http.HandleFunc("/submit", func(w http.ResponseWriter, r *http.Request) {
-		startDOIRegistration(w, r, jobQueue, config)
+	mux.HandleFunc("/submit", limitClients(conf, func(w http.ResponseWriter, r *http.Request) {
+		if dispatcher.stopped() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			renderResult(w, &reqResultData{Level: "warning", Message: template.HTML(msgShuttingDown)}, conf)
+			return
+		}
+		startDOIRegistration(w, r, jobQueue, dispatcher, conf)
+	}))
+
+	// status reports the processing state of a registration job
+	mux.HandleFunc("/status/", func(w http.ResponseWriter, r *http.Request) {
+		renderJobStatus(w, r, conf)
 	})
 
+	// metrics reports the queue, worker, and processing statistics in the
+	// Prometheus text format
+	mux.Handle("/metrics", serveMetrics(conf, jobQueue, dispatcher))
+
+	// healthz and readyz report the state of the workers and the dependencies
+	// of the service as JSON for the container orchestration
+	health := newHealthChecker(conf, jobQueue, dispatcher)
+	mux.HandleFunc("/healthz", health.serveHealth)
+	mux.HandleFunc("/readyz", health.serveReady)
+
+	// oai serves the metadata of the published datasets for harvesting
+	mux.Handle("/oai", NewOAIProvider(conf))
+
+	// admin provides the curator area for reviewing, releasing, and
+	// rejecting registrations
+	registerAdminHandlers(mux, conf)
+	// reload applies changes of the configuration, licenses, and keywords
+	// without a restart
+	mux.HandleFunc("/admin/reload", adminHandler(conf, serveReload(live, dispatcher)))
+
 	// assets fetches static assets using a custom FileSystem
 	assetserver := http.FileServer(newAssetFS("/assets"))
-	http.Handle("/assets/", http.StripPrefix("/assets/", assetserver))
+	mux.Handle("/assets/", http.StripPrefix("/assets/", assetserver))
+
+	// debug serves the expvar and pprof handlers, which register themselves
+	// with the default mux
+	mux.Handle("/debug/", http.DefaultServeMux)
+
+	return mux
+}
+
+// shutdown stops the service gracefully: new submissions are refused, the
+// workers are given ShutdownTimeout to finish their current jobs, and the
+// HTTP server is shut down once the running requests are completed. Jobs
+// that are still running when the time runs out are marked as interrupted
+// and resumed on the next start.
+func shutdown(server *http.Server, dispatcher *Dispatcher, conf *Configuration) {
+	deadline := time.Now().Add(conf.ShutdownTimeout)
+	log.Printf("Waiting up to %s for running jobs to finish", conf.ShutdownTimeout)
+	for _, id := range dispatcher.stop(conf.ShutdownTimeout) {
+		log.Printf("Job %s did not finish in time; it will be resumed after the restart", id)
+		conf.Jobs.interrupt(id)
+	}
+
+	// Leave some time for the HTTP requests even if the workers took all of it
+	if minimum := time.Now().Add(5 * time.Second); deadline.Before(minimum) {
+		deadline = minimum
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down the HTTP server: %s", err.Error())
+	}
+	log.Print("Shutdown complete")
+}
+
+// isAdminRequest returns true if the request is authenticated with the
+// configured admin token, either as a bearer token in the Authorization header
+// or as the password of HTTP basic authentication. If no admin token is
+// configured, no request is authenticated.
+func isAdminRequest(r *http.Request, conf *Configuration) bool {
+	if conf.AdminToken == "" {
+		return false
+	}
+	token := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(conf.AdminToken)) == 1
+}
 
-	fmt.Printf("Listening for connections on port %d\n", config.Port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", config.Port), nil))
+// requestData is the decrypted data of a registration request. In addition to
+// the user and repository information, GIN Web can request the registration
+// of a specific revision of the repository. The revision is part of the
+// encrypted data, so it is bound to the request issued by GIN Web and is not
+// taken from plain form fields.
+type requestData struct {
+	libgin.DOIRequestData
+	// Revision (commit hash or tag) to register; optional
+	Revision string
+	// Date (YYYY-MM-DD) until which the data are embargoed; optional and
+	// takes precedence over the embargo in the datacite.yml file
+	Embargo string
+	// Time at which GIN issued the request and a random value that
+	// identifies it; used to reject expired and replayed requests
+	Issued time.Time
+	Nonce  string
 }
 
 // decryptRequestData decrypts the submitted data into a map.  Returns with
 // error if the decryption fails, the encrypted data is not a valid JSON
 // object, or if any of the expected keys (username, realname, repository,
 // email) are not present.
-func decryptRequestData(regrequest string, key string) (*libgin.DOIRequestData, error) {
+func decryptRequestData(regrequest string, key string) (*requestData, error) {
 	plaintext, err := libgin.DecryptURLString([]byte(key), regrequest)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt verification string: %s", err.Error())
 	}
 
-	data := libgin.DOIRequestData{}
+	data := requestData{}
 	err = json.Unmarshal([]byte(plaintext), &data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal request data: %s", err.Error())
@@ -91,6 +247,11 @@ func decryptRequestData(regrequest string, key string) (*libgin.DOIRequestData,
 	if data.Username == "" || data.Repository == "" || data.Email == "" {
 		return nil, fmt.Errorf("invalid request: required key is missing or empty")
 	}
+	if data.Embargo != "" {
+		if _, err := parseEmbargo(data.Embargo); err != nil {
+			return nil, fmt.Errorf("invalid request: %s", err.Error())
+		}
+	}
 
 	return &data, nil
 }
@@ -110,6 +271,20 @@ func injectDynamicGINURL(tmpl *template.Template, ginurl string) *template.Templ
 	return tmpl
 }
 
+// injectArchiveChecksum overwrites the 'ArchiveChecksum' default template
+// function to provide the checksum of the dataset archive.
+func injectArchiveChecksum(tmpl *template.Template, checksum string) *template.Template {
+	if checksum != "" {
+		var injectedFunc = template.FuncMap{
+			"ArchiveChecksum": func() string {
+				return checksum
+			},
+		}
+		tmpl = template.Must(tmpl.Clone()).Funcs(injectedFunc)
+	}
+	return tmpl
+}
+
 // renderRequestPage renders the page for the staging area, where information
 // is provided to the user and offers to start the DOI registration request.
 // It validates the metadata provided from the GIN repository and shows
@@ -128,10 +303,13 @@ func renderRequestPage(w http.ResponseWriter, r *http.Request, conf *Configurati
 
 	regRequest := &RegistrationRequest{}
 	reqdata, err := decryptRequestData(encReqData, conf.Key)
+	if err == nil {
+		err = checkRequest(conf, reqdata, time.Now())
+	}
 	if err != nil {
 		log.Printf("Invalid request: %s", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
-		regRequest.Message = template.HTML(msgInvalidRequest)
+		regRequest.Message = template.HTML(requestMessage(err))
 		regRequest.Metadata = new(libgin.RepositoryMetadata)
 		tmpl, err := prepareTemplates("RequestFailurePage")
 		if err != nil {
@@ -148,11 +326,19 @@ func renderRequestPage(w http.ResponseWriter, r *http.Request, conf *Configurati
 		return
 	}
 
-	regRequest.DOIRequestData = reqdata
+	regRequest.DOIRequestData = &reqdata.DOIRequestData
 	regRequest.EncryptedRequestData = encReqData // Forward it through the hidden form in the template
+	regRequest.Revision = reqdata.Revision
 	regRequest.Metadata = &libgin.RepositoryMetadata{}
+	cookie, err := csrfCookie(w, r)
+	if err != nil {
+		log.Printf("Failed to create CSRF cookie: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	regRequest.CSRFToken = csrfToken(conf.Key, cookie, reqdata.Nonce)
 
-	repoMetadata, err := readAndValidate(conf, regRequest.Repository)
+	repoMetadata, embargo, err := readAndValidate(conf, regRequest.Repository, regRequest.Revision)
 	if err != nil {
 		regRequest.ErrorMessages = []string{err.Error()}
 		regRequest.Message = template.HTML(err.Error())
@@ -185,6 +371,7 @@ func renderRequestPage(w http.ResponseWriter, r *http.Request, conf *Configurati
 
 	regRequest.Metadata.YAMLData = repoMetadata
 	regRequest.Metadata.DataCite = libgin.NewDataCiteFromYAML(repoMetadata)
+	setEmbargo(regRequest.Metadata, embargo, reqdata.Embargo)
 	regRequest.Metadata.SourceRepository = regRequest.DOIRequestData.Repository
 	regRequest.Metadata.ForkRepository = regRequest.DOIRequestData.Repository // Make the button link to repo for preview
 
@@ -198,7 +385,7 @@ func renderRequestPage(w http.ResponseWriter, r *http.Request, conf *Configurati
 
 // startDOIRegistration starts the DOI registration process by authenticating
 // with the GIN server and adding a new DOIJob to the jobQueue.
-func startDOIRegistration(w http.ResponseWriter, r *http.Request, jobQueue chan *RegistrationJob, conf *Configuration) {
+func startDOIRegistration(w http.ResponseWriter, r *http.Request, jobQueue chan *RegistrationJob, dispatcher *Dispatcher, conf *Configuration) {
 	// Make sure we can only be called with an HTTP POST request.
 	if r.Method != "POST" {
 		w.Header().Set("Allow", "POST")
@@ -219,17 +406,73 @@ func startDOIRegistration(w http.ResponseWriter, r *http.Request, jobQueue chan
 
 	encryptedRequestData := r.PostFormValue("reqdata")
 	reqdata, err := decryptRequestData(encryptedRequestData, conf.Key)
+	if err == nil && !checkCSRFToken(r, conf.Key, reqdata.Nonce) {
+		err = fmt.Errorf("invalid request: form token does not match the request page")
+	}
+	if err == nil {
+		err = checkRequest(conf, reqdata, time.Now())
+	}
 	if err != nil {
 		log.Printf("Invalid request: %s", err.Error())
-		resData.Message = template.HTML(msgInvalidRequest)
+		resData.Message = template.HTML(requestMessage(err))
 		// ignore the error, no email to send
 		renderResult(w, &resData, conf)
 		return
 	}
+
 	resData.Repository = reqdata.Repository
 
 	log.Printf("Received DOI request: %+v", reqdata)
 
+	// Show the reserved DOI instead of starting again if the same commit is
+	// being processed or was registered recently; if the commit of the
+	// revision cannot be determined, submissions of the same revision are
+	// considered the same
+	since := time.Now().Add(-conf.DuplicateWindow)
+	commit, err := resolveCommit(conf, reqdata.Repository, reqdata.Revision)
+	if err != nil {
+		log.Printf("Failed to resolve the commit of %s@%s: %s", reqdata.Repository, reqdata.Revision, err.Error())
+	}
+	if duplicate, err := conf.Jobs.findDuplicate(reqdata.Repository, reqdata.Revision, commit, since); err != nil {
+		log.Printf("Failed to check for duplicate submissions: %s", err.Error())
+	} else if duplicate != nil {
+		log.Printf("Repository %s was already submitted as %s (%s)", reqdata.Repository, duplicate.ID, duplicate.State)
+		note := ""
+		if commit == "" {
+			note = msgDuplicateUnresolved
+		}
+		resData.Success = true
+		resData.Level = "warning"
+		resData.Message = template.HTML(fmt.Sprintf(msgDuplicateSubmission, duplicate.ID, duplicate.State, duplicate.ID, note))
+		renderResult(w, &resData, conf)
+		return
+	}
+
+	// Turn the request away before it is marked as used, so that it can be
+	// submitted again later
+	if queueFull(conf, jobQueue, dispatcher) {
+		log.Printf("Job queue full, rejecting request for %s", reqdata.Repository)
+		conf.Metrics.countThrottled(throttleQueueFull)
+		renderBusy(w, conf, http.StatusServiceUnavailable, busyRetryAfter, msgServiceBusy)
+		return
+	}
+	if message, retry := checkUserLimits(conf, reqdata.Username, time.Now()); message != "" {
+		renderBusy(w, conf, http.StatusTooManyRequests, retry, message)
+		return
+	}
+
+	// Mark the request as used before anything else happens, so that a second
+	// submission of the same request is rejected
+	err = conf.Requests.use(reqdata.Nonce, reqdata.Issued.Add(conf.RequestMaxAge))
+	if err == errRequestUsed {
+		log.Printf("Invalid request: %s", err.Error())
+		resData.Message = template.HTML(requestMessage(err))
+		renderResult(w, &resData, conf)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save used request: %s", err.Error())
+	}
 	requser := &libgin.GINUser{
 		Username: reqdata.Username,
 		RealName: reqdata.Realname,
@@ -264,22 +507,13 @@ func startDOIRegistration(w http.ResponseWriter, r *http.Request, jobQueue chan
 		renderResult(w, &resData, conf)
 	}()
 
-	// generate random DOI (keep generating if it's already registered)
-	var doi string
-	maxtry := 5
-	for ntry := 0; doi == "" || libgin.IsRegisteredDOI(doi); ntry++ {
-		// limit to 5 attempts in case something goes wrong (a bug in the
-		// randomiser) or we somehow win the lottery and keep generating valid
-		// DOIs
-		if ntry == maxtry {
-			errors = append(errors, fmt.Sprintf("Couldn't find a new DOI after %d tries (or the PRNG is broken)", maxtry))
-			resData.Success = false
-			resData.Level = "warning"
-			resData.Message = template.HTML(msgSubmitError)
-			return
-
-		}
-		doi = conf.DOIBase + randAlnum(6)
+	doi, err := reserveDOI(conf)
+	if err != nil {
+		errors = append(errors, err.Error())
+		resData.Success = false
+		resData.Level = "warning"
+		resData.Message = template.HTML(msgSubmitError)
+		return
 	}
 
 	// NOTE: Delete?
@@ -295,7 +529,8 @@ func startDOIRegistration(w http.ResponseWriter, r *http.Request, jobQueue chan
 		return
 	}
 
-	repoMetadata, err := readAndValidate(conf, regJob.Metadata.SourceRepository)
+	regJob.Revision = reqdata.Revision
+	repoMetadata, embargo, err := readAndValidate(conf, regJob.Metadata.SourceRepository, regJob.Revision)
 	if err != nil {
 		errors = append(errors, err.Error())
 		resData.Success = false
@@ -306,17 +541,56 @@ func startDOIRegistration(w http.ResponseWriter, r *http.Request, jobQueue chan
 
 	regJob.Metadata.YAMLData = repoMetadata
 	regJob.Metadata.DataCite = libgin.NewDataCiteFromYAML(repoMetadata)
+	setEmbargo(regJob.Metadata, embargo, reqdata.Embargo)
 	regJob.Metadata.Identifier.ID = doi
 	regJob.Metadata.Identifier.Type = "DOI"
 
+	// Create the draft DOI at DataCite; failures are reported to the admins
+	// and the DOI can still be registered manually
+	if conf.DataCite != nil {
+		if err := conf.DataCite.CreateDraft(doi); err != nil {
+			log.Printf("Failed to create draft DOI %s: %s", doi, err.Error())
+			errors = append(errors, fmt.Sprintf("Failed to create draft DOI at DataCite: %s", err.Error()))
+		}
+	}
+
 	log.Printf("Submitting job")
 
-	// Add job to queue
-	jobQueue <- regJob
+	// Persist the job before queueing it so it can be resumed after a restart
+	if err := conf.Jobs.add(regJob); err != nil {
+		log.Printf("Failed to store job %s: %s", doi, err.Error())
+		errors = append(errors, fmt.Sprintf("Failed to store job; the job will not be resumed after a restart: %s", err.Error()))
+	}
+
+	// Add job to queue without waiting; the queue may have filled up since
+	// the request was checked
+	queued := false
+	if !queueFull(conf, jobQueue, dispatcher) {
+		select {
+		case jobQueue <- regJob:
+			queued = true
+		default:
+		}
+	}
+	if !queued {
+		log.Printf("Job queue full, failed to queue job %s", doi)
+		conf.Metrics.countThrottled(throttleQueueFull)
+		errors = append(errors, "Job queue full; the job was not started and the user was asked to submit the request again")
+		conf.Jobs.finish(doi, jobFailed, []string{"job queue full"}, nil)
+		if err := conf.Requests.release(reqdata.Nonce); err != nil {
+			log.Printf("Failed to release request: %s", err.Error())
+		}
+		resData.Success = false
+		resData.Level = "warning"
+		resData.Message = template.HTML(msgServiceBusy)
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(busyRetryAfter.Seconds())))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 
 	// Render success (deferred)
 	log.Printf("Render success")
-	message := fmt.Sprintf(msgServerIsArchiving, doi)
+	message := fmt.Sprintf(msgServerIsArchiving, doi, doi)
 	resData.Success = true
 	resData.Level = "success"
 	resData.Message = template.HTML(message)
@@ -328,6 +602,27 @@ func startDOIRegistration(w http.ResponseWriter, r *http.Request, jobQueue chan
 	}
 }
 
+// isRegisteredDOI checks whether a DOI is already registered. It can be
+// replaced in tests to avoid querying the DOI resolver.
+var isRegisteredDOI = libgin.IsRegisteredDOI
+
+// reserveDOI generates a new random DOI with the configured prefix. New DOIs
+// are generated until one is found that is not already registered.
+func reserveDOI(conf *Configuration) (string, error) {
+	var doi string
+	maxtry := 5
+	for ntry := 0; doi == "" || isRegisteredDOI(doi); ntry++ {
+		// limit to 5 attempts in case something goes wrong (a bug in the
+		// randomiser) or we somehow win the lottery and keep generating valid
+		// DOIs
+		if ntry == maxtry {
+			return "", fmt.Errorf("couldn't find a new DOI after %d tries (or the PRNG is broken)", maxtry)
+		}
+		doi = conf.DOIBase + randAlnum(6)
+	}
+	return doi, nil
+}
+
 // renderResult renders the results of a registration request using the
 // 'RequestResult' template. If it fails to parse the template, it renders
 // the Message from the result data in plain HTML.
@@ -350,3 +645,62 @@ func renderResult(w http.ResponseWriter, resData *reqResultData, conf *Configura
 		log.Printf("Error rendering RequestResult template: %v", err.Error())
 	}
 }
+
+// renderJobStatus serves the processing status of the registration job for
+// the DOI given in the request path (/status/<doi>). The status is returned as
+// JSON, unless an HTML page is requested by the client, either through the
+// Accept header or the 'format=html' query parameter. The public status only
+// shows the processing state; the repository, errors, and warnings are added
+// for requests that carry the admin token (see isAdminRequest).
+func renderJobStatus(w http.ResponseWriter, r *http.Request, conf *Configuration) {
+	doi := strings.TrimPrefix(r.URL.Path, "/status/")
+	rec, err := conf.Jobs.get(doi)
+	if err != nil {
+		log.Printf("Status request for unknown job %q: %s", doi, err.Error())
+		http.NotFound(w, r)
+		return
+	}
+
+	status := jobStatus{
+		DOI:         rec.ID,
+		State:       rec.State,
+		Created:     rec.Created,
+		Updated:     rec.Updated,
+		Stages:      rec.Stages,
+		ArchiveSize: rec.ArchiveSize,
+	}
+	if isAdminRequest(r, conf) {
+		// Curator view: include the details of the job
+		if rec.Metadata != nil {
+			status.Repository = rec.Metadata.SourceRepository
+		}
+		status.Errors = rec.Errors
+		status.Warnings = rec.Warnings
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		format = "html"
+	}
+	if format != "html" {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(status); err != nil {
+			log.Printf("Failed to write job status: %s", err.Error())
+		}
+		return
+	}
+
+	tmpl, err := prepareTemplates("JobStatus")
+	if err != nil {
+		log.Printf("Failed to parse JobStatus template: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Overwrite default GIN server URL with config GIN server URL
+	tmpl = injectDynamicGINURL(tmpl, GetGINURL(conf))
+	if err := tmpl.Execute(w, status); err != nil {
+		log.Printf("Error rendering JobStatus template: %s", err.Error())
+	}
+}
@@ -2,8 +2,21 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/libgin/libgin"
 )
 
 // TestInjectDynamicGINURL checks that the function injectDynamicGINURL
@@ -49,3 +62,190 @@ func TestInjectDynamicGINURL(t *testing.T) {
 		t.Fatalf("Error dynamic URL; got: '%s'", b.String())
 	}
 }
+
+func TestRenderJobStatus(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_jobstatus")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	conf := &Configuration{Jobs: store, AdminToken: "secret"}
+	conf.GIN.Session = ginclient.New("")
+
+	doi := "10.12751/g-node.abcdef"
+	job := newTestJob(doi, "owner/repo")
+	job.Metadata.RequestingUser = &libgin.GINUser{Username: "owner", Email: "owner@example.org"}
+	if err := store.add(job); err != nil {
+		t.Fatalf("Error adding job: %v", err)
+	}
+	store.startStage(doi, "clone repository")
+	store.setArchiveSize(doi, 1024)
+	store.finish(doi, jobFailed, []string{"clone failed"}, nil)
+
+	// JSON response
+	req := httptest.NewRequest(http.MethodGet, "/status/"+doi, nil)
+	rec := httptest.NewRecorder()
+	renderJobStatus(rec, req, conf)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d", rec.Code)
+	}
+	status := jobStatus{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to unmarshal job status: %v", err)
+	}
+	if status.DOI != doi || status.State != jobFailed || status.ArchiveSize != 1024 {
+		t.Fatalf("Unexpected job status: %+v", status)
+	}
+	if len(status.Stages) != 1 || status.Stages[0].Finished == nil {
+		t.Fatalf("Unexpected job stages: %+v", status.Stages)
+	}
+	if status.Repository != "" || len(status.Errors) != 0 {
+		t.Fatalf("Public job status exposes job details: %+v", status)
+	}
+
+	// JSON response with admin token
+	req = httptest.NewRequest(http.MethodGet, "/status/"+doi, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	renderJobStatus(rec, req, conf)
+	status = jobStatus{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to unmarshal job status: %v", err)
+	}
+	if status.Repository != "owner/repo" || len(status.Errors) != 1 {
+		t.Fatalf("Unexpected admin job status: %+v", status)
+	}
+	if strings.Contains(rec.Body.String(), "owner@example.org") {
+		t.Fatal("Job status exposes the email address of the requesting user")
+	}
+
+	// Wrong token
+	req = httptest.NewRequest(http.MethodGet, "/status/"+doi, nil)
+	req.SetBasicAuth("curator", "wrong")
+	if isAdminRequest(req, conf) {
+		t.Fatal("Request with wrong token is authenticated")
+	}
+	req.SetBasicAuth("curator", "secret")
+	if !isAdminRequest(req, conf) {
+		t.Fatal("Request with admin token is not authenticated")
+	}
+
+	// HTML response
+	req = httptest.NewRequest(http.MethodGet, "/status/"+doi+"?format=html", nil)
+	rec = httptest.NewRecorder()
+	renderJobStatus(rec, req, conf)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code for HTML page: %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "clone repository") {
+		t.Fatalf("HTML page is missing the job stages: %s", rec.Body.String())
+	}
+
+	// Unknown job
+	req = httptest.NewRequest(http.MethodGet, "/status/10.12751/g-node.nothere", nil)
+	rec = httptest.NewRecorder()
+	renderJobStatus(rec, req, conf)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected status code for unknown job: %d", rec.Code)
+	}
+}
+
+func TestDecryptRequestData(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef"
+	encrypt := func(plaintext string) string {
+		enc, err := libgin.EncryptURLString([]byte(key), plaintext)
+		if err != nil {
+			t.Fatalf("Failed to encrypt request data: %v", err)
+		}
+		return enc
+	}
+
+	// Request data as sent by GIN without a revision
+	enc := encrypt(`{"Username": "owner", "Realname": "Owner", "Repository": "owner/repo", "Email": "owner@example.org"}`)
+	data, err := decryptRequestData(enc, key)
+	if err != nil {
+		t.Fatalf("Failed to decrypt request data: %v", err)
+	}
+	if data.Repository != "owner/repo" || data.Revision != "" {
+		t.Fatalf("Unexpected request data: %+v", data)
+	}
+
+	// The revision is carried in the encrypted data
+	enc = encrypt(`{"Username": "owner", "Repository": "owner/repo", "Email": "owner@example.org", "Revision": "v1.0"}`)
+	data, err = decryptRequestData(enc, key)
+	if err != nil {
+		t.Fatalf("Failed to decrypt request data: %v", err)
+	}
+	if data.Revision != "v1.0" {
+		t.Fatalf("Unexpected revision: %q", data.Revision)
+	}
+
+	// Missing required keys
+	enc = encrypt(`{"Username": "owner", "Revision": "v1.0"}`)
+	if _, err := decryptRequestData(enc, key); err == nil {
+		t.Fatal("Incomplete request data accepted")
+	}
+
+	// Wrong key
+	if _, err := decryptRequestData(enc, "fedcba9876543210fedcba9876543210"); err == nil {
+		t.Fatal("Request data with wrong key accepted")
+	}
+}
+
+func TestSubmitQueueFull(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_submit")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newRequestStore(filepath.Join(tmpDir, usedRequestsFile))
+	if err != nil {
+		t.Fatalf("Error creating request store: %v", err)
+	}
+	key := "0123456789abcdef0123456789abcdef"
+	conf := &Configuration{Key: key, Requests: store, RequestMaxAge: time.Hour, MaxQueue: 1}
+	conf.GIN.Session = ginclient.New("")
+
+	// The queue holds as many jobs as allowed; the dispatcher is not running
+	jobQueue := make(chan *RegistrationJob, 2)
+	jobQueue <- newTestJob("10.12751/g-node.aaaaaa", "owner/other")
+	dispatcher := newDispatcher(jobQueue, 1)
+
+	nonce := "queue-full-nonce"
+	plaintext := fmt.Sprintf(`{"Username": "owner", "Repository": "owner/repo", "Email": "owner@example.org", "Issued": %q, "Nonce": %q}`,
+		time.Now().Format(time.RFC3339), nonce)
+	enc, err := libgin.EncryptURLString([]byte(key), plaintext)
+	if err != nil {
+		t.Fatalf("Failed to encrypt request data: %v", err)
+	}
+	cookie := strings.Repeat("c", 64)
+	form := url.Values{"reqdata": {enc}, "csrftoken": {csrfToken(key, cookie, nonce)}}
+	req := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookie})
+	rec := httptest.NewRecorder()
+	startDOIRegistration(rec, req, jobQueue, dispatcher, conf)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status code with full queue: %d\n%s", rec.Code, rec.Body.String())
+	}
+	if retry := rec.Header().Get("Retry-After"); retry != "300" {
+		t.Errorf("Unexpected Retry-After header: %q", retry)
+	}
+	if !strings.Contains(rec.Body.String(), "The DOI service is busy") {
+		t.Errorf("Busy message missing from response:\n%s", rec.Body.String())
+	}
+	if len(jobQueue) != 1 {
+		t.Errorf("Job added to full queue")
+	}
+	// The request can be submitted again
+	if store.isUsed(nonce) {
+		t.Error("Request turned away with full queue was marked as used")
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/G-Node/libgin/libgin"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// withdrawalfname is the name of the file that marks the dataset
+	// directory of a withdrawn dataset and records the reason.
+	withdrawalfname = "withdrawn.json"
+	// quarantinedir is the directory of the storage backend where the
+	// archives of withdrawn datasets are kept when they are not purged.
+	quarantinedir = "quarantine"
+	// withdrawalDescType is the DataCite description type of the withdrawal
+	// notice that is added to the metadata of a withdrawn dataset. The
+	// "Other" type is not used since it holds the reference citations.
+	withdrawalDescType = "TechnicalInfo"
+	// withdrawalPrefix starts the withdrawal notice.
+	withdrawalPrefix = "Withdrawn on "
+)
+
+// Withdrawal is the content of the withdrawal marker file of a dataset.
+type Withdrawal struct {
+	DOI    string
+	Date   time.Time
+	Reason string
+	// Storage names of the quarantined archives; empty if the archives were
+	// deleted
+	Quarantine []string `json:",omitempty"`
+}
+
+// withdrawalNotice returns the withdrawal notice from the metadata of a
+// dataset or an empty string if the dataset was not withdrawn.
+func withdrawalNotice(md *libgin.RepositoryMetadata) string {
+	if md.DataCite == nil {
+		return ""
+	}
+	for _, desc := range md.Descriptions {
+		if desc.Type == withdrawalDescType && strings.HasPrefix(desc.Content, withdrawalPrefix) {
+			return desc.Content
+		}
+	}
+	return ""
+}
+
+// isWithdrawn returns true if the metadata of a dataset contains a withdrawal
+// notice.
+func isWithdrawn(md *libgin.RepositoryMetadata) bool {
+	return withdrawalNotice(md) != ""
+}
+
+// tombstonePage is the data of the Tombstone template.
+type tombstonePage struct {
+	*libgin.RepositoryMetadata
+	Notice string
+}
+
+// Abstract returns the abstract of the dataset.
+func (p tombstonePage) Abstract() string {
+	for _, desc := range p.Descriptions {
+		if desc.Type == "Abstract" {
+			return desc.Content
+		}
+	}
+	return ""
+}
+
+// createTombstonePage renders the page that replaces the landing page of a
+// withdrawn dataset to the target file in the storage backend.
+func createTombstonePage(storage StorageBackend, metadata *libgin.RepositoryMetadata, targetfile string, ginurl string) error {
+	tmpl, err := prepareTemplates("Tombstone")
+	if err != nil {
+		return err
+	}
+	tmpl = injectDynamicGINURL(tmpl, ginurl)
+
+	fp, err := storage.Create(targetfile)
+	if err != nil {
+		log.Printf("Could not create the tombstone page file: %s", err.Error())
+		return err
+	}
+	if err := tmpl.Execute(fp, tombstonePage{RepositoryMetadata: metadata, Notice: withdrawalNotice(metadata)}); err != nil {
+		fp.Close()
+		log.Printf("Error rendering the tombstone page: %s", err.Error())
+		return err
+	}
+	return fp.Close()
+}
+
+// readStoredMetadata reads and parses the doi.xml file of a dataset from the
+// storage backend.
+func readStoredMetadata(storage StorageBackend, doi string) (*libgin.RepositoryMetadata, error) {
+	fp, err := storage.Open(path.Join(doi, "doi.xml"))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	data, err := ioutil.ReadAll(fp)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetadataXML(data)
+}
+
+// storedArchives returns the storage names of the zip and tar files directly
+// in the given directory of the storage backend.
+func storedArchives(storage StorageBackend, dir string) ([]string, error) {
+	files, err := storage.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	archives := make([]string, 0, 1)
+	for _, file := range files {
+		if path.Dir(file.Name) != dir {
+			continue
+		}
+		if ext := path.Ext(file.Name); ext == ".zip" || ext == ".tar" {
+			archives = append(archives, file.Name)
+		}
+	}
+	return archives, nil
+}
+
+// datasetArchives returns the storage names of the archives of a dataset in
+// the dataset directory and in the embargo directory.
+func datasetArchives(storage StorageBackend, doi string) ([]string, error) {
+	archives, err := storedArchives(storage, doi)
+	if err != nil {
+		return nil, err
+	}
+	held, err := storedArchives(storage, path.Join(embargodir, doi))
+	if err != nil {
+		return nil, err
+	}
+	return append(archives, held...), nil
+}
+
+// withdrawDataset withdraws a published dataset: the archive is moved to the
+// restricted quarantine directory (or deleted if purge is set), the archive
+// link is removed from the metadata and a withdrawal notice is added, the
+// landing page is replaced with the tombstone page, the DOI is hidden at
+// DataCite, and the dataset is removed from the keyword pages if the keyword
+// directory is configured. The citation files are kept. It returns a
+// description of each step; an error is returned if the dataset cannot be
+// withdrawn at all.
+func withdrawDataset(conf *Configuration, doi string, reason string, purge bool) ([]string, error) {
+	storage := conf.Storage.Backend
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("a reason is required to withdraw a dataset")
+	}
+	metadata, err := readStoredMetadata(storage, doi)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the metadata of %s: %s", doi, err.Error())
+	}
+	if isWithdrawn(metadata) {
+		return nil, fmt.Errorf("dataset %s has already been withdrawn", doi)
+	}
+
+	messages := make([]string, 0, 6)
+	withdrawal := Withdrawal{DOI: doi, Date: time.Now().UTC(), Reason: reason}
+	archives, err := datasetArchives(storage, doi)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the archive of %s: %s", doi, err.Error())
+	}
+	for _, archive := range archives {
+		if !purge {
+			qdir := path.Join(quarantinedir, doi)
+			if err := storage.MkdirAll(qdir); err != nil {
+				return messages, fmt.Errorf("failed to create the quarantine directory: %s", err.Error())
+			}
+			if err := storage.SetPublic(qdir, false); err != nil {
+				return messages, fmt.Errorf("failed to restrict access to the quarantine directory: %s", err.Error())
+			}
+			target := path.Join(qdir, path.Base(archive))
+			if err := moveStorageFile(storage, archive, target); err != nil {
+				return messages, fmt.Errorf("failed to move %s to quarantine: %s", archive, err.Error())
+			}
+			withdrawal.Quarantine = append(withdrawal.Quarantine, target)
+			messages = append(messages, fmt.Sprintf("Moved %s to %s", archive, target))
+			continue
+		}
+		if err := storage.Remove(archive); err != nil {
+			return messages, fmt.Errorf("failed to remove %s: %s", archive, err.Error())
+		}
+		messages = append(messages, fmt.Sprintf("Deleted %s", archive))
+	}
+	if len(archives) == 0 {
+		messages = append(messages, "No archive found")
+	}
+
+	// Remove the archive link and size and add the notice to the metadata
+	relids := make([]libgin.RelatedIdentifier, 0, len(metadata.RelatedIdentifiers))
+	for _, relid := range metadata.RelatedIdentifiers {
+		if relid.RelationType == "IsVariantFormOf" {
+			if ext := path.Ext(relid.Identifier); ext == ".zip" || ext == ".tar" {
+				continue
+			}
+		}
+		relids = append(relids, relid)
+	}
+	metadata.RelatedIdentifiers = relids
+	metadata.Sizes = nil
+	notice := fmt.Sprintf("%s%s: %s", withdrawalPrefix, withdrawal.Date.Format("2006-01-02"), reason)
+	metadata.Descriptions = append(metadata.Descriptions, libgin.Description{Content: notice, Type: withdrawalDescType})
+
+	marker, err := json.MarshalIndent(withdrawal, "", "  ")
+	if err != nil {
+		return messages, err
+	}
+	if err := writeStorageFile(storage, path.Join(doi, withdrawalfname), marker); err != nil {
+		return messages, fmt.Errorf("failed to write the withdrawal marker: %s", err.Error())
+	}
+	xmldata, err := metadata.DataCite.Marshal()
+	if err != nil {
+		return messages, fmt.Errorf("failed to render the metadata: %s", err.Error())
+	}
+	if err := writeStorageFile(storage, path.Join(doi, "doi.xml"), []byte(xmldata)); err != nil {
+		return messages, fmt.Errorf("failed to write the metadata: %s", err.Error())
+	}
+	messages = append(messages, "Withdrawal notice added to the metadata")
+	if err := createTombstonePage(storage, metadata, path.Join(doi, "index.html"), GetGINURL(conf)); err != nil {
+		return messages, fmt.Errorf("failed to create the tombstone page: %s", err.Error())
+	}
+	messages = append(messages, "Landing page replaced with the tombstone page")
+
+	if conf.DataCite != nil {
+		if err := conf.DataCite.UpdateMetadata(doi, []byte(xmldata), landingPageURL(conf, doi)); err != nil {
+			log.Printf("Failed to update the metadata of %s at DataCite: %s", doi, err.Error())
+			messages = append(messages, fmt.Sprintf("Failed to update the metadata at DataCite; this needs to be done manually: %s", err.Error()))
+		} else if err := conf.DataCite.Hide(doi); err != nil {
+			log.Printf("Failed to hide %s at DataCite: %s", doi, err.Error())
+			messages = append(messages, fmt.Sprintf("Failed to hide the DOI at DataCite; this needs to be done manually: %s", err.Error()))
+		} else {
+			messages = append(messages, "DOI is no longer findable at DataCite")
+		}
+	}
+
+	if conf.KeywordDirectory != "" {
+		if changed, err := removeKeywords(conf.KeywordDirectory, doi); err != nil {
+			log.Printf("Failed to remove %s from the keyword pages: %s", doi, err.Error())
+			messages = append(messages, fmt.Sprintf("Failed to remove the dataset from the keyword pages; run make-keyword-pages --remove: %s", err.Error()))
+		} else {
+			messages = append(messages, fmt.Sprintf("Removed from %d keyword page(s)", changed))
+		}
+	}
+
+	if _, err := conf.Jobs.get(doi); err == nil {
+		conf.Jobs.review(doi, jobWithdrawn, reason)
+	}
+	log.Printf("Withdrew %s: %s", doi, reason)
+	return messages, nil
+}
+
+// withdraw withdraws the published datasets with the given DOIs. The keyword
+// directory given with --keyword-dir replaces the configured one.
+func withdraw(cmd *cobra.Command, args []string) {
+	reason, _ := cmd.Flags().GetString("reason")
+	purge, _ := cmd.Flags().GetBool("purge")
+	kwdir, _ := cmd.Flags().GetString("keyword-dir")
+	if strings.TrimSpace(reason) == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: A reason must be given with --reason")
+		os.Exit(1)
+	}
+
+	conf, err := loadconfig()
+	if err != nil {
+		fmt.Printf("Failed to load configuration: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if kwdir != "" {
+		conf.KeywordDirectory = kwdir
+	}
+
+	var failed int
+	for _, doi := range args {
+		fmt.Printf("Withdrawing %s\n", doi)
+		messages, err := withdrawDataset(conf, doi, reason, purge)
+		for _, msg := range messages {
+			fmt.Printf(" -> %s\n", msg)
+		}
+		if err != nil {
+			fmt.Printf("Failed to withdraw %s: %s\n", doi, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
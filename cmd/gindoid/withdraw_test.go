@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/libgin/libgin"
+)
+
+func TestWithdrawDataset(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test_gindoi_withdraw")
+	if err != nil {
+		t.Fatalf("Error creating tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := newJobStore(filepath.Join(tmpDir, jobsdir))
+	if err != nil {
+		t.Fatalf("Error creating job store: %v", err)
+	}
+	fake := newFakeDataCite()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	conf := &Configuration{Jobs: store}
+	conf.GIN.Session = ginclient.New("")
+	conf.Storage.StoreURL = "https://doi.example.org"
+	conf.Storage.Backend = NewLocalStorage(filepath.Join(tmpDir, "target"))
+	conf.DataCite = NewDataCiteClient(server.URL, "GIN.TEST", "testpassword", false)
+
+	dois := []string{"10.12751/g-node.aaaaaa", "10.12751/g-node.bbbbbb"}
+	for _, doi := range dois {
+		yamldata := &libgin.RepositoryYAML{
+			Authors:      []libgin.Author{{FirstName: "Alice", LastName: "Doe"}},
+			Title:        "Dataset " + doi,
+			Description:  "The abstract",
+			Keywords:     []string{"Neuroscience"},
+			License:      &libgin.License{Name: "CC-BY", URL: "https://creativecommons.org/licenses/by/4.0/"},
+			ResourceType: "Dataset",
+		}
+		job := newTestJob(doi, "owner/repo")
+		job.Metadata.YAMLData = yamldata
+		job.Metadata.DataCite = libgin.NewDataCiteFromYAML(yamldata)
+		job.Metadata.Identifier.ID = doi
+		job.Metadata.Dates = []libgin.Date{{Value: "2020-01-01", Type: "Issued"}}
+		job.Metadata.Sizes = &[]string{"12 B"}
+		archive := strings.ReplaceAll(doi, "/", "_") + ".zip"
+		job.Metadata.RelatedIdentifiers = []libgin.RelatedIdentifier{
+			{Identifier: "https://gin.g-node.org/owner/repo", Type: "URL", RelationType: "IsVariantFormOf"},
+			{Identifier: "https://doi.example.org/" + doi + "/" + archive, Type: "URL", RelationType: "IsVariantFormOf"},
+		}
+		xmldata, err := job.Metadata.DataCite.Marshal()
+		if err != nil {
+			t.Fatalf("Error creating XML: %v", err)
+		}
+		dir := filepath.Join(tmpDir, "target", filepath.FromSlash(doi))
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatalf("Error creating dataset directory: %v", err)
+		}
+		for name, content := range map[string]string{"doi.xml": xmldata, archive: "archive data", "index.html": "landing page"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+				t.Fatalf("Error writing %s: %v", name, err)
+			}
+		}
+		if err := store.add(job); err != nil {
+			t.Fatalf("Error adding job: %v", err)
+		}
+		store.review(doi, jobReleased, "")
+		fake.dois[doi] = dataciteAttributes{XML: "x"}
+		fake.states[doi] = "findable"
+	}
+
+	if _, err := withdrawDataset(conf, dois[0], " ", false); err == nil {
+		t.Fatal("Withdrawal without a reason did not fail")
+	}
+	if _, err := withdrawDataset(conf, "10.12751/g-node.nothere", "Duplicate", false); err == nil {
+		t.Fatal("Withdrawal of unknown dataset did not fail")
+	}
+
+	// Archive is moved to quarantine
+	messages, err := withdrawDataset(conf, dois[0], "Published without consent of the co-authors", false)
+	if err != nil {
+		t.Fatalf("Error withdrawing dataset: %v (%v)", err, messages)
+	}
+	archive := "10.12751_g-node.aaaaaa.zip"
+	dir := filepath.Join(tmpDir, "target", "10.12751", "g-node.aaaaaa")
+	if _, err := os.Stat(filepath.Join(dir, archive)); !os.IsNotExist(err) {
+		t.Errorf("Archive was not removed: %v", err)
+	}
+	qdir := filepath.Join(tmpDir, "target", quarantinedir, "10.12751", "g-node.aaaaaa")
+	if data, err := ioutil.ReadFile(filepath.Join(qdir, archive)); err != nil || string(data) != "archive data" {
+		t.Errorf("Archive was not moved to quarantine: %q %v", data, err)
+	}
+	if public, _ := conf.Storage.Backend.IsPublic(quarantinedir + "/" + dois[0]); public {
+		t.Error("Quarantine directory is publicly accessible")
+	}
+
+	page, err := ioutil.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatalf("Error reading tombstone page: %v", err)
+	}
+	for _, expected := range []string{"This dataset has been withdrawn", "Published without consent of the co-authors", "Dataset " + dois[0], "Alice Doe", "Doe A (", "The abstract"} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("Tombstone page does not contain %q:\n%s", expected, page)
+		}
+	}
+	if strings.Contains(string(page), archive) {
+		t.Errorf("Tombstone page links to the archive:\n%s", page)
+	}
+
+	md, err := readStoredMetadata(conf.Storage.Backend, dois[0])
+	if err != nil {
+		t.Fatalf("Error reading metadata: %v", err)
+	}
+	if !isWithdrawn(md) || md.Sizes != nil || len(md.RelatedIdentifiers) != 1 || archiveURL(md) != "" {
+		t.Errorf("Unexpected metadata of withdrawn dataset: %+v", md.DataCite)
+	}
+	if notice := withdrawalNotice(md); !strings.HasSuffix(notice, ": Published without consent of the co-authors") {
+		t.Errorf("Unexpected withdrawal notice: %q", notice)
+	}
+	if refs := FormatReferences(md); len(refs) != 0 {
+		t.Errorf("Withdrawal notice shown as reference: %+v", refs)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, withdrawalfname))
+	if err != nil {
+		t.Fatalf("Error reading withdrawal marker: %v", err)
+	}
+	withdrawal := Withdrawal{}
+	if err := json.Unmarshal(data, &withdrawal); err != nil || withdrawal.DOI != dois[0] || len(withdrawal.Quarantine) != 1 {
+		t.Errorf("Unexpected withdrawal marker: %+v (%v)", withdrawal, err)
+	}
+	if fake.states[dois[0]] != "registered" {
+		t.Errorf("DOI was not hidden: %q", fake.states[dois[0]])
+	}
+	if rec, _ := store.get(dois[0]); rec.State != jobWithdrawn || rec.ReviewMessage != "Published without consent of the co-authors" {
+		t.Errorf("Unexpected job record of withdrawn dataset: %s %q", rec.State, rec.ReviewMessage)
+	}
+
+	if _, err := withdrawDataset(conf, dois[0], "Again", false); err == nil {
+		t.Error("Withdrawing a dataset twice did not fail")
+	}
+
+	// Archive is deleted
+	if _, err := withdrawDataset(conf, dois[1], "Duplicate of "+dois[0], true); err != nil {
+		t.Fatalf("Error purging dataset: %v", err)
+	}
+	if files, err := conf.Storage.Backend.List(quarantinedir + "/" + dois[1]); err == nil && len(files) != 0 {
+		t.Errorf("Purged archive was moved to quarantine: %+v", files)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "target", "10.12751", "g-node.bbbbbb", "10.12751_g-node.bbbbbb.zip")); !os.IsNotExist(err) {
+		t.Errorf("Purged archive was not removed: %v", err)
+	}
+}
@@ -9,6 +9,10 @@ import (
 	_ "expvar"
 	"log"
 	_ "net/http/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/G-Node/libgin/libgin"
 )
@@ -18,6 +22,14 @@ import (
 type RegistrationJob struct {
 	Metadata *libgin.RepositoryMetadata
 	Config   *Configuration
+	// Revision (commit hash or tag) of the repository to register; the
+	// default branch is used if empty
+	Revision string
+	// Commit hash of the registered revision; set when the repository is cloned
+	Commit string
+	// Interactive jobs are run by the register command and are not resumed
+	// by the service
+	Interactive bool
 }
 
 // newWorker creates a worker that waits for new jobs on its JobQueue starts a
@@ -38,6 +50,9 @@ type Worker struct {
 	JobQueue   chan *RegistrationJob
 	WorkerPool chan chan *RegistrationJob
 	QuitChan   chan bool
+	// Running keeps track of the jobs of all workers of a dispatcher; set
+	// by the dispatcher when the worker is started
+	Running *runningJobs
 }
 
 // start the worker and wait for jobs.
@@ -45,14 +60,33 @@ func (w *Worker) start() {
 	go func() {
 		for {
 			// Add my jobQueue to the worker pool.
-			w.WorkerPool <- w.JobQueue
+			select {
+			case w.WorkerPool <- w.JobQueue:
+			case <-w.QuitChan:
+				return
+			}
 			select {
 			case job := <-w.JobQueue:
+				if job == nil {
+					// The dispatcher has reduced the number of workers
+					log.Printf("Worker %d retired", w.ID)
+					return
+				}
 				// Dispatcher has added a job to my jobQueue
+				id := job.Metadata.Identifier.ID
+				if w.Running != nil && !w.Running.start(id) {
+					// The service is shutting down; the job is persisted
+					// and resumed after the restart
+					log.Printf("Worker %d not starting %q during shutdown", w.ID, id)
+					return
+				}
 				err := createRegisteredDataset(job)
 				if err != nil {
 					log.Printf("Encountered issue handling request: %q", err.Error())
 				}
+				if w.Running != nil {
+					w.Running.done(id)
+				}
 				log.Printf("Worker %d Completed %q!", w.ID, job.Metadata.SourceRepository)
 			case <-w.QuitChan:
 				// We have been asked to stop.
@@ -62,6 +96,75 @@ func (w *Worker) start() {
 	}()
 }
 
+// runningJobs keeps track of the jobs that are being processed by the workers
+// so that the service can wait for them to finish when shutting down.
+type runningJobs struct {
+	mutex   sync.Mutex
+	wg      sync.WaitGroup
+	ids     map[string]bool
+	stopped bool
+}
+
+// start registers a job as running. It returns false if no jobs may be
+// started because the workers are stopping.
+func (r *runningJobs) start(id string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stopped {
+		return false
+	}
+	if r.ids == nil {
+		r.ids = make(map[string]bool)
+	}
+	r.ids[id] = true
+	r.wg.Add(1)
+	return true
+}
+
+// count returns the number of running jobs.
+func (r *runningJobs) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.ids)
+}
+
+// done removes a finished job.
+func (r *runningJobs) done(id string) {
+	r.mutex.Lock()
+	delete(r.ids, id)
+	r.mutex.Unlock()
+	r.wg.Done()
+}
+
+// stop prevents new jobs from being started and waits for the running jobs to
+// finish for at most the given time. It returns the IDs of the jobs that were
+// still running when the time ran out.
+func (r *runningJobs) stop(timeout time.Duration) []string {
+	r.mutex.Lock()
+	r.stopped = true
+	r.mutex.Unlock()
+
+	finished := make(chan bool)
+	go func() {
+		r.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := make([]string, 0, len(r.ids))
+	for id := range r.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // newDispatcher creates and returns a new Dispatcher object that holds all
 // waiting jobs and sends the next job in the queue to the first available
 // worker.
@@ -72,39 +175,156 @@ func newDispatcher(jobQueue chan *RegistrationJob, maxWorkers int) *Dispatcher {
 		jobQueue:   jobQueue,
 		maxWorkers: maxWorkers,
 		workerPool: workerPool,
+		quit:       make(chan bool),
 	}
 }
 
 // Dispatcher holds waiting jobs and sends the next job in the queue to
 // the first available worker.
 type Dispatcher struct {
+	// Number of dispatched jobs waiting for a free worker; first field for
+	// the alignment required by the atomic operations
+	nwaiting   int64
 	workerPool chan chan *RegistrationJob
-	maxWorkers int
 	jobQueue   chan *RegistrationJob
+	running    runningJobs
+	// mutex protects the number and list of workers, which change when the
+	// worker pool is resized
+	mutex      sync.Mutex
+	maxWorkers int
+	workers    []Worker
+	makeWorker func(int, chan chan *RegistrationJob) Worker
+	// quit is closed when the dispatcher stops
+	quit     chan bool
+	stopOnce sync.Once
 }
 
 // run starts the dispatcher after creating and starting a new set of workers
 // (given the provided function and the predefined max workers).
 func (d *Dispatcher) run(makeWorker func(int, chan chan *RegistrationJob) Worker) {
+	d.mutex.Lock()
+	d.makeWorker = makeWorker
 	for i := 0; i < d.maxWorkers; i++ {
-		worker := makeWorker(i+1, d.workerPool)
-		worker.start()
+		d.startWorker()
 	}
+	d.mutex.Unlock()
 
 	go d.dispatch()
 }
 
+// startWorker creates and starts a new worker. The caller must hold the lock.
+func (d *Dispatcher) startWorker() {
+	worker := d.makeWorker(len(d.workers)+1, d.workerPool)
+	worker.Running = &d.running
+	worker.start()
+	d.workers = append(d.workers, worker)
+}
+
+// resize changes the number of workers. Additional workers are started right
+// away; surplus workers are retired as soon as they are idle, so that running
+// jobs are not interrupted.
+func (d *Dispatcher) resize(maxWorkers int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if maxWorkers < 1 || d.stopped() {
+		return
+	}
+	log.Printf("Resizing worker pool from %d to %d workers", d.maxWorkers, maxWorkers)
+	for ; d.maxWorkers < maxWorkers; d.maxWorkers++ {
+		d.startWorker()
+	}
+	if surplus := d.maxWorkers - maxWorkers; surplus > 0 {
+		d.maxWorkers = maxWorkers
+		go d.retire(surplus)
+	}
+}
+
+// retire stops the given number of workers when they become idle by sending
+// them an empty job.
+func (d *Dispatcher) retire(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case workerJobQueue := <-d.workerPool:
+			select {
+			case workerJobQueue <- nil:
+			case <-d.quit:
+				return
+			}
+		case <-d.quit:
+			return
+		}
+	}
+}
+
 func (d *Dispatcher) dispatch() {
 	//lint:ignore S1000 rewrite to suggested range syntax leads to loop variable i captured by func literal issue.
 	for {
 		select {
 		case job := <-d.jobQueue:
+			if d.stopped() {
+				// Keep consuming the queue so that submissions do not block;
+				// the jobs are persisted and resumed after the restart
+				log.Printf("Not dispatching %q during shutdown", job.Metadata.Identifier.ID)
+				continue
+			}
+			atomic.AddInt64(&d.nwaiting, 1)
 			go func() {
+				defer atomic.AddInt64(&d.nwaiting, -1)
 				log.Printf("Fetching workerJobQueue for %q", job.Metadata.SourceRepository)
-				workerJobQueue := <-d.workerPool
-				log.Printf("Adding %q to workerJobQueue", job.Metadata.SourceRepository)
-				workerJobQueue <- job
+				select {
+				case workerJobQueue := <-d.workerPool:
+					log.Printf("Adding %q to workerJobQueue", job.Metadata.SourceRepository)
+					select {
+					case workerJobQueue <- job:
+					case <-d.quit:
+					}
+				case <-d.quit:
+				}
 			}()
 		}
 	}
 }
+
+// waiting returns the number of jobs that were taken from the job queue and
+// are waiting for a free worker.
+func (d *Dispatcher) waiting() int {
+	return int(atomic.LoadInt64(&d.nwaiting))
+}
+
+// busy returns the number of workers that are processing a job.
+func (d *Dispatcher) busy() int {
+	return d.running.count()
+}
+
+// size returns the number of workers.
+func (d *Dispatcher) size() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.maxWorkers
+}
+
+// stopped returns true if the dispatcher has been stopped.
+func (d *Dispatcher) stopped() bool {
+	select {
+	case <-d.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop stops dispatching jobs and the workers and waits for the running jobs
+// to finish for at most the given time. Jobs that are waiting in the queue
+// are not started. It returns the IDs of the jobs that were still running
+// when the time ran out.
+func (d *Dispatcher) stop(timeout time.Duration) []string {
+	d.stopOnce.Do(func() {
+		close(d.quit)
+		d.mutex.Lock()
+		for _, worker := range d.workers {
+			close(worker.QuitChan)
+		}
+		d.mutex.Unlock()
+	})
+	return d.running.stop(timeout)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunningJobsStop(t *testing.T) {
+	// All jobs finish in time
+	running := &runningJobs{}
+	if !running.start("10.12751/g-node.aaaaaa") {
+		t.Fatal("Job was not started")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		running.done("10.12751/g-node.aaaaaa")
+	}()
+	if ids := running.stop(time.Second); len(ids) != 0 {
+		t.Fatalf("Unexpected interrupted jobs: %v", ids)
+	}
+	if running.start("10.12751/g-node.bbbbbb") {
+		t.Fatal("Job was started after stopping")
+	}
+
+	// Running jobs are reported when the time runs out
+	running = &runningJobs{}
+	running.start("10.12751/g-node.cccccc")
+	running.start("10.12751/g-node.aaaaaa")
+	running.done("10.12751/g-node.aaaaaa")
+	ids := running.stop(10 * time.Millisecond)
+	if len(ids) != 1 || ids[0] != "10.12751/g-node.cccccc" {
+		t.Fatalf("Unexpected interrupted jobs: %v", ids)
+	}
+}
+
+func TestDispatcherStop(t *testing.T) {
+	jobQueue := make(chan *RegistrationJob, 2)
+	dispatcher := newDispatcher(jobQueue, 2)
+	dispatcher.run(newWorker)
+	if dispatcher.stopped() {
+		t.Fatal("New dispatcher is stopped")
+	}
+	if ids := dispatcher.stop(time.Second); len(ids) != 0 {
+		t.Fatalf("Unexpected interrupted jobs: %v", ids)
+	}
+	if !dispatcher.stopped() {
+		t.Fatal("Dispatcher was not stopped")
+	}
+	// Stopping again is harmless and queued jobs are not dispatched
+	dispatcher.stop(time.Second)
+	jobQueue <- newTestJob("10.12751/g-node.aaaaaa", "owner/one")
+	jobQueue <- newTestJob("10.12751/g-node.bbbbbb", "owner/two")
+	jobQueue <- newTestJob("10.12751/g-node.cccccc", "owner/three")
+}
+
+func TestDispatcherResize(t *testing.T) {
+	jobQueue := make(chan *RegistrationJob, 2)
+	dispatcher := newDispatcher(jobQueue, 2)
+	dispatcher.run(newWorker)
+
+	dispatcher.resize(5)
+	if size := dispatcher.size(); size != 5 {
+		t.Fatalf("Unexpected number of workers after growing: %d", size)
+	}
+	dispatcher.resize(1)
+	if size := dispatcher.size(); size != 1 {
+		t.Fatalf("Unexpected number of workers after shrinking: %d", size)
+	}
+	// The surplus idle workers are retired
+	for idx := 0; idx < 100 && len(dispatcher.workerPool) != 1; idx++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if idle := len(dispatcher.workerPool); idle != 1 {
+		t.Errorf("Unexpected number of idle workers: %d", idle)
+	}
+	dispatcher.resize(0)
+	if size := dispatcher.size(); size != 1 {
+		t.Errorf("Worker pool resized to zero: %d", size)
+	}
+
+	if ids := dispatcher.stop(time.Second); len(ids) != 0 {
+		t.Fatalf("Unexpected interrupted jobs: %v", ids)
+	}
+	dispatcher.resize(3)
+	if size := dispatcher.size(); size != 1 {
+		t.Errorf("Stopped worker pool resized: %d", size)
+	}
+}
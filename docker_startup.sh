@@ -11,6 +11,7 @@ echo "Preparing 'assets' directory; overwriting any previous 'assets' changes."
 mkdir -p /doidata/assets
 cp -vr /assets/* /doidata/assets
 
-# Start DOI registration server
+# Start DOI registration server; exec so that the service receives the
+# stop signal of the container and can shut down gracefully
 echo "Starting DOI registration server"
-/gindoid start
+exec /gindoid start
@@ -0,0 +1,153 @@
+package gdtmpl
+
+// AdminJobList is the template for the curator overview of the registration
+// jobs that have not been released or rejected yet.
+const AdminJobList = `<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta charset="utf-8">
+		<meta http-equiv="X-UA-Compatible" content="IE=edge">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+
+		<meta name="robots" content="noindex,nofollow">
+
+		<link rel="shortcut icon" href="/assets/img/favicon.png">
+		<link rel="stylesheet" href="/assets/css/semantic-2.3.1.min.css">
+		<link rel="stylesheet" href="/assets/octicons-4.3.0/octicons.min.css">
+		<link rel="stylesheet" href="/assets/css/gogs.css">
+		<link rel="stylesheet" href="/assets/css/custom.css">
+
+		<title>G-Node DOI: Pending registrations</title>
+	</head>
+	<body>
+		<div class="full height">
+			{{template "Nav"}}
+			<div class="home middle very relaxed page grid" id="main">
+				<div class="ui container sixteen wide centered column doi">
+					<h1>Pending registrations</h1>
+					{{if .}}
+					<table class="ui very basic table">
+						<thead><tr><th>DOI</th><th>Repository</th><th>Requested by</th><th>State</th><th>Submitted</th><th>Warnings</th><th>Errors</th></tr></thead>
+						{{range .}}
+							<tr>
+								<td><a href="/admin/job/{{.ID}}">{{.ID}}</a></td>
+								<td><a href="{{GINServerURL}}/{{.Metadata.SourceRepository}}">{{.Metadata.SourceRepository}}</a></td>
+								<td>{{with .Metadata.RequestingUser}}{{.Username}}{{end}}</td>
+								<td>{{.State}}</td>
+								<td>{{.Created.Format "2006-01-02 15:04"}}</td>
+								<td>{{len .Warnings}}</td>
+								<td>{{len .Errors}}</td>
+							</tr>
+						{{end}}
+					</table>
+					{{else}}
+					<p>There are no pending registrations.</p>
+					{{end}}
+				</div>
+			</div>
+		</div>
+		{{template "Footer"}}
+	</body>
+</html>`
+
+// AdminJob is the template for the curator review page of a single
+// registration job.
+const AdminJob = `<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta charset="utf-8">
+		<meta http-equiv="X-UA-Compatible" content="IE=edge">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+
+		<meta name="robots" content="noindex,nofollow">
+
+		<link rel="shortcut icon" href="/assets/img/favicon.png">
+		<link rel="stylesheet" href="/assets/css/semantic-2.3.1.min.css">
+		<link rel="stylesheet" href="/assets/octicons-4.3.0/octicons.min.css">
+		<link rel="stylesheet" href="/assets/css/gogs.css">
+		<link rel="stylesheet" href="/assets/css/custom.css">
+
+		<title>G-Node DOI: Review {{.ID}}</title>
+	</head>
+	<body>
+		<div class="full height">
+			{{template "Nav"}}
+			<div class="home middle very relaxed page grid" id="main">
+				<div class="ui container sixteen wide centered column doi">
+					<p><a href="/admin/">&larr; Pending registrations</a></p>
+					<h1>Review {{.ID}}</h1>
+					{{if .Messages}}
+					<div class="ui info message">
+						<ul>{{range .Messages}}<li>{{.}}</li>{{end}}</ul>
+					</div>
+					{{end}}
+					<table class="ui very basic table">
+						<tr><td><strong>Repository</strong></td><td><a href="{{GINServerURL}}/{{.Metadata.SourceRepository}}">{{.Metadata.SourceRepository}}</a></td></tr>
+						{{with .Metadata.RequestingUser}}<tr><td><strong>Requested by</strong></td><td>{{.Username}}{{if .RealName}} ({{.RealName}}){{end}} &lt;{{.Email}}&gt;</td></tr>{{end}}
+						{{if .Revision}}<tr><td><strong>Revision</strong></td><td>{{.Revision}}{{if .Commit}} ({{.Commit}}){{end}}</td></tr>{{end}}
+						<tr><td><strong>State</strong></td><td>{{.State}}</td></tr>
+						<tr><td><strong>Submitted</strong></td><td>{{.Created.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+						{{if .ReviewMessage}}<tr><td><strong>Review message</strong></td><td>{{.ReviewMessage}}</td></tr>{{end}}
+					</table>
+					{{if .Errors}}
+					<div class="ui negative message">
+						<div class="header">Errors</div>
+						<ul>{{range .Errors}}<li>{{.}}</li>{{end}}</ul>
+					</div>
+					{{end}}
+					{{if .Warnings}}
+					<div class="ui warning message">
+						<div class="header">Warnings</div>
+						<ul>{{range .Warnings}}<li>{{.}}</li>{{end}}</ul>
+					</div>
+					{{end}}
+					{{if eq .State "done"}}
+					<div class="ui segment">
+						<form action="/admin/approve/{{.ID}}" method="post" class="ui form">
+							<button class="ui green button" type="submit">Approve and release</button>
+						</form>
+						<div class="ui divider"></div>
+						<form action="/admin/reject/{{.ID}}" method="post" class="ui form">
+							<div class="field">
+								<label for="message">Message to the requester (also posted to the XML repository issue)</label>
+								<textarea id="message" name="message" rows="4" required></textarea>
+							</div>
+							<button class="ui red button" type="submit">Reject</button>
+						</form>
+					</div>
+					{{end}}
+					{{if or (eq .State "released") (eq .State "embargoed")}}
+					<div class="ui segment">
+						<form action="/admin/withdraw/{{.ID}}" method="post" class="ui form">
+							<div class="field">
+								<label for="reason">Reason for the withdrawal (shown on the tombstone page that replaces the landing page)</label>
+								<textarea id="reason" name="reason" rows="3" required></textarea>
+							</div>
+							<div class="field">
+								<div class="ui checkbox">
+									<input type="checkbox" id="purge" name="purge" value="yes">
+									<label for="purge">Delete the archive instead of moving it to quarantine</label>
+								</div>
+							</div>
+							<button class="ui red button" type="submit">Withdraw</button>
+						</form>
+					</div>
+					{{end}}
+					{{if and (not .AllowNewVersion) (ne .State "failed") (ne .State "rejected") (ne .State "withdrawn")}}
+					<div class="ui segment">
+						<form action="/admin/allow-new-version/{{.ID}}" method="post" class="ui form">
+							<p>New submissions of this repository revision are shown this DOI instead of starting a new registration.</p>
+							<button class="ui button" type="submit">Allow a new version</button>
+						</form>
+					</div>
+					{{end}}
+					<h3>Landing page preview</h3>
+					<iframe src="/admin/preview/{{.ID}}" style="width: 100%; height: 600px; border: 1px solid #ddd;"></iframe>
+					<h3>DataCite XML</h3>
+					{{if .XML}}<pre style="white-space: pre-wrap;">{{.XML}}</pre>{{else}}<p>The XML file is not available.</p>{{end}}
+				</div>
+			</div>
+		</div>
+		{{template "Footer"}}
+	</body>
+</html>`
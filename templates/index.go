@@ -0,0 +1,95 @@
+package gdtmpl
+
+// DatasetIndex is the template for one page of the chronological HTML
+// listing of all published datasets.
+const DatasetIndex = `<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta charset="utf-8">
+		<meta http-equiv="X-UA-Compatible" content="IE=edge">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+
+		<link rel="shortcut icon" href="/assets/img/favicon.png">
+		<link rel="stylesheet" href="/assets/css/semantic-2.3.1.min.css">
+		<link rel="stylesheet" href="/assets/octicons-4.3.0/octicons.min.css">
+		<link rel="stylesheet" href="/assets/css/gogs.css">
+		<link rel="stylesheet" href="/assets/css/custom.css">
+		<link rel="alternate" type="application/atom+xml" title="G-Node Open Data: Latest datasets" href="/{{.FeedFile}}">
+
+		<title>G-Node Open Data{{if gt .Page 1}}: Page {{.Page}}{{end}}</title>
+	</head>
+	<body>
+		<div class="full height">
+			{{template "Nav"}}
+			<div class="home middle very relaxed page grid" id="main">
+				<div class="sixteen wide center aligned centered column">
+					<h1>G-Node Open Data</h1>
+					<h2>{{.Total}} Registered Dataset{{if ne .Total 1}}s{{end}}</h2>
+				</div>
+				<div class="ui container sixteen wide centered column doi">
+					<table class="ui very basic table">
+						<thead><tr> <th class="ten wide"></th><th class="two wide"></th> <th class="four wide"></th></tr></thead>
+						{{range $idx, $dataset := .Datasets}}
+							{{$title := index $dataset.Titles 0}}
+							{{$date := FormatIssuedDate $dataset}}
+							{{$doi := $dataset.Identifier.ID}}
+							{{$authors := FormatAuthorList $dataset}}
+							<tr><td><a href=https://doi.org/{{$doi}}>{{$title}}</a><br>{{$authors}}</td><td>{{$date}}</td> <td><a href=https://doi.org/{{$doi}}>{{$doi}}</a></td></tr>
+						{{end}}
+					</table>
+					{{if gt .NPages 1}}
+						<div class="ui center aligned container">
+							<div class="ui pagination menu">
+								{{if .Prev}}<a class="item" href="{{.Prev}}">&laquo;</a>{{end}}
+								{{range $idx, $page := .Pages}}
+									<a class="{{if eq $page.Number $.Page}}active {{end}}item" href="{{$page.File}}">{{$page.Number}}</a>
+								{{end}}
+								{{if .Next}}<a class="item" href="{{.Next}}">&raquo;</a>{{end}}
+							</div>
+						</div>
+					{{end}}
+				</div>
+			</div>
+		</div>
+		{{template "Footer"}}
+	</body>
+</html>`
+
+// Sitemap is the template for the sitemap.xml file listing the dataset
+// landing pages, the dataset index pages and the keyword pages. The XML
+// declaration is not part of the template since html/template escapes it.
+const Sitemap = `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+{{- range $idx, $url := .}}
+	<url>
+		<loc>{{$url.Loc}}</loc>
+		{{- if $url.LastMod}}
+		<lastmod>{{$url.LastMod}}</lastmod>
+		{{- end}}
+	</url>
+{{- end}}
+</urlset>
+`
+
+// AtomFeed is the template for the Atom feed of the latest published
+// datasets.
+const AtomFeed = `<feed xmlns="http://www.w3.org/2005/Atom">
+	<id>{{.ID}}</id>
+	<title>G-Node Open Data: Latest datasets</title>
+	<updated>{{.Updated}}</updated>
+	<link rel="self" href="{{.ID}}"/>
+	<link rel="alternate" href="{{.Home}}"/>
+	<author><name>G-Node</name></author>
+{{- range $idx, $entry := .Entries}}
+	<entry>
+		<id>{{$entry.ID}}</id>
+		<title>{{$entry.Title}}</title>
+		<updated>{{$entry.Updated}}</updated>
+		<link rel="alternate" href="{{$entry.Link}}"/>
+		{{- range $author := $entry.Authors}}
+		<author><name>{{$author}}</name></author>
+		{{- end}}
+		<summary>{{$entry.Summary}}</summary>
+	</entry>
+{{- end}}
+</feed>
+`
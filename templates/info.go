@@ -13,10 +13,13 @@ const DOIInfo = `
 	<p>
 	<a href="{{if .Identifier.ID}}https://doi.org/{{.Identifier.ID}}{{end}}" class="ui black doi label" itemprop="url">DOI: {{if .Identifier.ID}}{{.Identifier.ID}}{{else}}UNPUBLISHED{{end}}</a>
 	{{if .SourceRepository}}<a href="{{GINServerURL}}/{{.SourceRepository}}" class="ui blue doi label" data-tooltip="Browse the live dataset's contents on GIN. The repository may contain updates."><i class="doi label octicon octicon-link"></i>&nbsp;BROWSE REPOSITORY</a>{{end}}
-	{{if .ForkRepository}}<a href="{{GINServerURL}}/{{.ForkRepository}}" class="ui blue doi label" data-tooltip="Browse the archived dataset's contents on GIN. This is a snapshot of the published version."><i class="doi label octicon octicon-link"></i>&nbsp;BROWSE ARCHIVE</a>{{end}}
-	<a href="{{if .Identifier.ID}}{{Replace .Identifier.ID "/" "_"}}.zip{{end}}" class="ui green doi label"><i class="doi label octicon octicon-desktop-download"></i>&nbsp;DOWNLOAD ARCHIVE (ZIP{{if .Sizes}} {{index .Sizes 0}}{{end}})</a>
+	{{with EmbargoDate .}}<span class="ui grey doi label" data-tooltip="The data are under embargo and will be available for download from this date."><i class="doi label octicon octicon-clock"></i>&nbsp;AVAILABLE FROM {{.}}</span>
+	{{else}}{{if .ForkRepository}}<a href="{{GINServerURL}}/{{.ForkRepository}}" class="ui blue doi label" data-tooltip="Browse the archived dataset's contents on GIN. This is a snapshot of the published version."><i class="doi label octicon octicon-link"></i>&nbsp;BROWSE ARCHIVE</a>{{end}}
+	<a href="{{if .Identifier.ID}}{{ArchiveFilename .}}{{end}}" class="ui green doi label"><i class="doi label octicon octicon-desktop-download"></i>&nbsp;DOWNLOAD ARCHIVE ({{ArchiveType .}}{{if .Sizes}} {{index .Sizes 0}}{{end}})</a>
+	{{end}}
 	</p>
-	<p><strong>Published</strong> {{FormatIssuedDate .}} | <strong>License</strong> {{with index .RightsList 0}} <a href="{{.URL}}" itemprop="license">{{.Name}}</a>{{end}}</p>
+	<p><strong>Published</strong> {{FormatIssuedDate .}} | <strong>License</strong> {{with index .RightsList 0}} <a href="{{.URL}}" itemprop="license">{{.Name}}</a>{{end}}{{with RevisionLink .}} | <strong>Revision</strong> {{.}}{{end}}</p>
+	{{with ArchiveChecksum}}<p><strong>Archive SHA-256</strong> <code>{{.}}</code></p>{{end}}
 </div>
 <hr>
 
@@ -29,7 +32,7 @@ const DOIInfo = `
 
 {{if .Subjects}}
 	<h3>Keywords</h3>
-	| {{range $index, $kw := .Subjects}} <a href="/keywords/{{$kw | KeywordPath}}/">{{$kw}}</a> | {{end}}
+	| {{range $index, $kw := .Subjects}} <a href="/keywords/{{$kw | KeywordPath}}/">{{KeywordName $kw}}</a> | {{end}}
 	<meta itemprop="keywords" content="{{JoinComma .Subjects}}">
 {{end}}
 
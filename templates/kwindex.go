@@ -28,7 +28,7 @@ const KeywordIndex = `<!DOCTYPE html>
 
 			<div class="ui four column stackable grid container">
 				{{range $idx, $keyword := .KeywordList}}
-					<div class="column"><div class="ui"><a class="text bold" href="{{$keyword}}">{{$keyword}}</a> <span class="right">{{index $.KeywordMap $keyword | len}}</span></div></div>
+					<div class="column"><div class="ui"><a class="text bold" href="{{$keyword}}">{{if $.KeywordNames}}{{index $.KeywordNames $keyword}}{{else}}{{$keyword}}{{end}}</a> <span class="right">{{index $.KeywordMap $keyword | len}}</span></div></div>
 				{{end}}
 			</div>
 			</div>
@@ -15,6 +15,10 @@ const LandingPage = `<!DOCTYPE html>
 		<link rel="stylesheet" href="/assets/css/custom.css">
 
 		<title>G-Node Open Data: {{index .Titles 0}}</title>
+
+		<script type="application/ld+json">
+{{DatasetJSONLD . ArchiveChecksum}}
+		</script>
 	</head>
 	<body>
 		<div class="full height">
@@ -25,6 +29,10 @@ const LandingPage = `<!DOCTYPE html>
 						{{template "DOIInfo" .}}
 						<h3>Citation</h3>
 						{{FormatCitation .}}<br>
+						<p>
+						{{range CitationFormats}}<a href="{{.Filename}}" class="ui basic doi label" download><i class="doi label octicon octicon-desktop-download"></i>&nbsp;{{.Label}}</a>
+						{{end}}
+						</p>
 					</span>
 				</div>
 			</div>
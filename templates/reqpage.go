@@ -68,6 +68,7 @@ const RequestPage = `<!DOCTYPE html>
 							The following <strong>preview</strong> shows the information that will be published in the DOI registry and will be presented permanently alongside the data in your repository.
 							Please review it carefully before clicking the Request DOI button.
 							If anything needs to be changed use the Cancel button to return to your repository and edit the datacite.yml file.
+							{{if .Revision}}<p>The dataset will be published from revision <strong>{{.Revision}}</strong> of the repository.</p>{{end}}
 						</div>
 					</div>
 					<hr>
@@ -96,6 +97,7 @@ const RequestPage = `<!DOCTYPE html>
 					</div>
 					<form action="/submit" method="post">
 						<input type="hidden" id="reqdata" name="reqdata" value="{{.EncryptedRequestData}}">
+						<input type="hidden" id="csrftoken" name="csrftoken" value="{{.CSRFToken}}">
 						<div class="column center">
 							<a class="ui button" href={{GINServerURL}}/{{.Repository}}>Cancel</a>
 							<button class="ui green button" type="submit">Request DOI Now</button>
@@ -0,0 +1,62 @@
+package gdtmpl
+
+// JobStatus is the template for rendering the processing status of a
+// registration job.
+const JobStatus = `<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta charset="utf-8">
+		<meta http-equiv="X-UA-Compatible" content="IE=edge">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+
+		<meta name="robots" content="noindex,nofollow">
+
+		<link rel="shortcut icon" href="/assets/img/favicon.png">
+		<link rel="stylesheet" href="/assets/css/semantic-2.3.1.min.css">
+		<link rel="stylesheet" href="/assets/octicons-4.3.0/octicons.min.css">
+		<link rel="stylesheet" href="/assets/css/gogs.css">
+		<link rel="stylesheet" href="/assets/css/custom.css">
+
+		<title>G-Node DOI: Registration status {{.DOI}}</title>
+	</head>
+	<body>
+		<div class="full height">
+			{{template "Nav"}}
+			<div class="home middle very relaxed page grid" id="main">
+				<div class="ui container sixteen wide centered column doi">
+					<h1>Registration status</h1>
+					<table class="ui very basic table">
+						<tr><td><strong>DOI</strong></td><td>{{.DOI}}</td></tr>
+						{{if .Repository}}<tr><td><strong>Repository</strong></td><td><a href="{{GINServerURL}}/{{.Repository}}">{{.Repository}}</a></td></tr>{{end}}
+						<tr><td><strong>State</strong></td><td>{{.State}}</td></tr>
+						<tr><td><strong>Submitted</strong></td><td>{{.Created.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+						<tr><td><strong>Last update</strong></td><td>{{.Updated.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+						{{if .ArchiveSize}}<tr><td><strong>Archive size</strong></td><td>{{.ArchiveSize}} bytes</td></tr>{{end}}
+					</table>
+					{{if .Stages}}
+					<h3>Processing steps</h3>
+					<table class="ui very basic table">
+						<thead><tr><th>Step</th><th>Started</th><th>Finished</th></tr></thead>
+						{{range $idx, $stage := .Stages}}
+							<tr><td>{{$stage.Name}}</td><td>{{$stage.Started.Format "2006-01-02 15:04:05"}}</td><td>{{with $stage.Finished}}{{.Format "2006-01-02 15:04:05"}}{{else}}running{{end}}</td></tr>
+						{{end}}
+					</table>
+					{{end}}
+					{{if .Errors}}
+					<div class="ui negative message">
+						<div class="header">Errors</div>
+						<ul>{{range .Errors}}<li>{{.}}</li>{{end}}</ul>
+					</div>
+					{{end}}
+					{{if .Warnings}}
+					<div class="ui warning message">
+						<div class="header">Warnings</div>
+						<ul>{{range .Warnings}}<li>{{.}}</li>{{end}}</ul>
+					</div>
+					{{end}}
+				</div>
+			</div>
+		</div>
+		{{template "Footer"}}
+	</body>
+</html>`
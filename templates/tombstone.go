@@ -0,0 +1,59 @@
+package gdtmpl
+
+// Tombstone is the template for the page that replaces the landing page of a
+// withdrawn dataset. It keeps the citation and the basic metadata so that
+// the DOI still resolves to a description of what was published, but no
+// longer offers the data for download.
+const Tombstone = `<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta charset="utf-8">
+		<meta http-equiv="X-UA-Compatible" content="IE=edge">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+
+		<meta name="robots" content="noindex">
+
+		<link rel="shortcut icon" href="/assets/img/favicon.png">
+		<link rel="stylesheet" href="/assets/css/semantic-2.3.1.min.css">
+		<link rel="stylesheet" href="/assets/octicons-4.3.0/octicons.min.css">
+		<link rel="stylesheet" href="/assets/css/gogs.css">
+		<link rel="stylesheet" href="/assets/css/custom.css">
+
+		<title>G-Node Open Data: Withdrawn: {{index .Titles 0}}</title>
+	</head>
+	<body>
+		<div class="full height">
+			{{template "Nav"}}
+			<div class="home middle very relaxed page grid" id="main">
+				<div class="ui container sixteen wide centered column doi">
+					<div class="ui warning message">
+						<div class="header">This dataset has been withdrawn</div>
+						<p>{{.Notice}}</p>
+						<p>The data are no longer available from this service. The metadata and citation are kept so that references to the DOI remain resolvable.</p>
+					</div>
+					<div class="doi title">
+						<h2>{{.ResourceType.Value}}</h2>
+						<h1>{{index .Titles 0}}</h1>
+						{{AuthorBlock .Creators}}
+						<p>
+						<a href="https://doi.org/{{.Identifier.ID}}" class="ui black doi label">DOI: {{.Identifier.ID}}</a>
+						</p>
+						<p><strong>Published</strong> {{FormatIssuedDate .RepositoryMetadata}}{{with .RightsList}} | <strong>License</strong> {{with index . 0}}<a href="{{.URL}}">{{.Name}}</a>{{end}}{{end}}</p>
+					</div>
+					<hr>
+					{{with .Abstract}}
+						<h3>Description</h3>
+						<p>{{.}}</p>
+					{{end}}
+					<h3>Citation</h3>
+					{{FormatCitation .RepositoryMetadata}}<br>
+					<p>
+					{{range CitationFormats}}<a href="{{.Filename}}" class="ui basic doi label" download><i class="doi label octicon octicon-desktop-download"></i>&nbsp;{{.Label}}</a>
+					{{end}}
+					</p>
+				</div>
+			</div>
+		</div>
+		{{template "Footer"}}
+	</body>
+</html>`